@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// privilegedGroups are groups whose members can gain root, directly or through
+// the resources the group grants access to
+var privilegedGroups = []string{
+	"root", "wheel", "sudo", "admin", "adm", "docker", "lxd", "libvirt", "disk", "shadow",
+}
+
+// account is a local user from the passwd file
+type account struct {
+	name     string
+	uid      int
+	gid      int
+	home     string
+	shell    string
+	password string // none, locked or set, or empty if the shadow file is unreadable
+}
+
+// canLogin returns true if the shell of a allows interactive logins
+func (a *account) canLogin() bool {
+	switch filepath.Base(a.shell) {
+	case "nologin", "false":
+		return false
+	}
+	return true
+}
+
+// readAccounts returns the accounts in the passwd file of the filesystem at
+// root, with the password status from the shadow file if it can be read
+func readAccounts(root string) (ret []account, err error) {
+	fd, err := os.Open(resolveInRoot(root, "/etc/passwd"))
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		f := strings.Split(scn.Text(), ":")
+		if len(f) != 7 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		uid, err := strconv.Atoi(f[2])
+		if err != nil {
+			continue
+		}
+		gid, _ := strconv.Atoi(f[3])
+		shell := f[6]
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		ret = append(ret, account{name: f[0], uid: uid, gid: gid, home: f[5], shell: shell})
+	}
+	err = scn.Err()
+	if err != nil {
+		return
+	}
+	status, err := readPasswordStatus(root)
+	if err != nil {
+		if !os.IsNotExist(err) && !os.IsPermission(err) {
+			log.Printf("%v\n", err)
+		}
+		return ret, nil
+	}
+	for i := range ret {
+		ret[i].password = status[ret[i].name]
+	}
+	return ret, nil
+}
+
+// readPasswordStatus returns whether each account in the shadow file has a
+// password, no password, or is locked. The password hashes are not kept.
+func readPasswordStatus(root string) (map[string]string, error) {
+	fd, err := os.Open(resolveInRoot(root, "/etc/shadow"))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	ret := make(map[string]string)
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		f := strings.SplitN(scn.Text(), ":", 3)
+		if len(f) < 2 {
+			continue
+		}
+		switch {
+		case f[1] == "":
+			ret[f[0]] = "none"
+		case strings.HasPrefix(f[1], "!") || strings.HasPrefix(f[1], "*"):
+			ret[f[0]] = "locked"
+		default:
+			ret[f[0]] = "set"
+		}
+	}
+	return ret, scn.Err()
+}
+
+// group is a privileged group and its members
+type group struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// readPrivilegedGroups returns the privileged groups in the group file of the
+// filesystem at root. Members include the accounts with the group as their
+// primary group, which the group file does not list.
+func readPrivilegedGroups(root string, accounts []account) (ret []group, err error) {
+	fd, err := os.Open(resolveInRoot(root, "/etc/group"))
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	privileged := make(map[string]bool)
+	for _, g := range privilegedGroups {
+		privileged[g] = true
+	}
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		// name:password:gid:members
+		f := strings.Split(scn.Text(), ":")
+		if len(f) != 4 || !privileged[f[0]] {
+			continue
+		}
+		gid, err := strconv.Atoi(f[2])
+		if err != nil {
+			continue
+		}
+		g := group{name: f[0], gid: gid, members: []string{}}
+		seen := make(map[string]bool)
+		for _, m := range strings.Split(f[3], ",") {
+			if m != "" && !seen[m] {
+				seen[m] = true
+				g.members = append(g.members, m)
+			}
+		}
+		for _, a := range accounts {
+			if a.gid == gid && !seen[a.name] {
+				seen[a.name] = true
+				g.members = append(g.members, a.name)
+			}
+		}
+		sort.Strings(g.members)
+		ret = append(ret, g)
+	}
+	err = scn.Err()
+	return
+}
+
+// sudoersRule is a user specification or alias in a sudoers file
+type sudoersRule struct {
+	file     string
+	kind     string // rule or alias
+	rule     string
+	nopasswd bool
+}
+
+// maxSudoersDepth limits how deeply sudoers files may include each other
+const maxSudoersDepth = 8
+
+// readSudoers returns the rules in the sudoers file of the filesystem at root
+// and the files it includes. Defaults entries are not returned.
+func readSudoers(root string) (ret []sudoersRule, err error) {
+	err = readSudoersFile(root, "/etc/sudoers", 0, &ret)
+	return
+}
+
+// readSudoersFile adds the rules in the sudoers file at path, and any files it
+// includes, to rules
+func readSudoersFile(root, path string, depth int, rules *[]sudoersRule) error {
+	if depth > maxSudoersDepth {
+		return fmt.Errorf("%v: sudoers includes nested too deeply", path)
+	}
+	buf, err := ioutil.ReadFile(resolveInRoot(root, path))
+	if err != nil {
+		return err
+	}
+	var line string
+	for _, l := range strings.Split(string(buf), "\n") {
+		// Lines ending in a backslash continue on the next line
+		if strings.HasSuffix(l, "\\") {
+			line += strings.TrimSuffix(l, "\\") + " "
+			continue
+		}
+		line = strings.TrimSpace(line + l)
+		l, line = line, ""
+		f := strings.Fields(l)
+		if len(f) == 0 {
+			continue
+		}
+		switch f[0] {
+		case "#include", "@include", "#includedir", "@includedir":
+			if len(f) < 2 {
+				continue
+			}
+			inc := f[1]
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(filepath.Dir(path), inc)
+			}
+			if strings.HasSuffix(f[0], "dir") {
+				err = readSudoersDir(root, inc, depth+1, rules)
+			} else {
+				err = readSudoersFile(root, inc, depth+1, rules)
+			}
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("%v\n", err)
+			}
+			continue
+		}
+		l = strings.Join(strings.Fields(stripSudoersComment(l)), " ")
+		if l == "" || strings.HasPrefix(l, "Defaults") {
+			continue
+		}
+		kind := "rule"
+		if strings.HasSuffix(strings.Fields(l)[0], "_Alias") {
+			kind = "alias"
+		}
+		*rules = append(*rules, sudoersRule{
+			file:     path,
+			kind:     kind,
+			rule:     l,
+			nopasswd: strings.Contains(l, "NOPASSWD:"),
+		})
+	}
+	return nil
+}
+
+// readSudoersDir adds the rules in the files in an included sudoers directory
+// to rules. Like sudo, files whose names end in ~ or contain a dot are
+// skipped, so editor backups and package manager leftovers are not read.
+func readSudoersDir(root, dir string, depth int, rules *[]sudoersRule) error {
+	fis, err := ioutil.ReadDir(resolveInRoot(root, dir))
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || strings.HasSuffix(name, "~") || strings.Contains(name, ".") {
+			continue
+		}
+		err = readSudoersFile(root, filepath.Join(dir, name), depth, rules)
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+	return nil
+}
+
+// stripSudoersComment removes a comment from a sudoers line. A # followed by
+// a digit is a uid, as in #0, rather than the start of a comment.
+func stripSudoersComment(l string) string {
+	for i := 0; i < len(l); i++ {
+		if l[i] != '#' {
+			continue
+		}
+		if i+1 < len(l) && l[i+1] >= '0' && l[i+1] <= '9' {
+			continue
+		}
+		return strings.TrimSpace(l[:i])
+	}
+	return l
+}
+
+// sshKey is an entry in an authorized_keys file, identified by the
+// fingerprint of the key rather than the key itself
+type sshKey struct {
+	user        string
+	file        string
+	keyType     string
+	fingerprint string // SHA256 fingerprint, as printed by ssh-keygen -l
+	comment     string
+	options     []string // Names of the options restricting the key, such as from
+	interactive bool     // The account has a shell allowing interactive logins
+}
+
+// sshKeyTypes are the key types that may appear in an authorized_keys file
+var sshKeyTypes = map[string]bool{
+	"ssh-rsa":                            true,
+	"ssh-dss":                            true,
+	"ssh-ed25519":                        true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// readAuthorizedKeys returns the keys in the authorized_keys files of each
+// account in the filesystem at root. Keys of accounts without a login shell
+// are included, as sshd still accepts them for forwarding and forced
+// commands, but are marked as not interactive.
+func readAuthorizedKeys(root string, accounts []account) (ret []sshKey) {
+	files := authorizedKeysFiles(root)
+	for _, a := range accounts {
+		if a.home == "" {
+			continue
+		}
+		for _, f := range files {
+			path := strings.NewReplacer("%h", a.home, "%u", a.name, "%%", "%").Replace(f)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(a.home, path)
+			}
+			keys, err := readAuthorizedKeysFile(root, path, a.name)
+			if err != nil {
+				if !os.IsNotExist(err) && !os.IsPermission(err) {
+					log.Printf("%v\n", err)
+				}
+				continue
+			}
+			for i := range keys {
+				keys[i].interactive = a.canLogin()
+			}
+			ret = append(ret, keys...)
+		}
+	}
+	return
+}
+
+// authorizedKeysFiles returns the authorized keys files sshd reads, from the
+// AuthorizedKeysFile setting in sshd_config outside of any Match block
+func authorizedKeysFiles(root string) []string {
+	ret := []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}
+	fd, err := os.Open(resolveInRoot(root, "/etc/ssh/sshd_config"))
+	if err != nil {
+		return ret
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		f := strings.Fields(scn.Text())
+		if len(f) == 0 {
+			continue
+		}
+		switch strings.ToLower(f[0]) {
+		case "match":
+			return ret
+		case "authorizedkeysfile":
+			// sshd uses the first setting found
+			if len(f) > 1 && f[1] != "none" {
+				return f[1:]
+			}
+			return nil
+		}
+	}
+	return ret
+}
+
+// readAuthorizedKeysFile parses the authorized_keys file at path, which
+// belongs to user
+func readAuthorizedKeysFile(root, path, user string) (ret []sshKey, err error) {
+	fd, err := os.Open(resolveInRoot(root, path))
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	scn.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scn.Scan() {
+		l := strings.TrimSpace(scn.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		k, ok := parseAuthorizedKey(l)
+		if !ok {
+			log.Printf("%v: skipping unparseable key entry\n", path)
+			continue
+		}
+		k.user = user
+		k.file = path
+		ret = append(ret, k)
+	}
+	err = scn.Err()
+	return
+}
+
+// parseAuthorizedKey parses an authorized_keys entry, which is optional
+// options, the key type, the base64 key and an optional comment
+func parseAuthorizedKey(l string) (k sshKey, ok bool) {
+	f := splitAuthorizedKey(l)
+	for i := 0; i+1 < len(f); i++ {
+		if !sshKeyTypes[f[i]] {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(f[i+1])
+		if err != nil {
+			return
+		}
+		sum := sha256.Sum256(blob)
+		k.keyType = f[i]
+		k.fingerprint = "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+		k.comment = strings.Join(f[i+2:], " ")
+		k.options = []string{}
+		if i > 0 {
+			// Only the option names are kept, values such as commands may
+			// hold secrets
+			for _, o := range splitKeyOptions(f[0]) {
+				k.options = append(k.options, strings.SplitN(o, "=", 2)[0])
+			}
+		}
+		return k, true
+	}
+	return
+}
+
+// splitAuthorizedKey splits an authorized_keys entry on whitespace outside of
+// double quotes, which may appear in option values
+func splitAuthorizedKey(l string) (ret []string) {
+	var (
+		cur    []byte
+		quoted bool
+	)
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case (c == ' ' || c == '\t') && !quoted:
+			if len(cur) > 0 {
+				ret = append(ret, string(cur))
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, c)
+	}
+	if len(cur) > 0 {
+		ret = append(ret, string(cur))
+	}
+	return
+}
+
+// splitKeyOptions splits the comma separated options of an authorized_keys
+// entry, ignoring commas inside double quotes
+func splitKeyOptions(s string) (ret []string) {
+	var (
+		start  int
+		quoted bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				ret = append(ret, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(ret, s[start:])
+}
+
+// emitAccounts writes records for the local accounts, the members of
+// privileged groups, sudoers rules and authorized SSH keys of the filesystem
+// at root
+func emitAccounts(s sink, host logrus.Fields, root string) error {
+	accounts, err := readAccounts(root)
+	if err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		err = emit(s, withFields(host, logrus.Fields{
+			"rectype":  "account",
+			"username": a.name,
+			"uid":      a.uid,
+			"gid":      a.gid,
+			"home":     a.home,
+			"shell":    a.shell,
+			"login":    a.canLogin(),
+			"password": a.password,
+		}), fmt.Sprintf("account %v uid %v", a.name, a.uid))
+		if err != nil {
+			return err
+		}
+	}
+	groups, err := readPrivilegedGroups(root, accounts)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("%v\n", err)
+	}
+	for _, g := range groups {
+		err = emit(s, withFields(host, logrus.Fields{
+			"rectype":   "group",
+			"groupname": g.name,
+			"gid":       g.gid,
+			"members":   g.members,
+		}), fmt.Sprintf("group %v has %v members", g.name, len(g.members)))
+		if err != nil {
+			return err
+		}
+	}
+	rules, err := readSudoers(root)
+	if err != nil && !os.IsNotExist(err) && !os.IsPermission(err) {
+		log.Printf("%v\n", err)
+	}
+	for _, r := range rules {
+		err = emit(s, withFields(host, logrus.Fields{
+			"rectype":  "sudoers",
+			"file":     r.file,
+			"kind":     r.kind,
+			"rule":     r.rule,
+			"nopasswd": r.nopasswd,
+		}), fmt.Sprintf("sudoers %v in %v", r.kind, r.file))
+		if err != nil {
+			return err
+		}
+	}
+	for _, k := range readAuthorizedKeys(root, accounts) {
+		err = emit(s, withFields(host, logrus.Fields{
+			"rectype":     "sshkey",
+			"username":    k.user,
+			"file":        k.file,
+			"keytype":     k.keyType,
+			"fingerprint": k.fingerprint,
+			"comment":     k.comment,
+			"options":     k.options,
+			"interactive": k.interactive,
+		}), fmt.Sprintf("%v key %v for %v", k.keyType, k.fingerprint, k.user))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
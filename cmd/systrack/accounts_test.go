@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadAuthorizedKeys(t *testing.T) {
+	accounts, err := readAccounts("testdata/accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The git account has no login shell, but its key can still run the
+	// forced command
+	want := []sshKey{
+		{user: "alice", file: "/home/alice/.ssh/authorized_keys", keyType: "ssh-ed25519",
+			fingerprint: "SHA256:SxSTB635HWO8WFt+s/T399ZXFBS4CJpbnEhpF2PDukY",
+			comment:     "alice@laptop", options: []string{}, interactive: true},
+		{user: "git", file: "/home/git/.ssh/authorized_keys", keyType: "ssh-ed25519",
+			fingerprint: "SHA256:3y+lMpR2VwNVucqgybPC5DasHSFGzDh+Gb60iG6BNsY",
+			comment:     "deploy", options: []string{"command", "no-pty"}},
+	}
+	got := readAuthorizedKeys("testdata/accounts", accounts)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -7,11 +7,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -19,79 +19,153 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
-func getAWSMetadata() (instanceid, instancetype, localip, ami string, err error) {
-	instanceid, err = awsFetchMeta("instance-id")
+const (
+	// imdsTokenTTL is the lifetime requested for IMDSv2 session tokens
+	imdsTokenTTL = 6 * time.Hour
+	// imdsTokenSlack is how long before expiry a cached token is refreshed
+	imdsTokenSlack = time.Minute
+)
+
+// awsProvider fetches instance information from the EC2 instance metadata
+// service, and instance tags from the EC2 API
+//
+// Requests to the metadata service use IMDSv2 session tokens, falling back to
+// IMDSv1 only if allowIMDSv1 is set and a token could not be obtained.
+type awsProvider struct {
+	baseURL     string
+	ec2Endpoint string // If set, overrides the EC2 API endpoint used for tags
+	allowIMDSv1 bool   // If true, IMDSv1 may be used if IMDSv2 is not available
+
+	token       string    // Cached IMDSv2 session token
+	tokenExpiry time.Time // Time the cached token expires
+	useIMDSv1   bool      // Set once we have fallen back to IMDSv1
+}
+
+// awsIdentity includes the fields of the instance identity document we use
+type awsIdentity struct {
+	AccountID        string `json:"accountId"`
+	Region           string `json:"region"`
+	AvailabilityZone string `json:"availabilityZone"`
+	InstanceID       string `json:"instanceId"`
+	InstanceType     string `json:"instanceType"`
+	ImageID          string `json:"imageId"`
+	PrivateIP        string `json:"privateIp"`
+}
+
+func (a *awsProvider) name() string {
+	return "aws"
+}
+
+func (a *awsProvider) detect() bool {
+	_, err := a.getIdentity()
+	return err == nil
+}
+
+func (a *awsProvider) fetch() (info instanceInfo, err error) {
+	ident, err := a.getIdentity()
 	if err != nil {
 		return
 	}
-	instancetype, err = awsFetchMeta("instance-type")
+	info.id = ident.InstanceID
+	info.instanceType = ident.InstanceType
+	info.localIP = ident.PrivateIP
+	info.image = ident.ImageID
+	info.account = ident.AccountID
+	info.region = ident.Region
+	info.tags, err = a.getInstanceTags(info.id, info.region)
+	return
+}
+
+// getIdentity fetches the instance identity document, which provides the
+// instance details, region and account from a single signed source
+func (a *awsProvider) getIdentity() (ident awsIdentity, err error) {
+	buf, err := a.awsFetch("/latest/dynamic/instance-identity/document")
 	if err != nil {
 		return
 	}
-	localip, err = awsFetchMeta("local-ipv4")
+	err = json.Unmarshal([]byte(buf), &ident)
 	if err != nil {
 		return
 	}
-	ami, err = awsFetchMeta("ami-id")
+	if ident.InstanceID == "" || ident.Region == "" {
+		err = fmt.Errorf("instance identity document had no instance id or region")
+	}
 	return
 }
 
-func getAWSRegion() (region string, err error) {
-	region, err = awsFetchMeta("placement/availability-zone")
+// awsFetch requests path from the metadata service, including a session token
+// if one is in use
+func (a *awsProvider) awsFetch(path string) (string, error) {
+	hdr, err := a.tokenHeader()
 	if err != nil {
-		return
+		return "", err
 	}
-	// trim the last character that represents the availability zone
-	region = region[:len(region)-1]
-	return
+	result, err := fetchMeta(a.baseURL+path, hdr)
+	if e, ok := err.(metaStatusError); ok && e.code == http.StatusUnauthorized && hdr != nil {
+		// The token was rejected, so discard it and retry once with a new one
+		a.token = ""
+		hdr, err = a.tokenHeader()
+		if err != nil {
+			return "", err
+		}
+		result, err = fetchMeta(a.baseURL+path, hdr)
+	}
+	return result, err
 }
 
-func awsFetchMeta(endpoint string) (result string, err error) {
-	tr := &http.Transport{
-		Dial: (&net.Dialer{Timeout: time.Second}).Dial,
+// tokenHeader returns the headers needed to authenticate a metadata request,
+// which will be nil if we have fallen back to IMDSv1
+func (a *awsProvider) tokenHeader() (map[string]string, error) {
+	if a.useIMDSv1 {
+		return nil, nil
 	}
-	client := &http.Client{Transport: tr}
-	resp, err := client.Get("http://169.254.169.254:80/latest/meta-data/" + endpoint)
+	tok, err := a.getToken()
 	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("invalid HTTP response code returned by metadata service: %v",
-			resp.StatusCode)
-		return
+		if a.allowIMDSv1 {
+			a.useIMDSv1 = true
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to obtain IMDSv2 session token: %v", err)
 	}
-	if resp.ContentLength == -1 || resp.ContentLength > 10240 {
-		err = fmt.Errorf("invalid content length in response body")
-		return
+	return map[string]string{"X-aws-ec2-metadata-token": tok}, nil
+}
+
+// getToken returns the cached IMDSv2 session token, requesting a new one if it
+// is missing or about to expire
+func (a *awsProvider) getToken() (string, error) {
+	if a.token != "" && time.Now().Add(imdsTokenSlack).Before(a.tokenExpiry) {
+		return a.token, nil
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	ttl := strconv.Itoa(int(imdsTokenTTL / time.Second))
+	tok, err := metaRequest("PUT", a.baseURL+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": ttl})
 	if err != nil {
-		return
+		return "", err
 	}
-	result = string(body)
-	return
+	a.token = tok
+	a.tokenExpiry = time.Now().Add(imdsTokenTTL)
+	return a.token, nil
 }
 
-func getInstanceTags(instanceid string) (tags []string, err error) {
+func (a *awsProvider) getInstanceTags(instanceid, region string) (tags []string, err error) {
 	// do not read this code. it is unapologetically ugly.
 	akey := os.Getenv("AWS_ACCESS_KEY_ID")
 	skey := os.Getenv("AWS_SECRET_ACCESS_KEY")
 	origRegion := os.Getenv("AWS_REGION")
 	os.Setenv("AWS_SECRET_ACCESS_KEY", "")
 	os.Setenv("AWS_ACCESS_KEY_ID", "")
-	region, err := getAWSRegion()
-	if err != nil {
-		return
-	}
-	os.Setenv("AWS_REGION", region)
 	defer func() {
 		os.Setenv("AWS_ACCESS_KEY_ID", akey)
 		os.Setenv("AWS_SECRET_ACCESS_KEY", skey)
 		os.Setenv("AWS_REGION", origRegion)
 	}()
+	os.Setenv("AWS_REGION", region)
 
-	svc := ec2.New(session.New())
+	cfg := aws.NewConfig()
+	if a.ec2Endpoint != "" {
+		cfg = cfg.WithEndpoint(a.ec2Endpoint)
+	}
+	svc := ec2.New(session.New(), cfg)
 	input := &ec2.DescribeTagsInput{
 		Filters: []*ec2.Filter{
 			{
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+var azureHeaders = map[string]string{"Metadata": "true"}
+
+// azureAPIVersion is the version of the Azure Instance Metadata Service API we
+// request
+const azureAPIVersion = "2021-02-01"
+
+// azureProvider fetches instance information from the Azure Instance Metadata
+// Service
+type azureProvider struct {
+	baseURL string
+}
+
+// azureInstance includes the fields of the Azure instance document we use
+type azureInstance struct {
+	Compute struct {
+		VMID           string `json:"vmId"`
+		VMSize         string `json:"vmSize"`
+		Location       string `json:"location"`
+		SubscriptionID string `json:"subscriptionId"`
+		StorageProfile struct {
+			ImageReference struct {
+				ID        string `json:"id"`
+				Publisher string `json:"publisher"`
+				Offer     string `json:"offer"`
+				SKU       string `json:"sku"`
+				Version   string `json:"version"`
+			} `json:"imageReference"`
+		} `json:"storageProfile"`
+		TagsList []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"tagsList"`
+	} `json:"compute"`
+	Network struct {
+		Interface []struct {
+			IPv4 struct {
+				IPAddress []struct {
+					PrivateIPAddress string `json:"privateIpAddress"`
+				} `json:"ipAddress"`
+			} `json:"ipv4"`
+		} `json:"interface"`
+	} `json:"network"`
+}
+
+func (a *azureProvider) name() string {
+	return "azure"
+}
+
+func (a *azureProvider) detect() bool {
+	_, err := a.getInstance()
+	return err == nil
+}
+
+func (a *azureProvider) fetch() (info instanceInfo, err error) {
+	inst, err := a.getInstance()
+	if err != nil {
+		return
+	}
+	info.id = inst.Compute.VMID
+	info.instanceType = inst.Compute.VMSize
+	info.account = inst.Compute.SubscriptionID
+	info.region = inst.Compute.Location
+	ref := inst.Compute.StorageProfile.ImageReference
+	if ref.ID != "" {
+		// custom and shared gallery images are identified by resource id
+		info.image = ref.ID
+	} else if ref.Offer != "" {
+		info.image = fmt.Sprintf("%v:%v:%v:%v", ref.Publisher, ref.Offer, ref.SKU, ref.Version)
+	}
+	if len(inst.Network.Interface) > 0 && len(inst.Network.Interface[0].IPv4.IPAddress) > 0 {
+		info.localIP = inst.Network.Interface[0].IPv4.IPAddress[0].PrivateIPAddress
+	}
+	for _, t := range inst.Compute.TagsList {
+		info.tags = append(info.tags, fmt.Sprintf("%s=%s", t.Name, t.Value))
+	}
+	return
+}
+
+func (a *azureProvider) getInstance() (inst azureInstance, err error) {
+	buf, err := fetchMeta(a.baseURL+"/metadata/instance?api-version="+azureAPIVersion,
+		azureHeaders)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(buf), &inst)
+	if err != nil {
+		return
+	}
+	if inst.Compute.VMID == "" {
+		err = fmt.Errorf("azure instance metadata had no vmId")
+	}
+	return
+}
@@ -0,0 +1,21 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"debug/buildinfo"
+)
+
+// readGoBuildInfo returns the Go version and module information embedded in
+// the Go binary at path, which debug/buildinfo reads from binaries built by
+// any Go release that records module information, in any executable format
+func readGoBuildInfo(path string) (goVersion, modinfo string, err error) {
+	bi, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return
+	}
+	// The text form of the build information has the same mod, dep and =>
+	// lines as the module information embedded in the binary
+	return bi.GoVersion, bi.String(), nil
+}
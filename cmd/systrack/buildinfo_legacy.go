@@ -0,0 +1,73 @@
+//go:build !go1.18
+// +build !go1.18
+
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+)
+
+var errNotGoBinary = errors.New("not a Go binary")
+
+// goBuildInfoMagic marks the start of the build information embedded in Go
+// binaries
+var goBuildInfoMagic = []byte("\xff Go buildinf:")
+
+// readGoBuildInfo returns the Go version and module information embedded in
+// the ELF binary at path.
+//
+// This follows the format read by debug/buildinfo, which is only available
+// from Go 1.18, so is used when systrack is built with an older release such as
+// the one CI uses. Only the inline format written by Go 1.18 and later is
+// supported, as older toolchains stored pointers that would require resolving
+// addresses in the data segment, so binaries built before Go 1.18 are not
+// reported.
+func readGoBuildInfo(path string) (goVersion, modinfo string, err error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	sect := f.Section(".go.buildinfo")
+	if sect == nil {
+		err = errNotGoBinary
+		return
+	}
+	data, err := sect.Data()
+	if err != nil {
+		return
+	}
+	// The header is 32 bytes, the magic is followed by the pointer size and a
+	// flags byte, where bit 2 indicates the strings are stored inline
+	if len(data) < 32 || !bytes.HasPrefix(data, goBuildInfoMagic) || data[15]&2 == 0 {
+		err = errNotGoBinary
+		return
+	}
+	data = data[32:]
+	goVersion, data = readVarintString(data)
+	modinfo, _ = readVarintString(data)
+	if goVersion == "" {
+		err = errNotGoBinary
+		return
+	}
+	// Module information is wrapped in 16 byte sentinels
+	if len(modinfo) >= 33 && modinfo[len(modinfo)-17] == '\n' {
+		modinfo = modinfo[16 : len(modinfo)-16]
+	} else {
+		modinfo = ""
+	}
+	return
+}
+
+// readVarintString reads a string prefixed with its length as a uvarint,
+// returning the string and the remaining data
+func readVarintString(data []byte) (string, []byte) {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || n > uint64(len(data)-l) {
+		return "", nil
+	}
+	return string(data[l : l+int(n)]), data[l+int(n):]
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// metadataAddr is the link-local address most cloud platforms serve their
+// instance metadata services on
+const metadataAddr = "http://169.254.169.254"
+
+// maxMetaSize is the largest metadata response body we will accept
+const maxMetaSize = 65536
+
+// instanceInfo describes the instance a host is running as, as reported by
+// the metadata service of the platform it runs on
+type instanceInfo struct {
+	id           string
+	instanceType string
+	localIP      string
+	image        string
+	account      string
+	region       string
+	tags         []string
+}
+
+// metadataProvider fetches instance information from a cloud platform
+type metadataProvider interface {
+	// name returns the identifier of the provider, used in the cloud field of
+	// submitted records
+	name() string
+	// detect returns true if the host appears to be running on the platform
+	detect() bool
+	// fetch returns instance information for the host
+	fetch() (instanceInfo, error)
+}
+
+// newMetadataProvider returns the provider identified by name. If name is auto,
+// each known platform is probed and the first one detected is used, falling
+// back to a provider that reports no instance information.
+//
+// endpoint overrides the address of the metadata service if set, and
+// allowIMDSv1 permits the AWS provider to fall back to IMDSv1 requests if an
+// IMDSv2 session token cannot be obtained. static is the instance information
+// reported when no metadata service is used.
+func newMetadataProvider(name, endpoint string, allowIMDSv1 bool, static instanceInfo) (metadataProvider, error) {
+	if endpoint == "" {
+		endpoint = metadataAddr
+	}
+	providers := []metadataProvider{
+		// OpenStack also serves an EC2 compatible API, so check for it before
+		// AWS
+		&openstackProvider{baseURL: endpoint},
+		&gceProvider{baseURL: endpoint},
+		&azureProvider{baseURL: endpoint},
+		&awsProvider{baseURL: endpoint, allowIMDSv1: allowIMDSv1},
+	}
+	switch name {
+	case "auto":
+		for _, p := range providers {
+			if p.detect() {
+				return p, nil
+			}
+		}
+		return &staticProvider{info: static}, nil
+	case "none", "static":
+		return &staticProvider{info: static}, nil
+	}
+	for _, p := range providers {
+		if p.name() == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cloud provider %q", name)
+}
+
+// staticProvider is used on hosts that have no metadata service, such as bare
+// metal systems in a datacenter, and reports the instance information set in
+// the static section of the configuration file, if any
+type staticProvider struct {
+	info instanceInfo
+}
+
+func (s *staticProvider) name() string {
+	if s.info.id == "" && s.info.instanceType == "" && s.info.localIP == "" &&
+		s.info.image == "" && s.info.account == "" && s.info.region == "" && len(s.info.tags) == 0 {
+		return "none"
+	}
+	return "static"
+}
+
+func (s *staticProvider) detect() bool {
+	return true
+}
+
+func (s *staticProvider) fetch() (instanceInfo, error) {
+	return s.info, nil
+}
+
+var metaClient = &http.Client{
+	Transport: &http.Transport{
+		Dial: (&net.Dialer{Timeout: time.Second}).Dial,
+	},
+	Timeout: 5 * time.Second,
+}
+
+// metaStatusError is returned when a metadata service responds with a status
+// other than 200
+type metaStatusError struct {
+	code int
+}
+
+func (e metaStatusError) Error() string {
+	return fmt.Sprintf("invalid HTTP response code returned by metadata service: %v", e.code)
+}
+
+// fetchMeta requests url from a metadata service, including any headers in hdr,
+// and returns the response body
+func fetchMeta(url string, hdr map[string]string) (string, error) {
+	return metaRequest("GET", url, hdr)
+}
+
+// metaRequest makes a request using method to a metadata service, and returns
+// the response body
+func metaRequest(method, url string, hdr map[string]string) (result string, err error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return
+	}
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	resp, err := metaClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = metaStatusError{code: resp.StatusCode}
+		return
+	}
+	if resp.ContentLength > maxMetaSize {
+		err = fmt.Errorf("invalid content length in response body")
+		return
+	}
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxMetaSize + 1})
+	if err != nil {
+		return
+	}
+	if len(body) > maxMetaSize {
+		err = fmt.Errorf("metadata response body exceeds %v bytes", maxMetaSize)
+		return
+	}
+	result = string(body)
+	return
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// metaStandIn serves the metadata paths in docs, requiring the headers in hdr
+// on every request, and counts the requests made for each path
+type metaStandIn struct {
+	docs     map[string]string
+	hdr      map[string]string
+	requests map[string]int
+}
+
+func newMetaStandIn(docs, hdr map[string]string) (*metaStandIn, *httptest.Server) {
+	m := &metaStandIn{docs: docs, hdr: hdr, requests: make(map[string]int)}
+	return m, httptest.NewServer(m)
+}
+
+func (m *metaStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.requests[r.URL.RequestURI()]++
+	for k, v := range m.hdr {
+		if r.Header.Get(k) != v {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	doc, ok := m.docs[r.URL.RequestURI()]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(doc))
+}
+
+const awsIdentityDoc = `{"accountId": "123456789012", "region": "us-west-2",
+	"availabilityZone": "us-west-2b", "instanceId": "i-0abc", "instanceType": "m5.large",
+	"imageId": "ami-0def", "privateIp": "10.1.2.3"}`
+
+const awsIdentityPath = "/latest/dynamic/instance-identity/document"
+
+// imdsStandIn is an EC2 metadata service stand-in. If v2 is set it issues
+// session tokens and rejects requests without a valid one, and tokens issued
+// before expire is incremented are rejected with 401.
+type imdsStandIn struct {
+	v2       bool
+	v1       bool // Serve requests that have no token
+	tokens   int  // Tokens issued
+	expire   int  // Tokens up to this number are rejected
+	requests int  // Identity document requests
+}
+
+func (s *imdsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/latest/api/token" {
+		if !s.v2 || r.Method != "PUT" || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		s.tokens++
+		w.Write([]byte("token-" + strconv.Itoa(s.tokens)))
+		return
+	}
+	if r.URL.Path != awsIdentityPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.requests++
+	tok := r.Header.Get("X-aws-ec2-metadata-token")
+	n, _ := strconv.Atoi(strings.TrimPrefix(tok, "token-"))
+	if (tok == "" && !s.v1) || (tok != "" && n <= s.expire) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Write([]byte(awsIdentityDoc))
+}
+
+func TestAWSIdentity(t *testing.T) {
+	want := awsIdentity{
+		AccountID:        "123456789012",
+		Region:           "us-west-2",
+		AvailabilityZone: "us-west-2b",
+		InstanceID:       "i-0abc",
+		InstanceType:     "m5.large",
+		ImageID:          "ami-0def",
+		PrivateIP:        "10.1.2.3",
+	}
+	tests := []struct {
+		name        string
+		imds        imdsStandIn
+		allowIMDSv1 bool
+		wantErr     bool
+		wantTokens  int
+	}{
+		{"imdsv2", imdsStandIn{v2: true}, false, false, 1},
+		{"imdsv2 preferred", imdsStandIn{v2: true, v1: true}, true, false, 1},
+		{"imdsv1 fallback", imdsStandIn{v1: true}, true, false, 0},
+		{"imdsv1 not allowed", imdsStandIn{v1: true}, false, true, 0},
+	}
+	for _, tt := range tests {
+		ts := httptest.NewServer(&tt.imds)
+		a := &awsProvider{baseURL: ts.URL, allowIMDSv1: tt.allowIMDSv1}
+		ident, err := a.getIdentity()
+		ts.Close()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if ident != want {
+			t.Errorf("%v: got identity %+v, want %+v", tt.name, ident, want)
+		}
+		if tt.imds.tokens != tt.wantTokens {
+			t.Errorf("%v: %v tokens requested, want %v", tt.name, tt.imds.tokens, tt.wantTokens)
+		}
+	}
+}
+
+func TestAWSTokenReuseAndRetry(t *testing.T) {
+	imds := &imdsStandIn{v2: true}
+	ts := httptest.NewServer(imds)
+	defer ts.Close()
+	a := &awsProvider{baseURL: ts.URL}
+	for i := 0; i < 3; i++ {
+		if _, err := a.getIdentity(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if imds.tokens != 1 {
+		t.Errorf("cached token not reused, %v tokens requested", imds.tokens)
+	}
+
+	// A rejected token is replaced and the request retried once
+	imds.expire = 1
+	if _, err := a.getIdentity(); err != nil {
+		t.Fatalf("request not retried with a new token: %v", err)
+	}
+	if imds.tokens != 2 || imds.requests != 5 {
+		t.Errorf("got %v tokens and %v requests after retry, want 2 and 5", imds.tokens, imds.requests)
+	}
+
+	// The retry is only made once
+	imds.expire = 9
+	if _, err := a.getIdentity(); err == nil {
+		t.Errorf("expected an error when the new token is also rejected")
+	}
+	if imds.requests != 7 {
+		t.Errorf("got %v requests, want 7", imds.requests)
+	}
+}
+
+func TestGCEProvider(t *testing.T) {
+	p := "/computeMetadata/v1/"
+	_, ts := newMetaStandIn(map[string]string{
+		p + "instance/id":                      "4567",
+		p + "instance/machine-type":            "projects/1234/machineTypes/n1-standard-1",
+		p + "instance/network-interfaces/0/ip": "10.128.0.2",
+		p + "instance/image":                   "projects/debian-cloud/global/images/debian-12-bookworm-v20240110",
+		p + "project/project-id":               "inventory-prod",
+		p + "instance/zone":                    "projects/1234/zones/us-central1-a",
+		p + "instance/tags?alt=json":           `["web","https-server"]`,
+	}, gceHeaders)
+	defer ts.Close()
+	g := &gceProvider{baseURL: ts.URL}
+	if !g.detect() {
+		t.Fatal("gce not detected")
+	}
+	info, err := g.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := instanceInfo{
+		id:           "4567",
+		instanceType: "n1-standard-1",
+		localIP:      "10.128.0.2",
+		image:        "debian-12-bookworm-v20240110",
+		account:      "inventory-prod",
+		region:       "us-central1",
+		tags:         []string{"web", "https-server"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+}
+
+func TestAzureProvider(t *testing.T) {
+	_, ts := newMetaStandIn(map[string]string{
+		"/metadata/instance?api-version=" + azureAPIVersion: `{
+			"compute": {
+				"vmId": "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+				"vmSize": "Standard_D2s_v3",
+				"location": "westeurope",
+				"subscriptionId": "8d10da13-8125-4ba9-a717-bf7490507b3d",
+				"storageProfile": {"imageReference": {"publisher": "Canonical",
+					"offer": "0001-com-ubuntu-server-jammy", "sku": "22_04-lts", "version": "latest"}},
+				"tagsList": [{"name": "app", "value": "web"}]
+			},
+			"network": {"interface": [{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4"}]}}]}
+		}`,
+	}, azureHeaders)
+	defer ts.Close()
+	a := &azureProvider{baseURL: ts.URL}
+	if !a.detect() {
+		t.Fatal("azure not detected")
+	}
+	info, err := a.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := instanceInfo{
+		id:           "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+		instanceType: "Standard_D2s_v3",
+		localIP:      "10.0.0.4",
+		image:        "Canonical:0001-com-ubuntu-server-jammy:22_04-lts:latest",
+		account:      "8d10da13-8125-4ba9-a717-bf7490507b3d",
+		region:       "westeurope",
+		tags:         []string{"app=web"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+}
+
+func TestOpenStackProvider(t *testing.T) {
+	meta := map[string]string{
+		"/openstack/latest/meta_data.json": `{"uuid": "d8e02d56-2648-49a3-bf97-6be8f1204f38",
+			"project_id": "f7ac731cc11f40efbc03a9f9e1d1d21f", "meta": {"role": "db", "app": "inventory"}}`,
+	}
+	_, ts := newMetaStandIn(meta, nil)
+	o := &openstackProvider{baseURL: ts.URL}
+	if !o.detect() {
+		t.Fatal("openstack not detected")
+	}
+	// Without the EC2 compatible API the flavor, address and image are empty
+	info, err := o.fetch()
+	ts.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := instanceInfo{
+		id:      "d8e02d56-2648-49a3-bf97-6be8f1204f38",
+		account: "f7ac731cc11f40efbc03a9f9e1d1d21f",
+		tags:    []string{"app=inventory", "role=db"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+
+	meta["/latest/meta-data/instance-type"] = "m1.small"
+	meta["/latest/meta-data/local-ipv4"] = "192.168.0.10"
+	meta["/latest/meta-data/ami-id"] = "ami-00000002"
+	_, ts = newMetaStandIn(meta, nil)
+	defer ts.Close()
+	o.baseURL = ts.URL
+	info, err = o.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.instanceType = "m1.small"
+	want.localIP = "192.168.0.10"
+	want.image = "ami-00000002"
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+}
+
+func TestMetadataAutoDetect(t *testing.T) {
+	static := instanceInfo{id: "db-07", region: "mdc1", tags: []string{"rack=r12"}}
+	tests := []struct {
+		name string
+		docs map[string]string
+		want string
+	}{
+		{"openstack before aws", map[string]string{
+			"/openstack/latest/meta_data.json": `{"uuid": "d8e02d56"}`,
+			awsIdentityPath:                    awsIdentityDoc,
+		}, "openstack"},
+		{"aws imdsv1", map[string]string{awsIdentityPath: awsIdentityDoc}, "aws"},
+		{"nothing found", map[string]string{}, "static"},
+	}
+	for _, tt := range tests {
+		_, ts := newMetaStandIn(tt.docs, nil)
+		p, err := newMetadataProvider("auto", ts.URL, true, static)
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.name() != tt.want {
+			t.Errorf("%v: detected %v, want %v", tt.name, p.name(), tt.want)
+		}
+	}
+}
+
+func TestStaticProvider(t *testing.T) {
+	p, err := newMetadataProvider("none", "", false, instanceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.name() != "none" {
+		t.Errorf("provider with no static details named %v, want none", p.name())
+	}
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := writeFile(t, dir, "systrack.yaml", strings.Join([]string{
+		"cloud: none",
+		"static:",
+		"  instanceid: db-07",
+		"  localip: 10.20.0.7",
+		"  region: mdc1",
+		"  tags:",
+		"    rack: r12",
+		"    app: inventory",
+	}, "\n"))
+	var o options
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	setFlags(fs, &o)
+	if err := parseOptions(fs, []string{"-config", path}, &o); err != nil {
+		t.Fatal(err)
+	}
+	p, err = newMetadataProvider(o.cloud, o.metadataEndpoint, o.imdsv1, o.static)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := p.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := instanceInfo{id: "db-07", localIP: "10.20.0.7", region: "mdc1",
+		tags: []string{"app=inventory", "rack=r12"}}
+	if p.name() != "static" || !reflect.DeepEqual(info, want) {
+		t.Errorf("got %v %+v, want static %+v", p.name(), info, want)
+	}
+}
+
+func TestMetaRequestLimits(t *testing.T) {
+	_, ts := newMetaStandIn(map[string]string{
+		"/big": strings.Repeat("x", maxMetaSize+1),
+	}, nil)
+	defer ts.Close()
+	if _, err := fetchMeta(ts.URL+"/big", nil); err == nil {
+		t.Errorf("oversized metadata response accepted")
+	}
+	_, err := fetchMeta(ts.URL+"/missing", nil)
+	if e, ok := err.(metaStatusError); !ok || e.code != http.StatusNotFound {
+		t.Errorf("got %v for a missing document, want a 404 status error", err)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// collector gathers one kind of inventory and writes its records to the
+// output. In one-shot mode every enabled collector runs, in daemon mode each
+// collector runs on its own schedule.
+type collector struct {
+	name     string
+	interval time.Duration // Default interval in daemon mode
+	enabled  func(o *options) bool
+	collect  func(r *run) error
+}
+
+// collectors lists the available collectors, in the order they run
+var collectors = []collector{
+	{
+		name:     "packages",
+		interval: time.Hour,
+		enabled:  func(o *options) bool { return true },
+		collect:  collectPackages,
+	},
+	{
+		name:     "lang",
+		interval: 24 * time.Hour,
+		enabled:  func(o *options) bool { return o.lang },
+		collect: func(r *run) error {
+			return r.reportPackages(langStatePath(r.opts.statePath), getLangPackages(r.opts.root), nil)
+		},
+	},
+	{
+		name:     "containers",
+		interval: 24 * time.Hour,
+		enabled:  func(o *options) bool { return o.containers },
+		collect: func(r *run) error {
+			// Hosts without a running container runtime are common, so
+			// this does not fail the run
+			err := emitContainers(r.out, r.host, r.opts.format)
+			if err != nil {
+				log.Printf("failed to inventory containers: %v\n", err)
+			}
+			return nil
+		},
+	},
+	{
+		name:     "sockets",
+		interval: time.Hour,
+		enabled:  func(o *options) bool { return o.sockets },
+		collect:  collectSockets,
+	},
+	{
+		name:     "restart",
+		interval: time.Hour,
+		enabled:  func(o *options) bool { return o.restart },
+		collect:  collectRestart,
+	},
+	{
+		name:     "accounts",
+		interval: 24 * time.Hour,
+		enabled:  func(o *options) bool { return o.accounts },
+		collect: func(r *run) error {
+			return emitAccounts(r.out, r.host, r.opts.root)
+		},
+	},
+	{
+		name:     "services",
+		interval: 24 * time.Hour,
+		enabled:  func(o *options) bool { return o.services },
+		collect: func(r *run) error {
+			return emitServices(r.out, r.host, getServices(r.opts.root, r.fileOwners()))
+		},
+	},
+	{
+		name:     "fileaudit",
+		interval: 24 * time.Hour,
+		enabled:  func(o *options) bool { return o.fileAudit },
+		collect: func(r *run) error {
+			audit := auditFiles(r.opts.root, strings.Split(r.opts.auditPaths, ","),
+				r.opts.auditBudget, r.opts.auditRate, r.fileOwners())
+			return emitFileAudit(r.out, r.host, audit)
+		},
+	},
+	{
+		name:     "policy",
+		interval: 24 * time.Hour,
+		enabled:  func(o *options) bool { return o.policy != "" },
+		collect:  collectPolicy,
+	},
+}
+
+// enabledCollectors returns the collectors selected by the options
+func enabledCollectors(o *options) (ret []collector) {
+	for _, c := range collectors {
+		if c.enabled(o) {
+			ret = append(ret, c)
+		}
+	}
+	return
+}
+
+// run holds what the collectors share during a single run
+type run struct {
+	opts    *options
+	out     sink
+	host    logrus.Fields        // Fields included in every record
+	pkgs    []scribe.PackageInfo // System packages
+	sources pkgSources           // Source packages of pkgs
+	ki      kernelInfo           // Running kernel
+
+	// States to save by path once the run is delivered
+	states map[string]*inventoryState
+
+	owners fileOwners // Package owning each file, loaded on first use
+}
+
+// fileOwners returns the package owning each file installed by a system
+// package, reading the package databases on the first call
+func (r *run) fileOwners() fileOwners {
+	if r.owners == nil {
+		r.owners = getFileOwners(r.opts.root)
+	}
+	return r.owners
+}
+
+// runCollectors gathers the host details and system packages, runs each of
+// the collectors cs and delivers their records. A collector that fails does
+// not stop the others, and the first failure is returned once the records of
+// the others have been delivered.
+func runCollectors(o *options, provider metadataProvider, cs []collector) error {
+	out, err := o.newOutput()
+	if err != nil {
+		return err
+	}
+	r := &run{opts: o, out: out, states: make(map[string]*inventoryState)}
+	err = r.setup(provider)
+	if err != nil {
+		out.close()
+		return err
+	}
+	var cerr error
+	for _, c := range cs {
+		err = c.collect(r)
+		if err != nil && cerr == nil {
+			cerr = fmt.Errorf("%v collector failed: %v", c.name, err)
+		} else if err != nil {
+			log.Printf("%v collector failed: %v\n", c.name, err)
+		}
+	}
+	err = out.close()
+	if err != nil {
+		return err
+	}
+	// Only update the state once the records have been delivered, so changes
+	// are reported again if this run fails
+	for path, st := range r.states {
+		st.LastRun = time.Now().UTC()
+		err = saveState(path, st)
+		if err != nil {
+			return err
+		}
+	}
+	return cerr
+}
+
+// setup collects the host fields included in every record, and the system
+// packages, which are needed to identify the running kernel
+func (r *run) setup(provider metadataProvider) error {
+	fqdn := getHostname()
+	issue, err := getSysInfo(r.opts.root)
+	if err != nil {
+		if r.opts.root == "/" {
+			return err
+		}
+		// Images and chroots often have neither os-release nor issue,
+		// and are still reported
+		log.Printf("%v\n", err)
+	}
+	dist, err := getDist(r.opts.root)
+	if err != nil {
+		// Still report the host, the lambda will skip records with no
+		// distribution when checking for vulnerabilities
+		log.Printf("%v\n", err)
+	}
+	inst, err := provider.fetch()
+	if err != nil {
+		return err
+	}
+	// get a list of all system packages
+	r.pkgs, r.sources = getRootPackages(r.opts.root)
+	if len(r.pkgs) == 0 && r.opts.root == "/" {
+		// No database we can read natively, try the package manager
+		// commands instead
+		r.pkgs = scribe.QueryPackages()
+	}
+	if r.opts.root == "/" {
+		// The running kernel is only meaningful for the live system
+		r.ki, err = getKernelInfo(r.pkgs)
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+	r.host = logrus.Fields{
+		"fqdn":         fqdn,
+		"dist":         dist,
+		"issue":        issue,
+		"cloud":        provider.name(),
+		"instanceid":   inst.id,
+		"instancetype": inst.instanceType,
+		"instancetags": inst.tags,
+		"localip":      inst.localIP,
+		"ami":          inst.image,
+		"account":      inst.account,
+		"region":       inst.region,
+	}
+	if r.ki.release != "" {
+		r.host["kernel"] = r.ki.release
+		r.host["boottime"] = r.ki.bootTime
+		r.host["rebootrequired"] = r.ki.rebootRequired
+	}
+	if len(r.opts.tags) > 0 {
+		// Static tags are sent with the instance tags, where the lambda
+		// looks for the app tag
+		r.host["instancetags"] = mergeTags(inst.tags, r.opts.tags)
+	}
+	// The allowlist is applied last, so no field it leaves out is added back
+	if len(r.opts.fields) > 0 {
+		keep := make(map[string]bool)
+		for _, f := range append(r.opts.fields, requiredHostFields...) {
+			keep[f] = true
+		}
+		for k := range r.host {
+			if !keep[k] {
+				delete(r.host, k)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeTags returns the instance tags in tags, which are name=value strings,
+// with the static tags in static added, replacing any instance tags of the
+// same name
+func mergeTags(tags []string, static map[string]string) []string {
+	var ret []string
+	for _, t := range tags {
+		name := strings.SplitN(t, "=", 2)[0]
+		if _, ok := static[name]; !ok {
+			ret = append(ret, t)
+		}
+	}
+	for k, v := range static {
+		ret = append(ret, k+"="+v)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// collectPackages reports the running kernel and the system packages, either
+// in full or as the changes since the last run if a state file is used
+func collectPackages(r *run) error {
+	if r.ki.release != "" && r.ki.pkg.Name == "" {
+		// Such as a custom built kernel, which the lambda has nothing to
+		// check against
+		log.Printf("no package found for running kernel %v, not sending a kernel record\n",
+			r.ki.release)
+	} else if r.ki.release != "" {
+		err := emitKernel(r.out, r.host, r.ki, r.sources)
+		if err != nil {
+			return err
+		}
+	}
+	return r.reportPackages(r.opts.statePath, r.pkgs, r.sources)
+}
+
+// langStatePath returns the path of the state file for language packages,
+// which are kept apart from the system packages as they are collected on
+// their own schedule
+func langStatePath(statePath string) string {
+	if statePath == "" {
+		return ""
+	}
+	return statePath + ".lang"
+}
+
+// reportPackages reports pkgs, built from sources, in full, or if statePath is
+// set, as the changes since they were last reported according to the state
+// file at statePath
+func (r *run) reportPackages(statePath string, pkgs []scribe.PackageInfo, sources pkgSources) error {
+	var (
+		st  *inventoryState
+		err error
+	)
+	if statePath != "" {
+		st, err = loadState(statePath)
+		if err != nil {
+			// Fall back to a full report, which will replace the state
+			log.Printf("%v\n", err)
+			st = nil
+		}
+	}
+	if st != nil && st.Runs+1 < r.opts.fullEvery {
+		err = emitChanges(r.out, r.host, st, snapshotPkgs(pkgs, sources))
+		st.Runs++
+	} else {
+		err = emitInventory(r.out, r.host, r.opts.format, pkgs, sources)
+		st = &inventoryState{}
+	}
+	if err != nil {
+		return err
+	}
+	if statePath != "" {
+		st.Packages = snapshotPkgs(pkgs, sources)
+		r.states[statePath] = st
+	}
+	return nil
+}
+
+// collectSockets reports the listening sockets of the host. Sockets can only
+// be inspected on the live system, so nothing is reported with a root other
+// than /.
+func collectSockets(r *run) error {
+	if r.opts.root != "/" {
+		log.Printf("not reporting sockets when inventorying %v\n", r.opts.root)
+		return nil
+	}
+	sockets, err := getListenSockets(r.fileOwners())
+	if err != nil {
+		return err
+	}
+	return emitSockets(r.out, r.host, sockets, r.sources)
+}
+
+// collectRestart reports processes still running the code of an upgraded
+// package. Like sockets, processes can only be inspected on the live system.
+func collectRestart(r *run) error {
+	if r.opts.root != "/" {
+		log.Printf("not reporting processes when inventorying %v\n", r.opts.root)
+		return nil
+	}
+	return emitStaleProcesses(r.out, r.host, getStaleProcesses(r.fileOwners(), getUpgradedVersions()),
+		r.sources)
+}
+
+// collectPolicy evaluates the scribe policy documents. scribe examines the
+// files and packages of the live system, so nothing is evaluated with a root
+// other than /.
+func collectPolicy(r *run) error {
+	if r.opts.policy == "" {
+		return fmt.Errorf("no policy documents configured")
+	}
+	if r.opts.root != "/" {
+		log.Printf("not evaluating policies when inventorying %v\n", r.opts.root)
+		return nil
+	}
+	return emitPolicies(r.out, r.host, r.opts.policy)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetupHostFields(t *testing.T) {
+	p, err := newMetadataProvider("none", "", false, instanceInfo{id: "db-07",
+		tags: []string{"app=inventory", "rack=r12"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The allowlist does not name fqdn or dist, which the lambda needs, and
+	// the static app tag replaces the instance one
+	o := &options{root: "testdata/dist/debian12", fields: []string{"instanceid", "instancetags"},
+		tags: map[string]string{"app": "shop", "env": "prod"}}
+	r := &run{opts: o}
+	if err := r.setup(p); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range r.host {
+		keys = append(keys, k)
+	}
+	for _, k := range []string{"fqdn", "dist", "instanceid", "instancetags"} {
+		if _, ok := r.host[k]; !ok {
+			t.Errorf("no %v in host fields %v", k, keys)
+		}
+	}
+	if len(r.host) != 4 {
+		t.Errorf("got host fields %v, want 4", keys)
+	}
+	if r.host["dist"] != "debian:12" {
+		t.Errorf("dist is %v, want debian:12", r.host["dist"])
+	}
+	want := []string{"app=shop", "env=prod", "rack=r12"}
+	if got := r.host["instancetags"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("instancetags are %v, want %v", got, want)
+	}
+
+	// Static tags do not add instancetags back when the allowlist leaves
+	// it out
+	o.fields = []string{"instanceid"}
+	r = &run{opts: o}
+	if err := r.setup(p); err != nil {
+		t.Fatal(err)
+	}
+	if tags, ok := r.host["instancetags"]; ok {
+		t.Errorf("instancetags %v sent without being in the allowlist", tags)
+	}
+}
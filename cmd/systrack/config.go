@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// hostFields are the fields describing the host included in every record,
+// which the fields setting in the configuration file can select from
+var hostFields = []string{
+	"fqdn", "dist", "issue", "cloud", "instanceid", "instancetype", "instancetags",
+	"localip", "ami", "account", "region", "kernel", "boottime", "rebootrequired",
+}
+
+// requiredHostFields are always included whatever the fields setting, as the
+// lambda needs them to check the records
+var requiredHostFields = []string{"fqdn", "dist"}
+
+// unknownSettingRe matches the error yaml returns for a setting not in fileConfig
+var unknownSettingRe = regexp.MustCompile(`field (\S+) not found in type .*`)
+
+// fileConfig is the layout of the YAML configuration file, for example
+//
+//	logger: secops-dumper
+//	output: kinesis:inventory-prod
+//	format: snapshot
+//	state: /var/lib/systrack/state.json
+//	collectors:
+//	  packages: 1h
+//	  lang: 24h
+//	  containers: 0
+//	spool:
+//	  dir: /var/spool/systrack
+//	kinesis:
+//	  region: us-west-2
+//	tags:
+//	  environment: prod
+//	timeouts:
+//	  metadata: 2s
+//
+// Settings that are not in the file keep their defaults.
+type fileConfig struct {
+	Logger     string                   `yaml:"logger"`
+	Output     string                   `yaml:"output"`
+	Cloud      string                   `yaml:"cloud"`
+	IMDSv1     bool                     `yaml:"imdsv1"`
+	Format     string                   `yaml:"format"`
+	State      string                   `yaml:"state"`
+	FullEvery  int                      `yaml:"fullevery"`
+	Root       string                   `yaml:"root"`
+	Collectors map[string]time.Duration `yaml:"collectors"` // An interval of 0 disables a collector
+	Splay      time.Duration            `yaml:"splay"`
+	Policy     string                   `yaml:"policy"` // Scribe policy document or directory
+	Spool      struct {
+		Dir     string        `yaml:"dir"`
+		MaxSize int64         `yaml:"maxsize"` // Megabytes
+		MaxAge  time.Duration `yaml:"maxage"`
+	} `yaml:"spool"`
+	HTTP struct {
+		Headers map[string]string `yaml:"headers"`
+	} `yaml:"http"`
+	Kinesis struct {
+		Region          string `yaml:"region"`
+		Profile         string `yaml:"profile"`
+		AccessKeyID     string `yaml:"accesskeyid"`
+		SecretAccessKey string `yaml:"secretaccesskey"`
+	} `yaml:"kinesis"`
+	Metadata struct {
+		Endpoint string `yaml:"endpoint"`
+	} `yaml:"metadata"`
+	Static struct {
+		InstanceID   string            `yaml:"instanceid"`
+		InstanceType string            `yaml:"instancetype"`
+		LocalIP      string            `yaml:"localip"`
+		Image        string            `yaml:"image"`
+		Account      string            `yaml:"account"`
+		Region       string            `yaml:"region"`
+		Tags         map[string]string `yaml:"tags"`
+	} `yaml:"static"` // Instance details of hosts with no metadata service
+	Signing struct {
+		Key       string `yaml:"key"` // Path to the key file
+		Algorithm string `yaml:"algorithm"`
+		KeyID     string `yaml:"keyid"`
+	} `yaml:"signing"`
+	FileAudit struct {
+		Paths  []string      `yaml:"paths"`
+		Budget time.Duration `yaml:"budget"`
+		Rate   int           `yaml:"rate"` // Files per second
+	} `yaml:"fileaudit"`
+	Tags     map[string]string `yaml:"tags"`
+	Fields   []string          `yaml:"fields"`
+	Timeouts struct {
+		Metadata time.Duration `yaml:"metadata"`
+		HTTP     time.Duration `yaml:"http"`
+		Docker   time.Duration `yaml:"docker"`
+	} `yaml:"timeouts"`
+}
+
+// loadConfig reads the configuration file at path into o, replacing the
+// settings it contains
+func loadConfig(path string, o *options) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Start from the current settings so anything not in the file is kept
+	var fc fileConfig
+	fc.Logger = o.logger
+	fc.Output = o.output
+	fc.Cloud = o.cloud
+	fc.IMDSv1 = o.imdsv1
+	fc.Format = o.format
+	fc.State = o.statePath
+	fc.FullEvery = o.fullEvery
+	fc.Root = o.root
+	fc.Splay = o.splay
+	fc.Policy = o.policy
+	fc.Spool.Dir = o.spool
+	fc.Spool.MaxSize = o.spoolMaxSize
+	fc.Spool.MaxAge = o.spoolMaxAge
+	fc.Signing.Key = o.signKey
+	fc.Signing.Algorithm = o.signAlg
+	fc.Signing.KeyID = o.signKeyID
+	fc.FileAudit.Paths = strings.Split(o.auditPaths, ",")
+	fc.FileAudit.Budget = o.auditBudget
+	fc.FileAudit.Rate = o.auditRate
+	fc.Timeouts.Metadata = o.metadataTimeout
+	fc.Timeouts.HTTP = o.sink.httpTimeout
+	fc.Timeouts.Docker = o.dockerTimeout
+	err = yaml.UnmarshalStrict(buf, &fc)
+	if err != nil {
+		// Describe misspelt settings without the Go type they belong to
+		msg := unknownSettingRe.ReplaceAllString(err.Error(), "unknown setting $1")
+		return fmt.Errorf("%v: %v", path, msg)
+	}
+	err = fc.validate()
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	if fc.Kinesis.SecretAccessKey != "" || len(fc.HTTP.Headers) > 0 {
+		if fi, err := os.Stat(path); err == nil && fi.Mode().Perm()&0077 != 0 {
+			log.Printf("%v contains credentials but is readable by other users\n", path)
+		}
+	}
+
+	o.logger = fc.Logger
+	o.output = fc.Output
+	o.cloud = fc.Cloud
+	o.imdsv1 = fc.IMDSv1
+	o.format = fc.Format
+	o.statePath = fc.State
+	o.fullEvery = fc.FullEvery
+	o.root = fc.Root
+	o.splay = fc.Splay
+	o.policy = fc.Policy
+	o.spool = fc.Spool.Dir
+	o.spoolMaxSize = fc.Spool.MaxSize
+	o.spoolMaxAge = fc.Spool.MaxAge
+	o.sink.httpHeaders = fc.HTTP.Headers
+	o.sink.httpTimeout = fc.Timeouts.HTTP
+	o.sink.kinesisRegion = fc.Kinesis.Region
+	o.sink.kinesisProfile = fc.Kinesis.Profile
+	o.sink.kinesisAccessKeyID = fc.Kinesis.AccessKeyID
+	o.sink.kinesisSecretAccessKey = fc.Kinesis.SecretAccessKey
+	o.signKey = fc.Signing.Key
+	o.signAlg = fc.Signing.Algorithm
+	o.signKeyID = fc.Signing.KeyID
+	o.metadataEndpoint = fc.Metadata.Endpoint
+	o.static = instanceInfo{
+		id:           fc.Static.InstanceID,
+		instanceType: fc.Static.InstanceType,
+		localIP:      fc.Static.LocalIP,
+		image:        fc.Static.Image,
+		account:      fc.Static.Account,
+		region:       fc.Static.Region,
+	}
+	for k, v := range fc.Static.Tags {
+		o.static.tags = append(o.static.tags, k+"="+v)
+	}
+	sort.Strings(o.static.tags)
+	o.metadataTimeout = fc.Timeouts.Metadata
+	o.dockerTimeout = fc.Timeouts.Docker
+	o.auditPaths = strings.Join(fc.FileAudit.Paths, ",")
+	o.auditBudget = fc.FileAudit.Budget
+	o.auditRate = fc.FileAudit.Rate
+	o.tags = fc.Tags
+	o.fields = fc.Fields
+	o.intervals = make(map[string]time.Duration)
+	for name, d := range fc.Collectors {
+		switch name {
+		case "packages":
+			o.interval = d
+			continue
+		case "lang":
+			o.lang = d != 0
+		case "containers":
+			o.containers = d != 0
+		case "sockets":
+			o.sockets = d != 0
+		case "restart":
+			o.restart = d != 0
+		case "accounts":
+			o.accounts = d != 0
+		case "services":
+			o.services = d != 0
+		case "fileaudit":
+			o.fileAudit = d != 0
+		}
+		o.intervals[name] = d
+	}
+	return nil
+}
+
+// validate checks the settings in the configuration file, naming the setting
+// that is wrong in any error returned
+func (fc *fileConfig) validate() error {
+	if fc.Logger == "" {
+		return fmt.Errorf("logger: must not be empty")
+	}
+	if err := checkOutput(fc.Output); err != nil {
+		return fmt.Errorf("output: %v, expected stdout, file:<path>, "+
+			"http(s)://<url> or kinesis:<stream>", err)
+	}
+	switch fc.Cloud {
+	case "auto", "aws", "gce", "azure", "openstack", "none", "static":
+	default:
+		return fmt.Errorf("cloud: unknown provider %q, expected auto, aws, gce, azure, "+
+			"openstack, none or static", fc.Cloud)
+	}
+	if fc.Format != "package" && fc.Format != "snapshot" {
+		return fmt.Errorf("format: unknown format %q, expected package or snapshot", fc.Format)
+	}
+	if fc.FullEvery < 1 {
+		return fmt.Errorf("fullevery: must be at least 1, not %v", fc.FullEvery)
+	}
+	for name, d := range fc.Collectors {
+		found := false
+		for _, c := range collectors {
+			found = found || c.name == name
+		}
+		if !found {
+			var names []string
+			for _, c := range collectors {
+				names = append(names, c.name)
+			}
+			return fmt.Errorf("collectors: unknown collector %q, expected one of %v",
+				name, strings.Join(names, ", "))
+		}
+		if d != 0 && d < time.Minute {
+			return fmt.Errorf("collectors: interval %v for %v is less than a minute, "+
+				"intervals need a unit such as 1h", d, name)
+		}
+	}
+	if fc.Collectors["policy"] != 0 && fc.Policy == "" {
+		return fmt.Errorf("collectors: policy is enabled but no policy documents are set")
+	}
+	if fc.Splay < 0 {
+		return fmt.Errorf("splay: must not be negative")
+	}
+	// The daemon may not run from the directory the file was written for,
+	// so paths must not depend on it
+	for _, p := range []struct{ name, path string }{
+		{"state", fc.State},
+		{"root", fc.Root},
+		{"policy", fc.Policy},
+		{"spool.dir", fc.Spool.Dir},
+		{"signing.key", fc.Signing.Key},
+	} {
+		if p.path != "" && !strings.HasPrefix(p.path, "/") {
+			return fmt.Errorf("%v: %q is not an absolute path", p.name, p.path)
+		}
+	}
+	if fc.Spool.MaxSize < 0 {
+		return fmt.Errorf("spool.maxsize: must not be negative")
+	}
+	if fc.Spool.MaxAge < 0 {
+		return fmt.Errorf("spool.maxage: must not be negative")
+	}
+	if (fc.Kinesis.AccessKeyID == "") != (fc.Kinesis.SecretAccessKey == "") {
+		return fmt.Errorf("kinesis: accesskeyid and secretaccesskey must be set together")
+	}
+	if fc.Kinesis.AccessKeyID != "" && fc.Kinesis.Profile != "" {
+		return fmt.Errorf("kinesis: use either a profile or an access key, not both")
+	}
+	if fc.Metadata.Endpoint != "" && !strings.HasPrefix(fc.Metadata.Endpoint, "http://") &&
+		!strings.HasPrefix(fc.Metadata.Endpoint, "https://") {
+		return fmt.Errorf("metadata.endpoint: %q is not an http(s) URL", fc.Metadata.Endpoint)
+	}
+	if fc.Signing.Algorithm != sigAlgEd25519 && fc.Signing.Algorithm != sigAlgHMAC {
+		return fmt.Errorf("signing.algorithm: unknown algorithm %q, expected %v or %v",
+			fc.Signing.Algorithm, sigAlgEd25519, sigAlgHMAC)
+	}
+	if len(fc.FileAudit.Paths) == 0 {
+		return fmt.Errorf("fileaudit.paths: must not be empty")
+	}
+	for _, p := range fc.FileAudit.Paths {
+		if !strings.HasPrefix(p, "/") || strings.Contains(p, ",") {
+			return fmt.Errorf("fileaudit.paths: %q is not an absolute path", p)
+		}
+	}
+	if fc.FileAudit.Budget < time.Second {
+		return fmt.Errorf("fileaudit.budget: %v is too short, the budget needs a unit such as 5m",
+			fc.FileAudit.Budget)
+	}
+	if fc.FileAudit.Rate < 0 {
+		return fmt.Errorf("fileaudit.rate: must not be negative")
+	}
+	for k := range fc.Tags {
+		if k == "" {
+			return fmt.Errorf("tags: tag names must not be empty")
+		}
+	}
+	for _, f := range fc.Fields {
+		found := false
+		for _, h := range hostFields {
+			found = found || h == f
+		}
+		if !found {
+			sorted := append([]string(nil), hostFields...)
+			sort.Strings(sorted)
+			return fmt.Errorf("fields: unknown field %q, expected any of %v", f,
+				strings.Join(sorted, ", "))
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"metadata": fc.Timeouts.Metadata,
+		"http":     fc.Timeouts.HTTP,
+		"docker":   fc.Timeouts.Docker,
+	} {
+		if d < 10*time.Millisecond {
+			return fmt.Errorf("timeouts.%v: %v is too short, timeouts need a unit such as 5s",
+				name, d)
+		}
+	}
+	return nil
+}
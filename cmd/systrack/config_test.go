@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// defaultOptions returns the options set by the command line defaults
+func defaultOptions(t *testing.T) *options {
+	o := &options{}
+	fs := flag.NewFlagSet("systrack", flag.ContinueOnError)
+	setFlags(fs, o)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	o.sink.httpTimeout = 30 * time.Second
+	o.metadataTimeout = 5 * time.Second
+	o.dockerTimeout = 10 * time.Second
+	return o
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		config string
+		want   string // Expected in the error, or empty for none
+	}{
+		{"output: stdout\ncollectors:\n  packages: 1h\n", ""},
+		{"outptu: stdout\n", "unknown setting outptu"},
+		{"kinesis:\n  regoin: us-west-2\n", "unknown setting regoin"},
+		{"collectors:\n  packages: soon\n", "cannot unmarshal !!str `soon` into time.Duration"},
+		{"collectors:\n  packages: 60\n", "collectors: interval 60ns for packages is less than a minute, " +
+			"intervals need a unit such as 1h"},
+		{"collectors:\n  pakages: 1h\n", `collectors: unknown collector "pakages"`},
+		{"collectors:\n  policy: 24h\n", "collectors: policy is enabled but no policy documents are set"},
+		{"policy: /etc/systrack/policy\ncollectors:\n  policy: 24h\n", ""},
+		{"collectors:\n  policy: 0\n", ""},
+		{"timeouts:\n  http: 5\n", "timeouts.http: 5ns is too short, timeouts need a unit such as 5s"},
+		{"fileaudit:\n  budget: 30\n", "fileaudit.budget: 30ns is too short"},
+		{"output: ftp://collector\n", "output: unknown output \"ftp://collector\", expected stdout, " +
+			"file:<path>, http(s)://<url> or kinesis:<stream>"},
+		{"format: csv\n", `format: unknown format "csv", expected package or snapshot`},
+		{"cloud: ec2\n", `cloud: unknown provider "ec2"`},
+		{"state: var/lib/systrack/state.json\n", `state: "var/lib/systrack/state.json" is not an absolute path`},
+		{"spool:\n  dir: spool\n", `spool.dir: "spool" is not an absolute path`},
+		{"policy: policies/\n", `policy: "policies/" is not an absolute path`},
+		{"signing:\n  key: host.key\n", `signing.key: "host.key" is not an absolute path`},
+		{"fileaudit:\n  paths: [usr]\n", `fileaudit.paths: "usr" is not an absolute path`},
+		{"fields: [hostname]\n", `fields: unknown field "hostname"`},
+		{"kinesis:\n  accesskeyid: AKIA\n", "kinesis: accesskeyid and secretaccesskey must be set together"},
+		{"tags:\n  \"\": x\n", "tags: tag names must not be empty"},
+	}
+	for i, tc := range tests {
+		path := writeFile(t, dir, "systrack.yaml", tc.config)
+		err := loadConfig(path, defaultOptions(t))
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("config %v: %v", i, err)
+		case tc.want != "" && err == nil:
+			t.Errorf("config %v: no error, want %v", i, tc.want)
+		case tc.want != "" && !strings.Contains(err.Error(), tc.want):
+			t.Errorf("config %v: got %v, want %v", i, err, tc.want)
+		case err != nil && !strings.HasPrefix(err.Error(), path+": "):
+			t.Errorf("config %v: error %v does not name the file", i, err)
+		}
+	}
+}
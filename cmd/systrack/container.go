@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// dockerSocket is the Docker Engine API socket
+	dockerSocket = "/var/run/docker.sock"
+	// containerdTaskDir holds the bundles of containers run by containerd
+	// shim v2 runtimes, one directory per namespace
+	containerdTaskDir = "/run/containerd/io.containerd.runtime.v2.task"
+)
+
+// dockerTimeout limits each request to the Docker Engine API
+var dockerTimeout = 10 * time.Second
+
+// containerInfo describes a running container
+type containerInfo struct {
+	id      string
+	name    string
+	image   string // Image reference the container was started from
+	digest  string // Image digest, or the image id if no digest is known
+	runtime string // docker or containerd
+	rootfs  string // Path to the root filesystem of the container
+}
+
+// imageInfo groups the running containers that use the same image, so each
+// image is only inventoried once
+type imageInfo struct {
+	image      string
+	digest     string
+	rootfs     string
+	containers []containerInfo
+}
+
+// getContainers returns the containers running under Docker and containerd.
+// Containers Docker runs using containerd are only reported once.
+func getContainers() (ret []containerInfo, err error) {
+	seen := make(map[string]bool)
+	if _, err := os.Stat(dockerSocket); err == nil {
+		dc, err := getDockerContainers(dockerSocket)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range dc {
+			seen[c.id] = true
+		}
+		ret = append(ret, dc...)
+	}
+	cc, err := getContainerdContainers(containerdTaskDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cc {
+		if !seen[c.id] {
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+// groupByImage returns the images used by containers, keyed on digest
+func groupByImage(containers []containerInfo) []imageInfo {
+	m := make(map[string]*imageInfo)
+	var keys []string
+	for _, c := range containers {
+		key := c.digest
+		if key == "" {
+			key = c.image
+		}
+		img, ok := m[key]
+		if !ok {
+			img = &imageInfo{image: c.image, digest: c.digest, rootfs: c.rootfs}
+			m[key] = img
+			keys = append(keys, key)
+		}
+		img.containers = append(img.containers, c)
+	}
+	sort.Strings(keys)
+	ret := make([]imageInfo, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, *m[k])
+	}
+	return ret
+}
+
+// dockerClient returns an HTTP client that connects to the Docker socket at
+// path, regardless of the host in the request URL
+func dockerClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+		Timeout: dockerTimeout,
+	}
+}
+
+// dockerGet requests endpoint from the Docker Engine API and decodes the JSON
+// response into v
+func dockerGet(client *http.Client, endpoint string, v interface{}) error {
+	resp, err := client.Get("http://docker" + endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("invalid HTTP response code returned by docker for %v: %v",
+			endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getDockerContainers lists running containers using the Docker Engine API
+func getDockerContainers(socket string) (ret []containerInfo, err error) {
+	client := dockerClient(socket)
+	var list []struct {
+		ID string `json:"Id"`
+	}
+	err = dockerGet(client, "/containers/json", &list)
+	if err != nil {
+		return
+	}
+	for _, x := range list {
+		var ctr struct {
+			ID     string `json:"Id"`
+			Name   string `json:"Name"`
+			Image  string `json:"Image"`
+			Config struct {
+				Image string `json:"Image"`
+			} `json:"Config"`
+			State struct {
+				Pid int `json:"Pid"`
+			} `json:"State"`
+		}
+		err = dockerGet(client, "/containers/"+x.ID+"/json", &ctr)
+		if err != nil {
+			return
+		}
+		var img struct {
+			RepoDigests []string `json:"RepoDigests"`
+		}
+		err = dockerGet(client, "/images/"+ctr.Image+"/json", &img)
+		if err != nil {
+			return
+		}
+		c := containerInfo{
+			id:      ctr.ID,
+			name:    strings.TrimPrefix(ctr.Name, "/"),
+			image:   ctr.Config.Image,
+			digest:  ctr.Image,
+			runtime: "docker",
+			rootfs:  fmt.Sprintf("/proc/%v/root", ctr.State.Pid),
+		}
+		if len(img.RepoDigests) > 0 {
+			if n := strings.Index(img.RepoDigests[0], "@"); n != -1 {
+				c.digest = img.RepoDigests[0][n+1:]
+			}
+		}
+		ret = append(ret, c)
+	}
+	return
+}
+
+// getContainerdContainers lists running containers from the containerd task
+// directory. containerd has no HTTP API, but each task bundle includes the OCI
+// runtime config, which carries the image name for containers started through
+// CRI, and the pid of the container init process.
+func getContainerdContainers(dir string) (ret []containerInfo, err error) {
+	bundles, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		return
+	}
+	for _, b := range bundles {
+		if filepath.Base(filepath.Dir(b)) == "moby" {
+			// Docker containers, which we get from the Docker API
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(b, "init.pid"))
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+		if err != nil {
+			continue
+		}
+		c := containerInfo{
+			id:      filepath.Base(b),
+			runtime: "containerd",
+			rootfs:  fmt.Sprintf("/proc/%v/root", pid),
+		}
+		buf, err = ioutil.ReadFile(filepath.Join(b, "config.json"))
+		if err == nil {
+			var spec struct {
+				Annotations map[string]string `json:"annotations"`
+			}
+			if json.Unmarshal(buf, &spec) == nil {
+				c.name = spec.Annotations["io.kubernetes.cri.container-name"]
+				c.image = spec.Annotations["io.kubernetes.cri.image-name"]
+			}
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// emitContainers inventories the image of each running container, writing
+// records tagged with the image and the containers running it. The image is
+// inventoried by reading the package databases in the root filesystem of the
+// first container using it.
+func emitContainers(s sink, host logrus.Fields, format string) error {
+	containers, err := getContainers()
+	if err != nil {
+		return err
+	}
+	for _, img := range groupByImage(containers) {
+		var ids, names []string
+		for _, c := range img.containers {
+			ids = append(ids, c.id)
+			names = append(names, c.name)
+		}
+		fields := withFields(host, logrus.Fields{
+			"image":          img.image,
+			"imagedigest":    img.digest,
+			"containerids":   ids,
+			"containernames": names,
+			"runtime":        img.containers[0].runtime,
+		})
+		dist, err := getDist(img.rootfs)
+		if err != nil {
+			log.Printf("image %v: %v\n", img.image, err)
+		}
+		fields["dist"] = dist
+		pkgs, sources := getRootPackages(img.rootfs)
+		err = emitInventory(s, fields, format, pkgs, sources)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// setDaemonFlags registers the options only used in daemon mode in fs
+func setDaemonFlags(fs *flag.FlagSet, o *options) {
+	fs.DurationVar(&o.interval, "interval", time.Hour,
+		"how often the packages collector runs")
+	fs.DurationVar(&o.splay, "splay", 15*time.Minute,
+		"maximum random delay before the first run of each collector, so hosts started together do not report together")
+	fs.StringVar(&o.schedule, "schedule", "",
+		"comma separated collector=interval pairs overriding the default intervals, for example lang=12h,containers=6h; an interval of 0 disables the collector")
+}
+
+// scheduledCollector is a collector and when it next runs in daemon mode
+type scheduledCollector struct {
+	collector
+	interval time.Duration
+	next     time.Time
+}
+
+// getSchedule returns the collectors to run in daemon mode with their
+// intervals. Collectors named in the schedule option are enabled even if the
+// option that enables them in one-shot mode is not set.
+func getSchedule(o *options) ([]*scheduledCollector, error) {
+	intervals := make(map[string]time.Duration)
+	for _, c := range enabledCollectors(o) {
+		intervals[c.name] = c.interval
+	}
+	for k, v := range o.intervals {
+		intervals[k] = v
+	}
+	intervals["packages"] = o.interval
+	if o.schedule != "" {
+		for _, x := range strings.Split(o.schedule, ",") {
+			f := strings.SplitN(strings.TrimSpace(x), "=", 2)
+			if len(f) != 2 {
+				return nil, fmt.Errorf("invalid schedule entry %q", x)
+			}
+			d, err := time.ParseDuration(f[1])
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid interval in schedule entry %q", x)
+			}
+			found := false
+			for _, c := range collectors {
+				found = found || c.name == f[0]
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown collector %q in schedule", f[0])
+			}
+			intervals[f[0]] = d
+		}
+	}
+	var ret []*scheduledCollector
+	for _, c := range collectors {
+		d, ok := intervals[c.name]
+		if !ok || d == 0 {
+			continue
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("interval for %v collector is less than a minute", c.name)
+		}
+		ret = append(ret, &scheduledCollector{collector: c, interval: d})
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no collectors scheduled")
+	}
+	return ret, nil
+}
+
+// runDaemon runs the collectors on their schedules until it receives SIGTERM or
+// SIGINT. Each collector first runs after a random delay of up to the splay,
+// then at its interval. Collectors that are due at the same time run together
+// and are delivered as a single run. A run in progress when the signal arrives
+// is completed, so its records are delivered and the state is saved.
+func runDaemon(o *options) error {
+	sched, err := getSchedule(o)
+	if err != nil {
+		return err
+	}
+	// The provider is kept between runs so AWS session tokens are reused
+	provider, err := newMetadataProvider(o.cloud, o.metadataEndpoint, o.imdsv1, o.static)
+	if err != nil {
+		return err
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	now := time.Now()
+	for _, s := range sched {
+		s.next = now
+		if o.splay > 0 {
+			s.next = now.Add(time.Duration(rnd.Int63n(int64(o.splay))))
+		}
+		log.Printf("%v collector runs every %v, first at %v\n", s.name, s.interval,
+			s.next.Format(time.RFC3339))
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sig)
+	for {
+		next := sched[0].next
+		for _, s := range sched[1:] {
+			if s.next.Before(next) {
+				next = s.next
+			}
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case s := <-sig:
+			timer.Stop()
+			log.Printf("received %v, exiting\n", s)
+			return nil
+		case <-timer.C:
+		}
+
+		now = time.Now()
+		var due []collector
+		for _, s := range sched {
+			if s.next.After(now) {
+				continue
+			}
+			due = append(due, s.collector)
+			// Skip any runs missed while the host was suspended or a run
+			// took longer than the interval
+			for !s.next.After(now) {
+				s.next = s.next.Add(s.interval)
+			}
+		}
+		err = runCollectors(o, provider, due)
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+}
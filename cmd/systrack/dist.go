@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// distAliases maps os-release ID values to the name used in the distribution
+// namespace, where they differ
+var distAliases = map[string]string{
+	"almalinux":     "alma",
+	"ol":            "oracle",
+	"opensuse-leap": "opensuse",
+}
+
+// distFullVersion lists distributions where the complete version identifies
+// the release, rather than only the major version
+var distFullVersion = map[string]bool{
+	"ubuntu": true,
+}
+
+// distMinorVersion lists distributions where the major and minor version
+// identify the release
+var distMinorVersion = map[string]bool{
+	"alpine":   true,
+	"opensuse": true,
+}
+
+// releaseFile describes a distribution specific release file, and the
+// distribution identifier to use for it
+type releaseFile struct {
+	path string
+	id   string
+}
+
+// releaseFiles are checked in order if os-release and lsb-release could not
+// identify the system. Files that are present on several distributions, such as
+// redhat-release, are checked last.
+var releaseFiles = []releaseFile{
+	{"/etc/centos-release", "centos"},
+	{"/etc/oracle-release", "ol"},
+	{"/etc/rocky-release", "rocky"},
+	{"/etc/almalinux-release", "almalinux"},
+	{"/etc/fedora-release", "fedora"},
+	{"/etc/alpine-release", "alpine"},
+	{"/etc/debian_version", "debian"},
+	{"/etc/redhat-release", ""},
+	{"/etc/system-release", ""},
+}
+
+// releaseNames maps the product name found at the start of a generic release
+// file to a distribution identifier
+var releaseNames = []struct {
+	prefix string
+	id     string
+}{
+	{"Red Hat Enterprise Linux", "rhel"},
+	{"CentOS", "centos"},
+	{"Rocky Linux", "rocky"},
+	{"AlmaLinux", "almalinux"},
+	{"Oracle Linux", "ol"},
+	{"Amazon Linux", "amzn"},
+	{"Fedora", "fedora"},
+}
+
+var releaseVersionRegexp = regexp.MustCompile(`(\d+(\.\d+)*)`)
+
+// debianCodenames maps the codenames of Debian releases to their versions.
+// Testing and unstable are only identified by the codename of the next
+// release, so they are reported as that release.
+var debianCodenames = map[string]string{
+	"buster":   "10",
+	"bullseye": "11",
+	"bookworm": "12",
+	"trixie":   "13",
+	"forky":    "14",
+	"duke":     "15",
+}
+
+// getDist identifies the distribution installed under root, and returns a
+// normalized namespace such as rhel:8, amzn:2, debian:12 or ubuntu:22.04.
+//
+// The distribution is identified from os-release, falling back to lsb-release
+// and then the distribution specific release files.
+func getDist(root string) (string, error) {
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		vals, err := readKeyValueFile(resolveInRoot(root, p))
+		if err != nil {
+			continue
+		}
+		if vals["ID"] == "" {
+			continue
+		}
+		version := vals["VERSION_ID"]
+		if version == "" && vals["ID"] == "debian" {
+			// Debian testing and unstable have no VERSION_ID, but do
+			// have the codename of the next release, which is also the
+			// start of debian_version, such as trixie/sid
+			codename := vals["VERSION_CODENAME"]
+			if codename == "" {
+				codename, _ = readFirstLine(resolveInRoot(root, "/etc/debian_version"))
+			}
+			var ok bool
+			version, ok = debianVersion(codename)
+			if !ok {
+				return "", fmt.Errorf("unknown Debian release %q", codename)
+			}
+		}
+		if version != "" {
+			return normalizeDist(vals["ID"], version), nil
+		}
+	}
+
+	vals, err := readKeyValueFile(resolveInRoot(root, "/etc/lsb-release"))
+	if err == nil && vals["DISTRIB_ID"] != "" && vals["DISTRIB_RELEASE"] != "" {
+		return normalizeDist(vals["DISTRIB_ID"], vals["DISTRIB_RELEASE"]), nil
+	}
+	if root == "/" {
+		id, release, err := getLSBDist()
+		if err == nil {
+			return normalizeDist(id, release), nil
+		}
+	}
+
+	for _, rf := range releaseFiles {
+		line, err := readFirstLine(resolveInRoot(root, rf.path))
+		if err != nil {
+			continue
+		}
+		id := rf.id
+		if id == "" {
+			for _, n := range releaseNames {
+				if strings.HasPrefix(line, n.prefix) {
+					id = n.id
+					break
+				}
+			}
+			if id == "" {
+				continue
+			}
+		}
+		version := releaseVersionRegexp.FindString(line)
+		if version == "" && id == "debian" {
+			version, _ = debianVersion(line)
+		}
+		if version == "" {
+			continue
+		}
+		return normalizeDist(id, version), nil
+	}
+	return "", fmt.Errorf("unable to identify distribution")
+}
+
+// normalizeDist converts a distribution identifier and version into a namespace
+// string, for example ("almalinux", "9.2") becomes alma:9 and ("amzn",
+// "2023.3.20240108") becomes amzn:2023
+func normalizeDist(id, version string) string {
+	id = strings.ToLower(id)
+	if v, ok := distAliases[id]; ok {
+		id = v
+	}
+	switch id {
+	case "redhatenterpriseserver", "redhatenterprise":
+		// lsb_release reports RHEL this way
+		id = "rhel"
+	case "amazon":
+		id = "amzn"
+	}
+	parts := strings.Split(version, ".")
+	switch {
+	case distFullVersion[id]:
+	case id == "amzn" && len(parts[0]) == 4 && parts[0] < "2022":
+		// Amazon Linux 1 releases are named by year and month, such as
+		// 2018.03, where from Amazon Linux 2023 the year is the release
+		// and what follows a point release
+	case distMinorVersion[id] && len(parts) > 1:
+		version = parts[0] + "." + parts[1]
+	default:
+		version = parts[0]
+	}
+	return id + ":" + version
+}
+
+// debianVersion returns the version of the Debian release with codename, which
+// may be followed by /sid as it is in debian_version on testing and unstable
+func debianVersion(codename string) (string, bool) {
+	codename = strings.TrimSuffix(strings.TrimSpace(codename), "/sid")
+	v, ok := debianCodenames[codename]
+	return v, ok
+}
+
+// getLSBDist returns the distributor ID and release from lsb_release
+func getLSBDist() (id, release string, err error) {
+	path, err := exec.LookPath("lsb_release")
+	if err != nil {
+		return
+	}
+	out, err := exec.Command(path, "-i", "-s").Output()
+	if err != nil {
+		return
+	}
+	id = cleanString(string(out))
+	out, err = exec.Command(path, "-r", "-s").Output()
+	if err != nil {
+		return
+	}
+	release = cleanString(string(out))
+	if id == "" || release == "" || release == "n/a" {
+		err = fmt.Errorf("lsb_release returned no distribution")
+	}
+	return
+}
+
+// readKeyValueFile parses a file of KEY=value lines, such as os-release, and
+// returns the values with any quoting removed
+func readKeyValueFile(path string) (map[string]string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	ret := make(map[string]string)
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		line := strings.TrimSpace(scn.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n := strings.Index(line, "=")
+		if n < 1 {
+			continue
+		}
+		ret[line[:n]] = unquote(line[n+1:])
+	}
+	return ret, scn.Err()
+}
+
+// unquote removes shell style quoting from an os-release value
+func unquote(val string) string {
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+		val = val[1 : len(val)-1]
+	}
+	return strings.NewReplacer(`\"`, `"`, `\'`, `'`, `\\`, `\`, "\\`", "`", `\$`, `$`).Replace(val)
+}
+
+// readFirstLine returns the first line of the file at path
+func readFirstLine(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if loc := bytes.IndexByte(data, '\n'); loc != -1 {
+		data = data[:loc]
+	}
+	line := strings.TrimSpace(string(data))
+	if line == "" {
+		return "", fmt.Errorf("%v is empty", path)
+	}
+	return line, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetDist(t *testing.T) {
+	tests := []struct {
+		root string // Under testdata/dist
+		want string
+	}{
+		{"rhel8", "rhel:8"},
+		{"centos7", "centos:7"},
+		{"centos6", "centos:6"},
+		{"rhel6", "rhel:6"},
+		{"rocky9", "rocky:9"},
+		{"alma9", "alma:9"},
+		{"oracle8", "oracle:8"},
+		{"oracle6", "oracle:6"},
+		{"amzn1", "amzn:2018.03"},
+		{"amzn2", "amzn:2"},
+		{"amzn2023", "amzn:2023"},
+		{"debian12", "debian:12"},
+		{"debian-testing", "debian:13"},
+		{"debian-sid-nocodename", "debian:13"},
+		{"debian-version-only", "debian:14"},
+		{"debian9-version-only", "debian:9"},
+		{"ubuntu2204", "ubuntu:22.04"},
+		{"ubuntu1404-lsb", "ubuntu:14.04"},
+		{"alpine318", "alpine:3.18"},
+		{"alpine-release-only", "alpine:3.16"},
+		{"opensuse-leap155", "opensuse:15.5"},
+		{"fedora39", "fedora:39"},
+	}
+	for _, tt := range tests {
+		got, err := getDist(filepath.Join("testdata/dist", tt.root))
+		if err != nil {
+			t.Errorf("%v: %v", tt.root, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.root, got, tt.want)
+		}
+	}
+
+	if got, err := getDist("testdata/dist/empty"); err == nil {
+		t.Errorf("empty: got %v, want an error", got)
+	}
+}
+
+func TestNormalizeDist(t *testing.T) {
+	tests := []struct {
+		id, version, want string
+	}{
+		{"almalinux", "9.2", "alma:9"},
+		{"RedHatEnterpriseServer", "7.9", "rhel:7"},
+		{"Amazon", "2", "amzn:2"},
+		{"amzn", "2023.3.20240108", "amzn:2023"},
+		{"amzn", "2018.03", "amzn:2018.03"},
+		{"Ubuntu", "20.04", "ubuntu:20.04"},
+		{"alpine", "3.19.1", "alpine:3.19"},
+		{"ol", "7.9", "oracle:7"},
+	}
+	for _, tt := range tests {
+		if got := normalizeDist(tt.id, tt.version); got != tt.want {
+			t.Errorf("normalizeDist(%q, %q) = %v, want %v", tt.id, tt.version, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultAuditPaths are the paths the file audit walks if none are configured
+var defaultAuditPaths = []string{
+	"/usr", "/bin", "/sbin", "/lib", "/lib64", "/opt", "/etc", "/var", "/home", "/root",
+	"/srv", "/tmp",
+}
+
+// errAuditBudget stops the file audit walk once its budget is used up
+var errAuditBudget = errors.New("file audit budget exceeded")
+
+// maxHashSize is the size of the largest file the audit hashes. A larger
+// world-writable file, such as a disk image or log, is reported without a
+// hash rather than reading it all.
+var maxHashSize int64 = 64 << 20
+
+// auditFile is a setuid or setgid file, or a world-writable file or directory
+// without the sticky bit
+type auditFile struct {
+	path          string
+	mode          os.FileMode
+	uid, gid      uint32
+	size          int64
+	sha256        string // Regular files only
+	setuid        bool
+	setgid        bool
+	worldWritable bool
+	pkg           scribe.PackageInfo
+	packaged      bool
+}
+
+// fileAudit is the result of walking the audit paths
+type fileAudit struct {
+	files    []auditFile
+	scanned  int // Files and directories examined
+	complete bool
+	elapsed  time.Duration
+}
+
+// auditFiles walks paths in the filesystem at root, returning the setuid and
+// setgid files and the world-writable files and directories without the sticky
+// bit. The walk stays on the filesystem each path is on, and stops once budget
+// has elapsed. At most rate files are examined each second, so the audit does
+// not compete with the workload of the host for disk bandwidth.
+func auditFiles(root string, paths []string, budget time.Duration, rate int, owners fileOwners) (ret fileAudit) {
+	start := time.Now()
+	deadline := start.Add(budget)
+	ret.complete = true
+	for _, p := range paths {
+		err := auditPath(root, p, deadline, rate, start, owners, &ret)
+		if err == errAuditBudget {
+			ret.complete = false
+			break
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("%v\n", err)
+		}
+	}
+	ret.elapsed = time.Since(start)
+	return
+}
+
+// auditPath walks a single audit path, adding the files found to audit
+func auditPath(root, path string, deadline time.Time, rate int, start time.Time,
+	owners fileOwners, audit *fileAudit) error {
+	top := resolveInRoot(root, path)
+	fi, err := os.Lstat(top)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		// For example /lib where it is a link to /usr/lib, which is
+		// walked on its own
+		return nil
+	}
+	dev := fi.Sys().(*syscall.Stat_t).Dev
+	return filepath.Walk(top, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// Unreadable directories are skipped rather than ending the
+			// walk
+			return nil
+		}
+		audit.scanned++
+		now := time.Now()
+		if now.After(deadline) {
+			return errAuditBudget
+		}
+		if rate > 0 && audit.scanned%100 == 0 {
+			// Sleep until this many files are due at the rate, unless
+			// that is past the deadline
+			due := start.Add(time.Duration(audit.scanned) * time.Second / time.Duration(rate))
+			if due.After(deadline) {
+				return errAuditBudget
+			}
+			if due.After(now) {
+				time.Sleep(due.Sub(now))
+			}
+		}
+		if fi.IsDir() && fi.Sys().(*syscall.Stat_t).Dev != dev {
+			return filepath.SkipDir
+		}
+		mode := fi.Mode()
+		if !mode.IsRegular() && !mode.IsDir() {
+			return nil
+		}
+		f := auditFile{
+			setuid:        mode&os.ModeSetuid != 0,
+			setgid:        mode&os.ModeSetgid != 0 && !mode.IsDir(),
+			worldWritable: mode.Perm()&0002 != 0 && mode&os.ModeSticky == 0,
+		}
+		if !f.setuid && !f.setgid && !f.worldWritable {
+			return nil
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		f.path = filepath.Join(path, strings.TrimPrefix(p, top))
+		f.mode, f.uid, f.gid, f.size = mode, st.Uid, st.Gid, fi.Size()
+		f.pkg, f.packaged = owners.owner(f.path)
+		if mode.IsRegular() && f.size <= maxHashSize {
+			f.sha256, err = hashFile(p)
+			if err != nil {
+				log.Printf("%v\n", err)
+			}
+		}
+		audit.files = append(audit.files, f)
+		return nil
+	})
+}
+
+// hashFile returns the hex encoded SHA256 hash of the file at path, which must
+// be no larger than maxHashSize
+func hashFile(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	// The file may have grown since it was examined
+	n, err := io.Copy(h, io.LimitReader(fd, maxHashSize+1))
+	if err != nil {
+		return "", err
+	}
+	if n > maxHashSize {
+		return "", fmt.Errorf("%v: larger than %v bytes, not hashed", path, maxHashSize)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// emitFileAudit writes a record for each file found by the audit, and a
+// record summarising the audit so incomplete audits can be told apart from
+// hosts with nothing to report
+func emitFileAudit(s sink, host logrus.Fields, audit fileAudit) error {
+	for _, f := range audit.files {
+		// Permissions in the octal form chmod takes, including the setuid,
+		// setgid and sticky bits
+		perm := uint32(f.mode.Perm())
+		if f.mode&os.ModeSetuid != 0 {
+			perm |= 04000
+		}
+		if f.mode&os.ModeSetgid != 0 {
+			perm |= 02000
+		}
+		if f.mode&os.ModeSticky != 0 {
+			perm |= 01000
+		}
+		ftype := "file"
+		if f.mode.IsDir() {
+			ftype = "dir"
+		}
+		err := emit(s, withFields(host, logrus.Fields{
+			"rectype":       "file",
+			"path":          f.path,
+			"filetype":      ftype,
+			"mode":          fmt.Sprintf("%04o", perm),
+			"uid":           f.uid,
+			"gid":           f.gid,
+			"size":          f.size,
+			"sha256":        f.sha256,
+			"setuid":        f.setuid,
+			"setgid":        f.setgid,
+			"worldwritable": f.worldWritable,
+			"packaged":      f.packaged,
+			"pkgname":       f.pkg.Name,
+			"pkgversion":    f.pkg.Version,
+			"pkgtype":       f.pkg.Type,
+			"pkgarch":       f.pkg.Arch,
+		}), fmt.Sprintf("%v %04o %v", ftype, perm, f.path))
+		if err != nil {
+			return err
+		}
+	}
+	return emit(s, withFields(host, logrus.Fields{
+		"rectype":  "fileaudit",
+		"scanned":  audit.scanned,
+		"found":    len(audit.files),
+		"complete": audit.complete,
+		"elapsed":  audit.elapsed.Seconds(),
+	}), fmt.Sprintf("file audit examined %v files in %v", audit.scanned, audit.elapsed))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditHashSize(t *testing.T) {
+	saved := maxHashSize
+	defer func() { maxHashSize = saved }()
+	maxHashSize = 16
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"small": "0123456789abcdef",
+		"large": "0123456789abcdef!",
+	} {
+		path := writeFile(t, dir, name, content)
+		if err := os.Chmod(path, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	audit := auditFiles(dir, []string{"/"}, time.Minute, 0, make(fileOwners))
+	hashes := make(map[string]string)
+	for _, f := range audit.files {
+		hashes[f.path] = f.sha256
+	}
+	want := map[string]string{
+		// sha256sum of 0123456789abcdef
+		"/small": "9f9f5111f7b27a781f1f1ddde5ebc2dd2b796bfc7365c9c28b548e564176929f",
+		"/large": "",
+	}
+	for path, h := range want {
+		if got, ok := hashes[path]; !ok || got != h {
+			t.Errorf("%v: hash %q, want %q", path, got, h)
+		}
+	}
+}
+
+func TestAuditRateDeadline(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for i := 0; i < 150; i++ {
+		writeFile(t, dir, fmt.Sprintf("f%03d", i), "")
+	}
+	// At one file a second the first 100 files are only due after the
+	// budget, so the audit stops rather than sleeping past it
+	start := time.Now()
+	audit := auditFiles(dir, []string{"/"}, time.Minute, 1, make(fileOwners))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("audit took %v", elapsed)
+	}
+	if audit.complete {
+		t.Errorf("audit complete after %v files", audit.scanned)
+	}
+}
+
+func TestHashFileGrown(t *testing.T) {
+	saved := maxHashSize
+	defer func() { maxHashSize = saved }()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := writeFile(t, dir, "log", strings.Repeat("x", 32))
+	maxHashSize = 16
+	if _, err := hashFile(path); err == nil {
+		t.Error("no error hashing a file larger than the limit")
+	}
+}
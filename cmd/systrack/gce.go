@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+var gceHeaders = map[string]string{"Metadata-Flavor": "Google"}
+
+// gceProvider fetches instance information from the Google Compute Engine
+// metadata server
+type gceProvider struct {
+	baseURL string
+}
+
+func (g *gceProvider) name() string {
+	return "gce"
+}
+
+func (g *gceProvider) detect() bool {
+	_, err := g.gceFetchMeta("instance/id")
+	return err == nil
+}
+
+func (g *gceProvider) fetch() (info instanceInfo, err error) {
+	info.id, err = g.gceFetchMeta("instance/id")
+	if err != nil {
+		return
+	}
+	// machine-type and image are returned as resource paths, for example
+	// projects/1234/machineTypes/n1-standard-1, we only want the final component
+	mtype, err := g.gceFetchMeta("instance/machine-type")
+	if err != nil {
+		return
+	}
+	info.instanceType = path.Base(mtype)
+	info.localIP, err = g.gceFetchMeta("instance/network-interfaces/0/ip")
+	if err != nil {
+		return
+	}
+	image, err := g.gceFetchMeta("instance/image")
+	if err != nil {
+		return
+	}
+	info.image = path.Base(image)
+	info.account, err = g.gceFetchMeta("project/project-id")
+	if err != nil {
+		return
+	}
+	// the zone is returned as projects/1234/zones/us-central1-a, the region is
+	// the zone name without the final component
+	zone, err := g.gceFetchMeta("instance/zone")
+	if err != nil {
+		return
+	}
+	info.region = path.Base(zone)
+	if n := strings.LastIndex(info.region, "-"); n > 0 {
+		info.region = info.region[:n]
+	}
+	// network tags are returned as a JSON array of strings
+	tags, err := g.gceFetchMeta("instance/tags?alt=json")
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(tags), &info.tags)
+	return
+}
+
+func (g *gceProvider) gceFetchMeta(endpoint string) (string, error) {
+	return fetchMeta(g.baseURL+"/computeMetadata/v1/"+endpoint, gceHeaders)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// rpmKernelPkgs are the names of rpm packages that provide a bootable kernel
+var rpmKernelPkgs = map[string]bool{
+	"kernel":      true,
+	"kernel-core": true,
+	"kernel-uek":  true,
+}
+
+// kernelArchSuffixes are removed from the kernel release to obtain the rpm
+// package version, for example 3.10.0-1160.el7.x86_64 becomes 3.10.0-1160.el7
+var kernelArchSuffixes = []string{".x86_64", ".aarch64", ".i686", ".ppc64le", ".s390x"}
+
+// kernelInfo describes the running kernel and whether the host needs to be
+// rebooted to run the newest installed kernel
+type kernelInfo struct {
+	release        string    // Running kernel release, as returned by uname -r
+	bootTime       time.Time // Time the host booted
+	pkg            scribe.PackageInfo
+	installed      []string // Versions of all installed kernel packages
+	rebootRequired bool
+	rebootReasons  []string
+}
+
+// getKernelInfo identifies the running kernel, and the installed package it
+// was loaded from using the package list pkgs
+func getKernelInfo(pkgs []scribe.PackageInfo) (ki kernelInfo, err error) {
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return
+	}
+	ki.release = strings.TrimSpace(string(release))
+	ki.bootTime, err = getBootTime()
+	if err != nil {
+		return
+	}
+
+	rpmVersion, arch := ki.release, ""
+	for _, x := range kernelArchSuffixes {
+		if strings.HasSuffix(rpmVersion, x) {
+			rpmVersion = strings.TrimSuffix(rpmVersion, x)
+			arch = x[1:]
+			break
+		}
+	}
+	var newest, rpmArch string
+	for _, p := range pkgs {
+		switch {
+		case p.Type == "rpm" && rpmKernelPkgs[p.Name]:
+			ki.installed = append(ki.installed, p.Version)
+			rpmArch = p.Arch
+			if p.Version == rpmVersion {
+				ki.pkg = p
+			}
+			if newest == "" || versionGreater(p.Version, newest) {
+				newest = p.Version
+			}
+		case p.Type == "dpkg" && strings.HasPrefix(p.Name, "linux-image-") &&
+			len(p.Name) > 12 && p.Name[12] >= '0' && p.Name[12] <= '9':
+			ki.installed = append(ki.installed, p.Name+" "+p.Version)
+			if p.Name == "linux-image-"+ki.release {
+				ki.pkg = p
+			}
+		}
+	}
+	if ki.pkg.Name == "" && (arch != "" || rpmArch != "") {
+		// The package for the running kernel has been removed, report it
+		// using the version it would have had so it can still be checked
+		if arch == "" {
+			arch = rpmArch
+		}
+		ki.pkg = scribe.PackageInfo{Name: "kernel", Version: rpmVersion, Type: "rpm", Arch: arch}
+	}
+
+	if newest != "" && versionGreater(newest, rpmVersion) {
+		ki.rebootReasons = append(ki.rebootReasons,
+			fmt.Sprintf("newer kernel %v installed", newest))
+	}
+	if _, err := os.Stat("/var/run/reboot-required"); err == nil {
+		ki.rebootReasons = append(ki.rebootReasons, "/var/run/reboot-required present")
+	}
+	if needsRestarting() {
+		ki.rebootReasons = append(ki.rebootReasons, "needs-restarting reports reboot required")
+	}
+	ki.rebootRequired = len(ki.rebootReasons) > 0
+	return ki, nil
+}
+
+// versionGreater returns true if version a is later than version b
+func versionGreater(a, b string) bool {
+	f, err := scribe.TestEvrCompare(scribe.EvropGreaterThan, a, b)
+	return err == nil && f
+}
+
+// getBootTime reads the boot time of the host from /proc/stat
+func getBootTime() (time.Time, error) {
+	fd, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		f := strings.Fields(scn.Text())
+		if len(f) == 2 && f[0] == "btime" {
+			v, err := strconv.ParseInt(f[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(v, 0).UTC(), nil
+		}
+	}
+	if scn.Err() != nil {
+		return time.Time{}, scn.Err()
+	}
+	return time.Time{}, fmt.Errorf("boot time not found in /proc/stat")
+}
+
+// needsRestarting runs needs-restarting -r from yum-utils if it is installed,
+// which exits with status 1 if a reboot is required
+func needsRestarting() bool {
+	path, err := exec.LookPath("needs-restarting")
+	if err != nil {
+		return false
+	}
+	err = exec.Command(path, "-r").Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		if status, ok := e.Sys().(interface{ ExitStatus() int }); ok {
+			return status.ExitStatus() == 1
+		}
+	}
+	return false
+}
+
+// emitKernel writes a record describing the running kernel. The lambda checks
+// the package in this record rather than every installed kernel package, so
+// hosts still running a vulnerable kernel after an upgrade are reported.
+func emitKernel(s sink, host logrus.Fields, ki kernelInfo, sources pkgSources) error {
+	return emit(s, sources.add(withFields(host, logrus.Fields{
+		"rectype":          "kernel",
+		"pkgname":          ki.pkg.Name,
+		"pkgversion":       ki.pkg.Version,
+		"pkgtype":          ki.pkg.Type,
+		"pkgarch":          ki.pkg.Arch,
+		"installedkernels": ki.installed,
+		"rebootreasons":    ki.rebootReasons,
+	}), ki.pkg), "running kernel "+ki.release)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mozilla/scribe"
+)
+
+// Search patterns for globally installed language packages
+var (
+	pythonSitePatterns = []string{
+		"/usr/lib/python*/site-packages",
+		"/usr/lib/python*/dist-packages",
+		"/usr/lib64/python*/site-packages",
+		"/usr/local/lib/python*/site-packages",
+		"/usr/local/lib/python*/dist-packages",
+		"/usr/local/lib64/python*/site-packages",
+	}
+	npmModulePatterns = []string{
+		"/usr/lib/node_modules",
+		"/usr/local/lib/node_modules",
+	}
+	gemSpecPatterns = []string{
+		"/usr/share/gems/specifications",
+		"/usr/local/share/gems/specifications",
+		"/var/lib/gems/*/specifications",
+		"/usr/lib/ruby/gems/*/specifications",
+		"/usr/local/lib/ruby/gems/*/specifications",
+	}
+	goBinaryDirs = []string{
+		"/usr/bin",
+		"/usr/sbin",
+		"/usr/local/bin",
+		"/usr/local/sbin",
+		"/bin",
+		"/sbin",
+	}
+)
+
+// getLangPackages returns the globally installed Python, Node.js and Ruby
+// packages under root, and the modules built into any Go binaries found in
+// the standard binary directories. The package type is set to pip, npm, gem or
+// go so the lambda can route each package to the right advisory source.
+func getLangPackages(root string) []scribe.PackageInfo {
+	var ret []scribe.PackageInfo
+	ret = append(ret, getPipPackages(root)...)
+	ret = append(ret, getNpmPackages(root)...)
+	ret = append(ret, getGemPackages(root)...)
+	ret = append(ret, getGoPackages(root)...)
+	return dedupPackages(ret)
+}
+
+// dedupPackages removes duplicate entries from pkgs, such as the same module
+// built into several Go binaries
+func dedupPackages(pkgs []scribe.PackageInfo) []scribe.PackageInfo {
+	seen := make(map[scribe.PackageInfo]bool)
+	ret := make([]scribe.PackageInfo, 0, len(pkgs))
+	for _, p := range pkgs {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Type != ret[j].Type {
+			return ret[i].Type < ret[j].Type
+		}
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Version < ret[j].Version
+	})
+	return ret
+}
+
+// globRoot expands each pattern relative to root
+func globRoot(root string, patterns []string) []string {
+	var ret []string
+	for _, p := range patterns {
+		m, err := filepath.Glob(filepath.Join(root, p))
+		if err != nil {
+			continue
+		}
+		ret = append(ret, m...)
+	}
+	return ret
+}
+
+// getPipPackages reads the metadata of installed Python distributions, from
+// either dist-info or egg-info directories
+func getPipPackages(root string) (ret []scribe.PackageInfo) {
+	for _, dir := range globRoot(root, pythonSitePatterns) {
+		var files []string
+		m, _ := filepath.Glob(filepath.Join(dir, "*.dist-info", "METADATA"))
+		files = append(files, m...)
+		m, _ = filepath.Glob(filepath.Join(dir, "*.egg-info", "PKG-INFO"))
+		files = append(files, m...)
+		// Older distutils installs write egg-info as a single file
+		m, _ = filepath.Glob(filepath.Join(dir, "*.egg-info"))
+		for _, x := range m {
+			if fi, err := os.Stat(x); err == nil && fi.Mode().IsRegular() {
+				files = append(files, x)
+			}
+		}
+		for _, f := range files {
+			name, version, err := readPythonMetadata(f)
+			if err != nil || name == "" || version == "" {
+				continue
+			}
+			ret = append(ret, scribe.PackageInfo{Name: name, Version: version, Type: "pip"})
+		}
+	}
+	return
+}
+
+// readPythonMetadata returns the Name and Version headers from a Python core
+// metadata file
+func readPythonMetadata(path string) (name, version string, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		line := scn.Text()
+		if line == "" {
+			// The headers end at the first blank line
+			break
+		}
+		if strings.HasPrefix(line, "Name:") {
+			name = strings.TrimSpace(line[5:])
+		} else if strings.HasPrefix(line, "Version:") {
+			version = strings.TrimSpace(line[8:])
+		}
+	}
+	err = scn.Err()
+	return
+}
+
+// getNpmPackages reads package.json from each globally installed Node.js
+// module, including scoped modules
+func getNpmPackages(root string) (ret []scribe.PackageInfo) {
+	for _, dir := range globRoot(root, npmModulePatterns) {
+		var files []string
+		m, _ := filepath.Glob(filepath.Join(dir, "*", "package.json"))
+		files = append(files, m...)
+		m, _ = filepath.Glob(filepath.Join(dir, "@*", "*", "package.json"))
+		files = append(files, m...)
+		for _, f := range files {
+			buf, err := ioutil.ReadFile(f)
+			if err != nil {
+				continue
+			}
+			var pj struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			}
+			if json.Unmarshal(buf, &pj) != nil || pj.Name == "" || pj.Version == "" {
+				continue
+			}
+			ret = append(ret, scribe.PackageInfo{Name: pj.Name, Version: pj.Version, Type: "npm"})
+		}
+	}
+	return
+}
+
+// getGemPackages identifies installed Ruby gems from the names of their
+// specification files, which take the form name-version[-platform].gemspec
+func getGemPackages(root string) (ret []scribe.PackageInfo) {
+	for _, dir := range globRoot(root, gemSpecPatterns) {
+		m, _ := filepath.Glob(filepath.Join(dir, "*.gemspec"))
+		for _, f := range m {
+			parts := strings.Split(strings.TrimSuffix(filepath.Base(f), ".gemspec"), "-")
+			for i := 1; i < len(parts); i++ {
+				if parts[i] == "" || parts[i][0] < '0' || parts[i][0] > '9' {
+					continue
+				}
+				ret = append(ret, scribe.PackageInfo{
+					Name:    strings.Join(parts[:i], "-"),
+					Version: parts[i],
+					Type:    "gem",
+					Arch:    strings.Join(parts[i+1:], "-"),
+				})
+				break
+			}
+		}
+	}
+	return
+}
+
+// getGoPackages returns the Go toolchain version and the modules built into
+// each Go binary found in the standard binary directories
+func getGoPackages(root string) (ret []scribe.PackageInfo) {
+	for _, dir := range goBinaryDirs {
+		entries, err := ioutil.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			continue
+		}
+		for _, fi := range entries {
+			if !fi.Mode().IsRegular() || fi.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			goVersion, modinfo, err := readGoBuildInfo(filepath.Join(root, dir, fi.Name()))
+			if err != nil {
+				continue
+			}
+			ret = append(ret, scribe.PackageInfo{Name: "stdlib", Version: goVersion, Type: "go"})
+			ret = append(ret, parseGoModInfo(modinfo)...)
+		}
+	}
+	return
+}
+
+// parseGoModInfo parses the module lines of Go build information. A module
+// replaced with another module version is reported using the replacement.
+func parseGoModInfo(modinfo string) (ret []scribe.PackageInfo) {
+	for _, line := range strings.Split(modinfo, "\n") {
+		f := strings.Split(line, "\t")
+		switch {
+		case len(f) >= 3 && (f[0] == "mod" || f[0] == "dep"):
+			if f[2] == "(devel)" {
+				continue
+			}
+			ret = append(ret, scribe.PackageInfo{Name: f[1], Version: f[2], Type: "go"})
+		case len(f) >= 3 && f[0] == "=>" && len(ret) > 0:
+			if f[2] == "" {
+				// Replaced with a local directory, so there is no
+				// version to report
+				ret = ret[:len(ret)-1]
+				continue
+			}
+			ret[len(ret)-1] = scribe.PackageInfo{Name: f[1], Version: f[2], Type: "go"}
+		}
+	}
+	return
+}
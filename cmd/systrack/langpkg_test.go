@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/mozilla/scribe"
+)
+
+func TestGetLangPackages(t *testing.T) {
+	got := getLangPackages("testdata/lang")
+	want := []scribe.PackageInfo{
+		{Name: "net-http-persistent", Version: "4.0.2", Type: "gem"},
+		{Name: "nokogiri", Version: "1.15.5", Type: "gem", Arch: "x86_64-linux"},
+		{Name: "rake", Version: "13.0.6", Type: "gem"},
+		{Name: "@angular/cli", Version: "17.0.8", Type: "npm"},
+		{Name: "npm", Version: "10.2.4", Type: "npm"},
+		{Name: "PyYAML", Version: "6.0.1", Type: "pip"},
+		{Name: "distro", Version: "1.8.0", Type: "pip"},
+		{Name: "requests", Version: "2.31.0", Type: "pip"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestReadGoBuildInfo(t *testing.T) {
+	// The test binary is itself a Go binary
+	goVersion, _, err := readGoBuildInfo(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if goVersion != runtime.Version() {
+		t.Errorf("got Go version %v, want %v", goVersion, runtime.Version())
+	}
+	if _, _, err = readGoBuildInfo("testdata/lang/usr/local/lib/node_modules/npm/package.json"); err == nil {
+		t.Errorf("build information read from a file that is not a binary")
+	}
+}
+
+func TestParseGoModInfo(t *testing.T) {
+	modinfo := "path\tgithub.com/example/tool\n" +
+		"mod\tgithub.com/example/tool\t(devel)\t\n" +
+		"dep\tgolang.org/x/net\tv0.17.0\th1:pjNLBS0=\n" +
+		"dep\tgithub.com/example/fork\tv1.0.0\th1:abc=\n" +
+		"=>\tgithub.com/other/fork\tv1.0.1\th1:def=\n" +
+		"dep\tgithub.com/example/local\tv0.0.0\t\n" +
+		"=>\t../local\t\t\n" +
+		"build\t-compiler=gc\n"
+	want := []scribe.PackageInfo{
+		{Name: "golang.org/x/net", Version: "v0.17.0", Type: "go"},
+		{Name: "github.com/other/fork", Version: "v1.0.1", Type: "go"},
+	}
+	if got := parseGoModInfo(modinfo); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
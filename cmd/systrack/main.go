@@ -1,51 +1,306 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/mozilla/scribe"
 	"github.com/sirupsen/logrus"
 	"go.mozilla.org/mozlogrus"
 )
 
-func main() {
-	hook, err := mozlogrus.NewKinesisHook("secops-dumper")
-	logger := logrus.New()
-	logger.Hooks.Add(hook)
-	logger.Formatter = &mozlogrus.MozLogFormatter{LoggerName: "secops-dumper", Type: "app.log"}
+var formatter = &mozlogrus.MozLogFormatter{LoggerName: "secops-dumper", Type: "app.log"}
 
-	fqdn := getHostname()
-	issue, err := getSysInfo()
+// emit formats a record as a mozlog entry and writes it to the sink
+func emit(s sink, fields logrus.Fields, msg string) error {
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	entry.Time = time.Now()
+	if signer != nil {
+		var err error
+		fields, err = signer.sign(fields, entry.Time)
+		if err != nil {
+			return err
+		}
+	}
+	entry.Data = fields
+	entry.Level = logrus.InfoLevel
+	entry.Message = msg
+	buf, err := formatter.Format(entry)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	return s.write(buf)
+}
+
+// withFields returns a copy of base with the fields in extra added
+func withFields(base logrus.Fields, extra logrus.Fields) logrus.Fields {
+	ret := make(logrus.Fields, len(base)+len(extra))
+	for k, v := range base {
+		ret[k] = v
+	}
+	for k, v := range extra {
+		ret[k] = v
+	}
+	return ret
+}
+
+// emitPackages writes a record for each package in pkgs, including the host
+// fields and the source package from sources in each record
+func emitPackages(s sink, host logrus.Fields, pkgs []scribe.PackageInfo, sources pkgSources) error {
+	for _, pkg := range pkgs {
+		err := emit(s, sources.add(withFields(host, logrus.Fields{
+			"rectype":    "package",
+			"pkgname":    pkg.Name,
+			"pkgversion": pkg.Version,
+			"pkgtype":    pkg.Type,
+			"pkgarch":    pkg.Arch,
+		}), pkg), "package "+pkg.Name+" "+pkg.Version+" "+pkg.Type+" "+pkg.Arch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitInventory writes pkgs as package records or as a snapshot, depending on
+// the record format
+func emitInventory(s sink, host logrus.Fields, format string, pkgs []scribe.PackageInfo,
+	sources pkgSources) error {
+	switch format {
+	case "package":
+		return emitPackages(s, host, pkgs, sources)
+	case "snapshot":
+		return emitSnapshot(s, host, pkgs, sources)
+	}
+	return fmt.Errorf("unknown record format %q", format)
+}
+
+// options holds the settings given on the command line or in the
+// configuration file
+type options struct {
+	config       string
+	logger       string
+	output       string
+	cloud        string
+	imdsv1       bool
+	format       string
+	statePath    string
+	fullEvery    int
+	lang         bool
+	containers   bool
+	sockets      bool
+	restart      bool
+	accounts     bool
+	services     bool
+	fileAudit    bool
+	auditPaths   string // Comma separated
+	auditBudget  time.Duration
+	auditRate    int    // Files per second
+	policy       string // Scribe policy document, or a directory of them
+	root         string
+	spool        string
+	spoolMaxSize int64 // Megabytes
+	spoolMaxAge  time.Duration
+	spoolFlush   bool
+	signKey      string // Path to the signing key
+	signAlg      string
+	signKeyID    string
+
+	// Only set in the configuration file
+	sink             sinkOptions
+	metadataEndpoint string
+	static           instanceInfo // Reported by the static provider
+	metadataTimeout  time.Duration
+	dockerTimeout    time.Duration
+	tags             map[string]string // Static tags added to every record
+	fields           []string          // If set, the only host fields included in records
+
+	// Daemon mode only
+	interval  time.Duration
+	splay     time.Duration
+	schedule  string
+	intervals map[string]time.Duration // Collector intervals from the configuration file
+}
+
+// setFlags registers the options shared by one-shot and daemon mode in fs
+func setFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.config, "config", "",
+		"path to a YAML configuration file, flags given on the command line take precedence over it")
+	fs.StringVar(&o.logger, "logger", "secops-dumper",
+		"logger name in submitted records")
+	fs.StringVar(&o.output, "output", "kinesis:secops-dumper",
+		"record destination: stdout, file:<path>, http(s)://<url> or kinesis:<stream>")
+	fs.StringVar(&o.cloud, "cloud", "auto",
+		"metadata provider: auto, aws, gce, azure, openstack, or none for the static instance details in the configuration file")
+	fs.BoolVar(&o.imdsv1, "imdsv1", false,
+		"allow falling back to IMDSv1 if an IMDSv2 session token cannot be obtained")
+	fs.StringVar(&o.format, "format", "package",
+		"record format: package for one record per package, or snapshot for one record per host")
+	fs.StringVar(&o.statePath, "state", "",
+		"path to a state file, if set only changes since the last run are reported")
+	fs.IntVar(&o.fullEvery, "full-every", 24,
+		"when using a state file, send a full report every this many runs")
+	fs.BoolVar(&o.lang, "lang", false,
+		"also report globally installed pip, npm and gem packages and modules in Go binaries")
+	fs.BoolVar(&o.containers, "containers", false,
+		"also report the packages in the images of running Docker and containerd containers")
+	fs.BoolVar(&o.sockets, "sockets", false,
+		"also report listening sockets with the process and package listening on them")
+	fs.BoolVar(&o.restart, "restart", false,
+		"also report processes that need a restart, as they use deleted or replaced files of an upgraded package")
+	fs.BoolVar(&o.accounts, "accounts", false,
+		"also report local accounts, privileged group members, sudoers rules and authorized SSH key fingerprints")
+	fs.BoolVar(&o.services, "services", false,
+		"also report systemd units and SysV init scripts, with their state and owning package")
+	fs.BoolVar(&o.fileAudit, "fileaudit", false,
+		"also report setuid and setgid files and world-writable files and directories, with their hash and owning package")
+	fs.StringVar(&o.auditPaths, "fileaudit-paths", strings.Join(defaultAuditPaths, ","),
+		"comma separated paths the file audit walks, without crossing into other filesystems")
+	fs.DurationVar(&o.auditBudget, "fileaudit-budget", 5*time.Minute,
+		"maximum time the file audit runs for, after which it reports what it has found as incomplete")
+	fs.IntVar(&o.auditRate, "fileaudit-rate", 2000,
+		"maximum files the file audit examines each second, 0 for no limit")
+	fs.StringVar(&o.policy, "policy", "",
+		"scribe policy document, or directory of documents, to evaluate, reporting whether each test passes")
+	fs.StringVar(&o.root, "root", "/",
+		"inventory the filesystem mounted at this path, such as an image, a chroot or the host filesystem mounted in a container")
+	fs.StringVar(&o.spool, "spool", "",
+		"spool directory, if set each run is written here and then delivered, with failed runs retried later")
+	fs.Int64Var(&o.spoolMaxSize, "spool-max-size", 100,
+		"maximum size of the spool in megabytes, the oldest runs are dropped beyond it")
+	fs.DurationVar(&o.spoolMaxAge, "spool-max-age", 7*24*time.Hour,
+		"runs older than this are dropped from the spool")
+	fs.BoolVar(&o.spoolFlush, "spool-flush", false,
+		"deliver the runs in the spool now, ignoring the retry delay, and exit")
+	fs.StringVar(&o.signKey, "sign-key", "",
+		"path to a base64 encoded key, if set each record is signed with it")
+	fs.StringVar(&o.signAlg, "sign-alg", sigAlgEd25519,
+		"signature algorithm: ed25519 or hmac-sha256")
+	fs.StringVar(&o.signKeyID, "sign-keyid", "",
+		"key identifier included in signed records, the hostname if not set")
+}
+
+// validate checks the options for errors that would otherwise only show up
+// part way through a run
+func (o *options) validate() error {
+	if o.format != "package" && o.format != "snapshot" {
+		return fmt.Errorf("format must be package or snapshot, not %q", o.format)
 	}
-	dist, err := getDist()
+	if o.spoolFlush && o.spool == "" {
+		return fmt.Errorf("-spool-flush requires a spool directory")
+	}
+	if o.logger == "" {
+		return fmt.Errorf("logger name must not be empty")
+	}
+	for _, p := range strings.Split(o.auditPaths, ",") {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("file audit path %q is not an absolute path", p)
+		}
+	}
+	if o.auditBudget < time.Second || o.auditRate < 0 {
+		return fmt.Errorf("file audit budget must be at least a second and rate not negative")
+	}
+	return checkOutput(o.output)
+}
+
+// parseOptions parses the command line args using fs. If a configuration file
+// is given it is loaded over the defaults, and the command line parsed again
+// so the flags given there take precedence.
+func parseOptions(fs *flag.FlagSet, args []string, o *options) error {
+	o.sink.httpTimeout = 30 * time.Second
+	o.metadataTimeout = 5 * time.Second
+	o.dockerTimeout = 10 * time.Second
+	fs.Parse(args)
+	if o.config != "" {
+		err := loadConfig(o.config, o)
+		if err != nil {
+			return err
+		}
+		fs.Parse(args)
+	}
+	err := o.validate()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	formatter.LoggerName = o.logger
+	if o.signKey != "" {
+		keyID := o.signKeyID
+		if keyID == "" {
+			keyID = getHostname()
+		}
+		signer, err = newRecordSigner(o.signAlg, keyID, o.signKey)
+		if err != nil {
+			return err
+		}
+	}
+	metaClient.Timeout = o.metadataTimeout
+	dockerTimeout = o.dockerTimeout
+	return nil
+}
+
+// newOutput returns the sink records are written to, which is the spool if
+// one is configured
+func (o *options) newOutput() (sink, error) {
+	if o.spool != "" {
+		return newSpoolSink(o.spool, o.output, o.sink, o.spoolMaxSize<<20, o.spoolMaxAge)
+	}
+	return newSink(o.output, o.sink)
+}
+
+func main() {
+	var o options
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+		alg := fs.String("alg", sigAlgEd25519, "signature algorithm: ed25519 or hmac-sha256")
+		fs.Parse(os.Args[2:])
+		err := generateKey(*alg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "daemon" {
+		fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+		setFlags(fs, &o)
+		setDaemonFlags(fs, &o)
+		err := parseOptions(fs, os.Args[2:], &o)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = runDaemon(&o)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	instanceid, instancetype, localip, ami, err := getAWSMetadata()
+
+	setFlags(flag.CommandLine, &o)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v [flags]\n       %v daemon [flags]\n       %v keygen [-alg alg]\n\n",
+			os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	err := parseOptions(flag.CommandLine, os.Args[1:], &o)
 	if err != nil {
 		log.Fatal(err)
 	}
-	tags, err := getInstanceTags(instanceid)
+	if o.spoolFlush {
+		err = deliverSpool(o.spool, o.output, o.sink, o.spoolMaxSize<<20, o.spoolMaxAge, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	provider, err := newMetadataProvider(o.cloud, o.metadataEndpoint, o.imdsv1, o.static)
 	if err != nil {
 		log.Fatal(err)
 	}
-	// get a list of all system packages
-	for _, pkg := range scribe.QueryPackages() {
-		logger.WithFields(logrus.Fields{
-			"fqdn":         fqdn,
-			"dist":         dist,
-			"issue":        issue,
-			"instanceid":   instanceid,
-			"instancetype": instancetype,
-			"instancetags": tags,
-			"localip":      localip,
-			"ami":          ami,
-			"pkgname":      pkg.Name,
-			"pkgversion":   pkg.Version,
-			"pkgtype":      pkg.Type,
-			"pkgarch":      pkg.Arch,
-		}).Info("package " + pkg.Name + " " + pkg.Version + " " + pkg.Type + " " + pkg.Arch)
+	err = runCollectors(&o, provider, enabledCollectors(&o))
+	if err != nil {
+		log.Fatal(err)
 	}
 }
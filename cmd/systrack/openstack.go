@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// openstackProvider fetches instance information from the OpenStack Nova
+// metadata service
+type openstackProvider struct {
+	baseURL string
+}
+
+// openstackMeta includes the fields of meta_data.json we use
+type openstackMeta struct {
+	UUID      string            `json:"uuid"`
+	ProjectID string            `json:"project_id"`
+	Meta      map[string]string `json:"meta"`
+}
+
+func (o *openstackProvider) name() string {
+	return "openstack"
+}
+
+func (o *openstackProvider) detect() bool {
+	_, err := o.getMeta()
+	return err == nil
+}
+
+func (o *openstackProvider) fetch() (info instanceInfo, err error) {
+	meta, err := o.getMeta()
+	if err != nil {
+		return
+	}
+	info.id = meta.UUID
+	info.account = meta.ProjectID
+	for k, v := range meta.Meta {
+		info.tags = append(info.tags, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(info.tags)
+	// The flavor, address and image are only exposed through the EC2 compatible
+	// API, which deployments can disable, so treat these as optional
+	info.instanceType, _ = o.ec2FetchMeta("instance-type")
+	info.localIP, _ = o.ec2FetchMeta("local-ipv4")
+	info.image, _ = o.ec2FetchMeta("ami-id")
+	return
+}
+
+func (o *openstackProvider) getMeta() (meta openstackMeta, err error) {
+	buf, err := fetchMeta(o.baseURL+"/openstack/latest/meta_data.json", nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(buf), &meta)
+	if err != nil {
+		return
+	}
+	if meta.UUID == "" {
+		err = fmt.Errorf("openstack metadata had no uuid")
+	}
+	return
+}
+
+func (o *openstackProvider) ec2FetchMeta(endpoint string) (string, error) {
+	return fetchMeta(o.baseURL+"/latest/meta-data/"+endpoint, nil)
+}
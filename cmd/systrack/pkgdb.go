@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// pkgSource is the source package a binary package was built from. Debian,
+// Ubuntu and Alpine advisories name source packages rather than each binary
+// package built from them.
+type pkgSource struct {
+	name    string
+	version string // Set if it differs from the version of the binary package
+}
+
+// pkgSources maps packages, by sourceKey, to the source package they were built
+// from. Packages built from a source package of the same name and version are
+// not included.
+type pkgSources map[string]pkgSource
+
+// sourceKey identifies pkg in pkgSources
+func sourceKey(pkg scribe.PackageInfo) string {
+	return pkg.Type + "/" + pkg.Name + "/" + pkg.Arch + "/" + pkg.Version
+}
+
+// add adds the fields naming the source package of pkg to fields, if it has
+// one, and returns fields
+func (s pkgSources) add(fields logrus.Fields, pkg scribe.PackageInfo) logrus.Fields {
+	src, ok := s[sourceKey(pkg)]
+	if !ok {
+		return fields
+	}
+	fields["pkgsource"] = src.name
+	if src.version != "" {
+		fields["pkgsourceversion"] = src.version
+	}
+	return fields
+}
+
+// getRootPackages reads the packages installed in the filesystem at root from
+// the dpkg, apk and rpm databases found there, without running any package
+// manager commands, along with the source packages they were built from
+func getRootPackages(root string) ([]scribe.PackageInfo, pkgSources) {
+	var ret []scribe.PackageInfo
+	sources := make(pkgSources)
+	pkgs, err := readDpkgStatus(resolveInRoot(root, "/var/lib/dpkg/status"), sources)
+	if err == nil {
+		ret = append(ret, pkgs...)
+	}
+	pkgs, err = readApkInstalled(resolveInRoot(root, "/lib/apk/db/installed"), nil, sources)
+	if err == nil {
+		ret = append(ret, pkgs...)
+	}
+	pkgs, err = readRpmDB(root)
+	if err == nil {
+		ret = append(ret, pkgs...)
+	} else if err != errNoRpmDB {
+		log.Printf("%v\n", err)
+	}
+	return ret, sources
+}
+
+// readDpkgStatus parses a dpkg status file, returning the packages that are
+// currently installed. If sources is not nil, the source package of each
+// package is added to it.
+func readDpkgStatus(path string, sources pkgSources) (ret []scribe.PackageInfo, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	var (
+		pkg       scribe.PackageInfo
+		src       pkgSource
+		installed bool
+	)
+	flush := func() {
+		if installed && pkg.Name != "" && pkg.Version != "" {
+			pkg.Type = "dpkg"
+			ret = append(ret, pkg)
+			if src.name == "" {
+				src.name = pkg.Name
+			}
+			if src.version == pkg.Version {
+				src.version = ""
+			}
+			if sources != nil && (src.name != pkg.Name || src.version != "") {
+				sources[sourceKey(pkg)] = src
+			}
+		}
+		pkg = scribe.PackageInfo{}
+		src = pkgSource{}
+		installed = false
+	}
+	scn := bufio.NewScanner(fd)
+	scn.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scn.Scan() {
+		line := scn.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// Continuation of a multi-line field such as Description
+			continue
+		}
+		n := strings.Index(line, ":")
+		if n == -1 {
+			continue
+		}
+		val := strings.TrimSpace(line[n+1:])
+		switch line[:n] {
+		case "Package":
+			pkg.Name = val
+		case "Version":
+			pkg.Version = val
+		case "Architecture":
+			pkg.Arch = val
+		case "Source":
+			// The source package name, followed by its version in
+			// parentheses if the binary package version differs, as
+			// for a binary-only rebuild
+			f := strings.Fields(val)
+			if len(f) > 0 {
+				src.name = f[0]
+			}
+			if len(f) > 1 {
+				src.version = strings.Trim(f[1], "()")
+			}
+		case "Status":
+			// The status is want, error flag and state, for example
+			// "install ok installed"
+			f := strings.Fields(val)
+			installed = len(f) == 3 && f[2] == "installed"
+		}
+	}
+	flush()
+	err = scn.Err()
+	return
+}
+
+// readApkInstalled parses the Alpine apk installed database. If owners is not
+// nil, the files installed by each package are added to it, and if sources is
+// not nil, the origin of each package is.
+func readApkInstalled(path string, owners fileOwners, sources pkgSources) (ret []scribe.PackageInfo, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	var (
+		pkg    scribe.PackageInfo
+		origin string
+		dir    string
+		files  []string
+	)
+	flush := func() {
+		if pkg.Name != "" && pkg.Version != "" {
+			pkg.Type = "apk"
+			ret = append(ret, pkg)
+			for _, f := range files {
+				owners[f] = pkg
+			}
+			// A package always has the version of its origin
+			if sources != nil && origin != "" && origin != pkg.Name {
+				sources[sourceKey(pkg)] = pkgSource{name: origin}
+			}
+		}
+		pkg = scribe.PackageInfo{}
+		origin = ""
+		files = files[:0]
+	}
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		line := scn.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		switch line[0] {
+		case 'P':
+			pkg.Name = line[2:]
+		case 'V':
+			pkg.Version = line[2:]
+		case 'A':
+			pkg.Arch = line[2:]
+		case 'o':
+			// The name of the APKBUILD the package was built from,
+			// which the secdb lists vulnerabilities under
+			origin = line[2:]
+		case 'F':
+			dir = "/" + line[2:]
+		case 'R':
+			if owners != nil {
+				files = append(files, filepath.Join(dir, line[2:]))
+			}
+		}
+	}
+	flush()
+	err = scn.Err()
+	return
+}
+
+// fileOwners maps the path of each file installed by a package to the package
+type fileOwners map[string]scribe.PackageInfo
+
+// getFileOwners returns the packages owning the files installed in the
+// filesystem at root, according to the dpkg, apk and rpm databases
+func getFileOwners(root string) fileOwners {
+	owners := make(fileOwners)
+	err := readDpkgFiles(root, owners)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("%v\n", err)
+	}
+	_, err = readApkInstalled(resolveInRoot(root, "/lib/apk/db/installed"), owners, nil)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("%v\n", err)
+	}
+	hdrs, path, err := readRpmHeaders(root)
+	if err != nil && err != errNoRpmDB {
+		log.Printf("%v: %v\n", path, err)
+	}
+	for _, h := range hdrs {
+		pkg, err := h.pkg()
+		if err != nil {
+			continue
+		}
+		for _, f := range h.files() {
+			owners[f] = pkg
+		}
+	}
+	return owners
+}
+
+// owner returns the package owning the file at path. On systems where /bin,
+// /sbin and /lib are links into /usr, packages may list a file under either
+// path, so both are tried.
+func (o fileOwners) owner(path string) (scribe.PackageInfo, bool) {
+	if pkg, ok := o[path]; ok {
+		return pkg, true
+	}
+	for _, d := range []string{"/bin/", "/sbin/", "/lib/", "/lib64/"} {
+		if strings.HasPrefix(path, "/usr"+d) {
+			pkg, ok := o[strings.TrimPrefix(path, "/usr")]
+			return pkg, ok
+		}
+		if strings.HasPrefix(path, d) {
+			pkg, ok := o["/usr"+path]
+			return pkg, ok
+		}
+	}
+	return scribe.PackageInfo{}, false
+}
+
+// readDpkgFiles adds the files listed for each installed dpkg package to
+// owners
+func readDpkgFiles(root string, owners fileOwners) error {
+	pkgs, err := readDpkgStatus(resolveInRoot(root, "/var/lib/dpkg/status"), nil)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]scribe.PackageInfo)
+	for _, p := range pkgs {
+		byName[p.Name] = p
+		byName[p.Name+":"+p.Arch] = p
+	}
+	lists, err := filepath.Glob(filepath.Join(resolveInRoot(root, "/var/lib/dpkg/info"), "*.list"))
+	if err != nil {
+		return err
+	}
+	for _, l := range lists {
+		// Named package.list, or package:arch.list for multiarch packages
+		pkg, ok := byName[strings.TrimSuffix(filepath.Base(l), ".list")]
+		if !ok {
+			continue
+		}
+		fd, err := os.Open(l)
+		if err != nil {
+			continue
+		}
+		scn := bufio.NewScanner(fd)
+		for scn.Scan() {
+			owners[scn.Text()] = pkg
+		}
+		fd.Close()
+	}
+	return nil
+}
+
+// maxSymlinks limits the number of symbolic links followed by resolveInRoot
+const maxSymlinks = 40
+
+// resolveInRoot returns the path to name in the filesystem at root, following
+// symbolic links as if root were the root directory. Absolute links in a
+// container or mounted image, such as /etc/os-release pointing to
+// /usr/lib/os-release, would otherwise resolve against the host filesystem.
+func resolveInRoot(root, name string) string {
+	if root == "/" || root == "" {
+		return name
+	}
+	var (
+		resolved string
+		links    int
+	)
+	rest := strings.Split(filepath.Clean("/"+name), "/")
+	for len(rest) > 0 {
+		comp := rest[0]
+		rest = rest[1:]
+		if comp == "" || comp == "." {
+			continue
+		}
+		if comp == ".." {
+			resolved = filepath.Dir(resolved)
+			if resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+		next := filepath.Join(resolved, comp)
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			// Not a link, or does not exist, either way use it as is
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			break
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return filepath.Join(root, resolved)
+}
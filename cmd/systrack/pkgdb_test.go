@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// The rpm databases in testdata/pkgdb hold the same packages in each backend.
+// The one in sqlite-wal was copied with a write-ahead log that adds
+// kernel-core, removes tzdata, and ends with the frames of a transaction that
+// had not committed.
+var rpmTestPackages = []scribe.PackageInfo{
+	{Name: "bash", Version: "5.1.8-9.el9", Arch: "x86_64", Type: "rpm"},
+	{Name: "gpg-pubkey", Version: "5a6340b3-6229229e", Arch: "(none)", Type: "rpm"},
+	{Name: "kernel-core", Version: "5.14.0-427.13.1.el9_4", Arch: "x86_64", Type: "rpm"},
+	{Name: "openssl-libs", Version: "1:3.0.7-27.el9", Arch: "x86_64", Type: "rpm"},
+	{Name: "tzdata", Version: "2024a-1.el9", Arch: "noarch", Type: "rpm"},
+}
+
+// sortPackages sorts pkgs by name, as databases return them in storage order
+func sortPackages(pkgs []scribe.PackageInfo) []scribe.PackageInfo {
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs
+}
+
+func TestReadRpmDB(t *testing.T) {
+	without := func(name string) (ret []scribe.PackageInfo) {
+		for _, p := range rpmTestPackages {
+			if p.Name != name {
+				ret = append(ret, p)
+			}
+		}
+		return
+	}
+	tests := []struct {
+		root string
+		want []scribe.PackageInfo
+	}{
+		{"bdb", rpmTestPackages},
+		{"ndb", without("tzdata")},
+		{"sqlite", rpmTestPackages},
+		{"sqlite-wal", without("tzdata")},
+	}
+	for _, tc := range tests {
+		got, err := readRpmDB(filepath.Join("testdata/pkgdb", tc.root))
+		if err != nil {
+			t.Errorf("%v: %v", tc.root, err)
+			continue
+		}
+		if !reflect.DeepEqual(sortPackages(got), tc.want) {
+			t.Errorf("%v: got %+v\nwant %+v", tc.root, got, tc.want)
+		}
+	}
+	_, err := readRpmDB("testdata/pkgdb/dpkg")
+	if err != errNoRpmDB {
+		t.Errorf("root without rpm database: got %v, want %v", err, errNoRpmDB)
+	}
+}
+
+func TestRpmFileOwners(t *testing.T) {
+	for _, root := range []string{"bdb", "ndb", "sqlite", "sqlite-wal"} {
+		owners := getFileOwners(filepath.Join("testdata/pkgdb", root))
+		for path, want := range map[string]string{
+			"/usr/bin/bash":             "bash",
+			"/bin/sh":                   "bash",
+			"/usr/lib64/libcrypto.so.3": "openssl-libs",
+			"/boot/vmlinuz-5.14.0-427.13.1.el9_4.x86_64": "kernel-core",
+		} {
+			pkg, ok := owners.owner(path)
+			if !ok || pkg.Name != want {
+				t.Errorf("%v: owner of %v is %v, want %v", root, path, pkg.Name, want)
+			}
+		}
+	}
+}
+
+func TestReadDpkgStatus(t *testing.T) {
+	sources := make(pkgSources)
+	got, err := readDpkgStatus("testdata/pkgdb/dpkg/var/lib/dpkg/status", sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// libssl1.1 is removed with only its configuration files left
+	want := []scribe.PackageInfo{
+		{Name: "base-files", Version: "12.4+deb12u12", Arch: "amd64", Type: "dpkg"},
+		{Name: "bash", Version: "5.2.15-2+b9", Arch: "amd64", Type: "dpkg"},
+		{Name: "libc6", Version: "2.36-9+deb12u13", Arch: "amd64", Type: "dpkg"},
+		{Name: "libcurl4", Version: "7.88.1-10+deb12u14", Arch: "amd64", Type: "dpkg"},
+		{Name: "libgcc-s1", Version: "12.2.0-14+deb12u1", Arch: "amd64", Type: "dpkg"},
+		{Name: "libssl3", Version: "3.0.17-1~deb12u2", Arch: "amd64", Type: "dpkg"},
+		{Name: "tzdata", Version: "2025b-0+deb12u2", Arch: "all", Type: "dpkg"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	// bash is a binary-only rebuild, so its source has another version, and
+	// base-files and tzdata are built from a source of the same name
+	wantSources := pkgSources{
+		"dpkg/bash/amd64/5.2.15-2+b9":            {name: "bash", version: "5.2.15-2"},
+		"dpkg/libc6/amd64/2.36-9+deb12u13":       {name: "glibc"},
+		"dpkg/libcurl4/amd64/7.88.1-10+deb12u14": {name: "curl"},
+		"dpkg/libgcc-s1/amd64/12.2.0-14+deb12u1": {name: "gcc-12"},
+		"dpkg/libssl3/amd64/3.0.17-1~deb12u2":    {name: "openssl"},
+	}
+	if !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("got sources %+v\nwant %+v", sources, wantSources)
+	}
+	fields := sources.add(logrus.Fields{}, want[1])
+	if fields["pkgsource"] != "bash" || fields["pkgsourceversion"] != "5.2.15-2" {
+		t.Errorf("bash source fields %v", fields)
+	}
+
+	owners := getFileOwners("testdata/pkgdb/dpkg")
+	for path, want := range map[string]string{
+		"/bin/bash":                             "bash",
+		"/usr/lib/x86_64-linux-gnu/libssl.so.3": "libssl3",
+	} {
+		pkg, ok := owners.owner(path)
+		if !ok || pkg.Name != want {
+			t.Errorf("owner of %v is %v, want %v", path, pkg.Name, want)
+		}
+	}
+}
+
+func TestReadApkInstalled(t *testing.T) {
+	owners := make(fileOwners)
+	sources := make(pkgSources)
+	got, err := readApkInstalled("testdata/pkgdb/apk/lib/apk/db/installed", owners, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scribe.PackageInfo{
+		{Name: "musl", Version: "1.2.4_git20230717-r4", Arch: "x86_64", Type: "apk"},
+		{Name: "libcrypto3", Version: "3.1.4-r5", Arch: "x86_64", Type: "apk"},
+		{Name: "libssl3", Version: "3.1.4-r5", Arch: "x86_64", Type: "apk"},
+		{Name: "busybox", Version: "1.36.1-r15", Arch: "x86_64", Type: "apk"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	// musl and busybox are their own origin
+	wantSources := pkgSources{
+		"apk/libcrypto3/x86_64/3.1.4-r5": {name: "openssl"},
+		"apk/libssl3/x86_64/3.1.4-r5":    {name: "openssl"},
+	}
+	if !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("got sources %+v\nwant %+v", sources, wantSources)
+	}
+	for path, want := range map[string]string{
+		"/lib/libssl.so.3":          "libssl3",
+		"/etc/ssl/openssl.cnf.dist": "libcrypto3",
+		"/bin/busybox":              "busybox",
+		"/etc/udhcpd.conf":          "busybox",
+	} {
+		pkg, ok := owners.owner(path)
+		if !ok || pkg.Name != want {
+			t.Errorf("owner of %v is %v, want %v", path, pkg.Name, want)
+		}
+	}
+}
+
+func TestCorruptRpmDB(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		name  string
+		path  string
+		patch func(t *testing.T, buf []byte)
+	}{
+		{
+			// The length of the value kept in overflow pages
+			"bdb overflow length", "bdb/var/lib/rpm/Packages",
+			func(t *testing.T, buf []byte) {
+				// The item for kernel-core, stored from page 3
+				item := []byte{bdbItemOffPage, 0, 0, 0, 3, 0, 0, 0}
+				n := bytes.Index(buf[4096:], item)
+				if n == -1 {
+					t.Fatal("no overflow item")
+				}
+				le.PutUint32(buf[4096+n+8:], 0xffffffff)
+			},
+		},
+		{
+			"ndb blob length", "ndb/usr/lib/sysimage/rpm/Packages.db",
+			func(t *testing.T, buf []byte) {
+				blkOff := le.Uint32(buf[ndbHeaderSize+8:])
+				le.PutUint32(buf[blkOff*ndbBlockSize+12:], 0xffffffff)
+			},
+		},
+		{
+			"ndb slot pages", "ndb/usr/lib/sysimage/rpm/Packages.db",
+			func(t *testing.T, buf []byte) {
+				le.PutUint32(buf[12:], 2048)
+			},
+		},
+	}
+	for _, tc := range tests {
+		buf, err := ioutil.ReadFile(filepath.Join("testdata/pkgdb", tc.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.patch(t, buf)
+		root := tempDir(t)
+		defer os.RemoveAll(root)
+		writeFile(t, root, tc.path[strings.Index(tc.path, "/"):], string(buf))
+		_, err = readRpmDB(root)
+		if err == nil {
+			t.Errorf("%v: no error", tc.name)
+		}
+	}
+}
+
+func TestTruncatedRpmDB(t *testing.T) {
+	// Reading a database cut short at any point returns an error or the
+	// packages that were complete, and never panics
+	for _, path := range []string{
+		"bdb/var/lib/rpm/Packages",
+		"ndb/usr/lib/sysimage/rpm/Packages.db",
+		"sqlite/var/lib/rpm/rpmdb.sqlite",
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join("testdata/pkgdb", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := tempDir(t)
+		defer os.RemoveAll(root)
+		for n := 0; n < len(buf); n += 509 {
+			writeFile(t, root, path[strings.Index(path, "/"):], string(buf[:n]))
+			readRpmDB(root)
+		}
+	}
+}
+
+func TestSqliteCellPayloadSize(t *testing.T) {
+	db := &sqliteDB{pageSize: 4096, usable: 4096, pages: 8}
+	tests := []struct {
+		name string
+		cell []byte
+		ok   bool
+	}{
+		{"local", []byte{0x03, 0x01, 'a', 'b', 'c'}, true},
+		{"beyond cell", []byte{0x05, 0x01, 'a', 'b', 'c'}, false},
+		// A size of 2^56, which is more than the database holds
+		{"beyond database", []byte{0x81, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00, 0x01}, false},
+	}
+	for _, tc := range tests {
+		_, err := db.leafPayload(tc.cell)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v: got error %v", tc.name, err)
+		}
+	}
+}
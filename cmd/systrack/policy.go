@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// policyResult is the result of a test in a scribe policy document
+type policyResult struct {
+	policy string // Name of the document, its file name without the extension
+	scribe.TestResult
+}
+
+// policyFiles returns the scribe documents at path, which is either a single
+// document or a directory of JSON and YAML documents
+func policyFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, fi := range fis {
+		switch filepath.Ext(fi.Name()) {
+		case ".json", ".yaml", ".yml":
+			ret = append(ret, filepath.Join(path, fi.Name()))
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// evaluatePolicy evaluates a scribe policy document against the host,
+// returning the result of each test it contains
+func evaluatePolicy(path string) (ret []policyResult, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	doc, err := scribe.LoadDocument(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	err = scribe.AnalyzeDocument(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, id := range doc.GetTestIdentifiers() {
+		tr, err := scribe.GetResults(&doc, id)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		ret = append(ret, policyResult{policy: name, TestResult: tr})
+	}
+	return
+}
+
+// emitPolicies evaluates the scribe policy documents at path and writes a
+// record with the result of each test. A document that cannot be loaded does
+// not stop the others being evaluated, and the first such error is returned.
+func emitPolicies(s sink, host logrus.Fields, path string) error {
+	files, err := policyFiles(path)
+	if err != nil {
+		return err
+	}
+	var perr error
+	for _, f := range files {
+		results, err := evaluatePolicy(f)
+		if err != nil {
+			if perr == nil {
+				perr = err
+			} else {
+				log.Printf("%v\n", err)
+			}
+			continue
+		}
+		for _, r := range results {
+			err = emitPolicyResult(s, host, r)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return perr
+}
+
+// emitPolicyResult writes the record for a single policy test. The result is
+// pass if the test evaluated to true, fail if not, or error if the test could
+// not be evaluated, such as a file it examines not existing.
+func emitPolicyResult(s sink, host logrus.Fields, r policyResult) error {
+	result := "fail"
+	switch {
+	case r.IsError:
+		result = "error"
+	case r.MasterResult:
+		result = "pass"
+	}
+	tags := make(map[string]string)
+	for _, t := range r.Tags {
+		tags[t.Key] = t.Value
+	}
+	// The sources the test examined, such as files, with whether each
+	// matched
+	sources := make(map[string]bool)
+	for _, x := range r.Results {
+		sources[x.Identifier] = sources[x.Identifier] || x.Result
+	}
+	name := r.TestName
+	if name == "" {
+		name = r.TestID
+	}
+	return emit(s, withFields(host, logrus.Fields{
+		"rectype":     "policy",
+		"policy":      r.policy,
+		"testid":      r.TestID,
+		"testname":    name,
+		"description": r.Description,
+		"testtags":    tags,
+		"result":      result,
+		"error":       r.Error,
+		"sources":     sources,
+	}), fmt.Sprintf("policy %v test %v: %v", r.policy, r.TestID, result))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestPolicyFiles(t *testing.T) {
+	// README is not a JSON or YAML document
+	got, err := policyFiles("testdata/policy/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"testdata/policy/docs/banner.json",
+		"testdata/policy/docs/broken.yml",
+		"testdata/policy/docs/sshd.yaml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	got, err = policyFiles("testdata/policy/docs/sshd.yaml")
+	if err != nil || !reflect.DeepEqual(got, want[2:]) {
+		t.Errorf("got %v, %v for a single document", got, err)
+	}
+	if _, err = policyFiles("testdata/policy/missing"); err == nil {
+		t.Errorf("no error for a missing path")
+	}
+}
+
+func TestEmitPolicies(t *testing.T) {
+	out := &recordSink{}
+	err := emitPolicies(out, logrus.Fields{"fqdn": "host1"}, "testdata/policy/docs")
+	// The broken document is reported, but does not stop the others
+	if err == nil || !strings.HasPrefix(err.Error(), "testdata/policy/docs/broken.yml: ") {
+		t.Errorf("got error %v, want the broken document", err)
+	}
+
+	type result struct {
+		policy, name, result, err string
+		tags                      map[string]interface{}
+		sources                   map[string]interface{}
+	}
+	sshdConfig := map[string]interface{}{"testdata/policy/etc/ssh/sshd_config": true}
+	// Tests without a name are named by their identifier, and a test of a
+	// file that does not exist fails with no sources
+	want := map[string]result{
+		"managed-header":  {"banner", "managed-header", "pass", "", map[string]interface{}{}, sshdConfig},
+		"issue-banner":    {"banner", "issue-banner", "fail", "", map[string]interface{}{}, map[string]interface{}{}},
+		"managed-version": {"banner", "managed-version", "error", "invalid evr operation newer", map[string]interface{}{}, map[string]interface{}{}},
+		"sshd-root-login": {"sshd", "Root cannot log in over SSH", "pass", "", map[string]interface{}{}, sshdConfig},
+		"sshd-password-auth": {"sshd", "sshd-password-auth", "fail", "", map[string]interface{}{"severity": "high"},
+			map[string]interface{}{"testdata/policy/etc/ssh/sshd_config": false}},
+	}
+	if len(out.records) != len(want) {
+		t.Fatalf("got %v records, want %v", len(out.records), len(want))
+	}
+	for _, rec := range out.records {
+		var r struct {
+			Fields map[string]interface{}
+		}
+		if err := json.Unmarshal([]byte(rec), &r); err != nil {
+			t.Fatal(err)
+		}
+		f := r.Fields
+		id, _ := f["testid"].(string)
+		w, ok := want[id]
+		if !ok {
+			t.Errorf("unexpected record %v", rec)
+			continue
+		}
+		got := result{f["policy"].(string), f["testname"].(string), f["result"].(string), f["error"].(string),
+			f["testtags"].(map[string]interface{}), f["sources"].(map[string]interface{})}
+		if f["rectype"] != "policy" || f["fqdn"] != "host1" || !reflect.DeepEqual(got, w) {
+			t.Errorf("%v: got %+v, want %+v", id, got, w)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// staleProcess is a process using files that have since been deleted or
+// replaced, which belong to a package. The process still runs the code of the
+// package version that was installed when it started.
+type staleProcess struct {
+	pid            int
+	process        string
+	exe            string
+	pkg            scribe.PackageInfo
+	runningVersion string   // Version before the last upgrade, if known
+	files          []string // Deleted files of pkg in use by the process
+}
+
+// getStaleProcesses returns the processes with a deleted executable or mapped
+// library belonging to a package, one entry per process and package. Processes
+// in containers are skipped, as their files do not belong to host packages.
+func getStaleProcesses(owners fileOwners, upgraded map[string]string) (ret []staleProcess) {
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, p := range procs {
+		pid, err := strconv.Atoi(filepath.Base(p))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if !sameRoot(pid) {
+			continue
+		}
+		files := deletedFiles(pid)
+		if len(files) == 0 {
+			continue
+		}
+		exe, _ := os.Readlink(filepath.Join(p, "exe"))
+		var process string
+		if buf, err := ioutil.ReadFile(filepath.Join(p, "comm")); err == nil {
+			process = strings.TrimSpace(string(buf))
+		}
+		byPkg := make(map[string]*staleProcess)
+		var order []string
+		for _, f := range files {
+			pkg, ok := owners.owner(f)
+			if !ok {
+				// Temporary files, memfd mappings and the like
+				continue
+			}
+			key := pkg.Name + " " + pkg.Arch
+			sp, ok := byPkg[key]
+			if !ok {
+				sp = &staleProcess{
+					pid:            pid,
+					process:        process,
+					exe:            strings.TrimSuffix(exe, " (deleted)"),
+					pkg:            pkg,
+					runningVersion: upgraded[pkg.Name+" "+pkg.Version],
+				}
+				byPkg[key] = sp
+				order = append(order, key)
+			}
+			sp.files = append(sp.files, f)
+		}
+		for _, k := range order {
+			ret = append(ret, *byPkg[k])
+		}
+	}
+	return
+}
+
+// deletedFiles returns the paths of the deleted files that are the executable
+// of process pid or are mapped into its memory. Package managers replace
+// files by renaming the new file over the old one, so a replaced file is
+// reported by the kernel as deleted too.
+func deletedFiles(pid int) []string {
+	seen := make(map[string]bool)
+	var ret []string
+	add := func(path string) {
+		if !strings.HasSuffix(path, " (deleted)") {
+			return
+		}
+		path = strings.TrimSuffix(path, " (deleted)")
+		if !seen[path] {
+			seen[path] = true
+			ret = append(ret, path)
+		}
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%v/exe", pid))
+	if err == nil {
+		add(exe)
+	}
+	fd, err := os.Open(fmt.Sprintf("/proc/%v/maps", pid))
+	if err != nil {
+		return ret
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		// address perms offset dev inode pathname
+		f := strings.SplitN(scn.Text(), " ", 6)
+		if len(f) != 6 {
+			continue
+		}
+		path := strings.TrimLeft(f[5], " ")
+		if strings.HasPrefix(path, "/") {
+			add(path)
+		}
+	}
+	return ret
+}
+
+// Package manager logs recording upgrades. The yum log only has the new
+// version, so the version replaced is the one last installed before it.
+const (
+	dpkgLog   = "/var/log/dpkg.log"
+	dnfRpmLog = "/var/log/dnf.rpm.log"
+	yumLog    = "/var/log/yum.log"
+)
+
+// getUpgradedVersions returns the version each installed package replaced
+// when it was last upgraded, keyed by package name and installed version,
+// according to the dpkg, dnf and yum logs. Only the current and previous log
+// files are read, so older upgrades are not found. apk does not log the version
+// replaced.
+func getUpgradedVersions() map[string]string {
+	ret := make(map[string]string)
+	for _, path := range []string{dpkgLog + ".1", dpkgLog} {
+		readDpkgLog(path, ret)
+	}
+	for _, path := range []string{dnfRpmLog + ".1", dnfRpmLog} {
+		readDnfRpmLog(path, ret)
+	}
+	// The yum log is rotated yearly with the date appended
+	installed := make(map[string]string)
+	paths, _ := filepath.Glob(yumLog + "-[0-9]*[0-9]")
+	sort.Strings(paths)
+	if len(paths) > 0 {
+		readYumLog(paths[len(paths)-1], installed, ret)
+	}
+	readYumLog(yumLog, installed, ret)
+	return ret
+}
+
+// readDpkgLog adds the upgrades in the dpkg log at path to upgraded, from
+// lines such as
+//
+//	2024-02-01 10:00:00 upgrade libssl3:amd64 3.0.11-1~deb12u1 3.0.11-1~deb12u2
+func readDpkgLog(path string, upgraded map[string]string) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		f := strings.Fields(scn.Text())
+		if len(f) != 6 || f[2] != "upgrade" {
+			continue
+		}
+		name := strings.SplitN(f[3], ":", 2)[0]
+		upgraded[name+" "+f[5]] = f[4]
+	}
+}
+
+// readDnfRpmLog adds the upgrades in the dnf rpm log at path to upgraded. dnf
+// logs the new package and then the package it replaced, for example
+//
+//	2024-02-01T10:00:00+0000 SUBDEBUG Upgrade: openssl-libs-1:3.0.7-25.el9.x86_64
+//	2024-02-01T10:00:01+0000 SUBDEBUG Upgraded: openssl-libs-1:3.0.7-24.el9.x86_64
+func readDnfRpmLog(path string, upgraded map[string]string) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	pending := make(map[string]string) // Version being installed by name and arch
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		f := strings.Fields(scn.Text())
+		if len(f) != 4 || (f[2] != "Upgrade:" && f[2] != "Upgraded:") {
+			continue
+		}
+		name, evr, arch, ok := splitNEVRA(f[3])
+		if !ok {
+			continue
+		}
+		if f[2] == "Upgrade:" {
+			pending[name+"."+arch] = evr
+			continue
+		}
+		if v, ok := pending[name+"."+arch]; ok {
+			upgraded[name+" "+v] = evr
+			delete(pending, name+"."+arch)
+		}
+	}
+}
+
+// readYumLog adds the upgrades in the yum log at path to upgraded. yum logs
+// only the package installed, with any epoch first, for example
+//
+//	Jan 10 09:00:00 Installed: 1:openssl-libs-1.0.2k-25.el7_9.x86_64
+//	Feb 01 10:00:00 Updated: 1:openssl-libs-1.0.2k-26.el7_9.x86_64
+//
+// so the version an update replaced is the version last installed or updated
+// before it, which installed tracks by name and arch across the log files read.
+func readYumLog(path string, installed, upgraded map[string]string) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		f := strings.Fields(scn.Text())
+		if len(f) != 5 {
+			continue
+		}
+		pkg := f[4]
+		epoch := ""
+		if i := strings.Index(pkg, ":"); i > 0 && strings.Trim(pkg[:i], "0123456789") == "" {
+			epoch, pkg = pkg[:i], pkg[i+1:]
+		}
+		name, evr, arch, ok := splitNEVRA(pkg)
+		switch f[3] {
+		case "Installed:", "Updated:":
+			if !ok {
+				continue
+			}
+			if epoch != "" && epoch != "0" {
+				evr = epoch + ":" + evr
+			}
+			if v, ok := installed[name+"."+arch]; ok && f[3] == "Updated:" && v != evr {
+				upgraded[name+" "+evr] = v
+			}
+			installed[name+"."+arch] = evr
+		case "Erased:":
+			// Older versions of yum log only the name of the package
+			// erased
+			if !ok {
+				name = pkg
+			}
+			for k := range installed {
+				if strings.TrimSuffix(k, filepath.Ext(k)) == name {
+					delete(installed, k)
+				}
+			}
+		}
+	}
+}
+
+// splitNEVRA splits an rpm name-[epoch:]version-release.arch string, returning
+// the version in the same form as the packages read from the rpm database
+func splitNEVRA(s string) (name, evr, arch string, ok bool) {
+	i := strings.LastIndex(s, ".")
+	if i < 0 {
+		return
+	}
+	arch = s[i+1:]
+	s = s[:i]
+	r := strings.LastIndex(s, "-")
+	if r < 0 {
+		return
+	}
+	v := strings.LastIndex(s[:r], "-")
+	if v <= 0 {
+		return
+	}
+	return s[:v], s[v+1:], arch, true
+}
+
+// emitStaleProcesses writes a record for each process still using files of a
+// package that has been upgraded since it started, with the source package of
+// the package from sources
+func emitStaleProcesses(s sink, host logrus.Fields, procs []staleProcess, sources pkgSources) error {
+	sort.SliceStable(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+	for _, x := range procs {
+		err := emit(s, sources.add(withFields(host, logrus.Fields{
+			"rectype":        "restart",
+			"pid":            x.pid,
+			"process":        x.process,
+			"exe":            x.exe,
+			"pkgname":        x.pkg.Name,
+			"pkgversion":     x.pkg.Version,
+			"pkgtype":        x.pkg.Type,
+			"pkgarch":        x.pkg.Arch,
+			"runningversion": x.runningVersion,
+			"files":          x.files,
+		}), x.pkg), fmt.Sprintf("%v (%v) needs restart for %v", x.process, x.pid, x.pkg.Name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
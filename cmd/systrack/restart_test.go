@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadYumLog(t *testing.T) {
+	installed := make(map[string]string)
+	got := make(map[string]string)
+	readYumLog("testdata/logs/yum.log-20240101", installed, got)
+	readYumLog("testdata/logs/yum.log", installed, got)
+	// The i686 glibc was not updated, and the reinstalled telnet is not an
+	// upgrade
+	want := map[string]string{
+		"openssl-libs 1:1.0.2k-26.el7_9": "1:1.0.2k-25.el7_9",
+		"openssl-libs 1:1.0.2k-27.el7_9": "1:1.0.2k-26.el7_9",
+		"glibc 2.17-326.el7_9":           "2.17-325.el7_9",
+		"bash 4.2.46-35.el7_9":           "4.2.46-34.el7",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/mozilla/scribe"
+)
+
+// The rpm database is read natively so packages can be inventoried in images,
+// chroots and containers without an rpm binary. Depending on the rpm release
+// the database is stored using one of three backends, all of which store each
+// installed package as a header blob.
+//
+//   rpmdb.sqlite  SQLite, Fedora 33 and later, RHEL 9 and derivatives
+//   Packages      Berkeley DB hash, RHEL 8 and earlier, Amazon Linux 2
+//   Packages.db   ndb, SUSE and openSUSE
+
+var errNoRpmDB = errors.New("no rpm database found")
+
+// rpmDBPaths are the locations of rpm databases relative to the root, in the
+// order they are checked
+var rpmDBPaths = []struct {
+	path string
+	read func(string) ([][]byte, error)
+}{
+	{"/usr/lib/sysimage/rpm/rpmdb.sqlite", readSqliteRpmDB},
+	{"/var/lib/rpm/rpmdb.sqlite", readSqliteRpmDB},
+	{"/usr/lib/sysimage/rpm/Packages.db", readNdbRpmDB},
+	{"/var/lib/rpm/Packages.db", readNdbRpmDB},
+	{"/usr/lib/sysimage/rpm/Packages", readBdbRpmDB},
+	{"/var/lib/rpm/Packages", readBdbRpmDB},
+}
+
+// readRpmHeaders returns the header of each package in the rpm database under
+// root, and the path of the database
+func readRpmHeaders(root string) ([]*rpmHeader, string, error) {
+	for _, x := range rpmDBPaths {
+		path := resolveInRoot(root, x.path)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		blobs, err := x.read(path)
+		if err != nil {
+			return nil, path, err
+		}
+		ret := make([]*rpmHeader, 0, len(blobs))
+		for _, b := range blobs {
+			h, err := newRpmHeader(b)
+			if err != nil {
+				return nil, path, err
+			}
+			ret = append(ret, h)
+		}
+		return ret, path, nil
+	}
+	return nil, "", errNoRpmDB
+}
+
+// readRpmDB returns the packages in the rpm database under root
+func readRpmDB(root string) ([]scribe.PackageInfo, error) {
+	hdrs, path, err := readRpmHeaders(root)
+	if err == errNoRpmDB {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	ret := make([]scribe.PackageInfo, 0, len(hdrs))
+	for _, h := range hdrs {
+		pkg, err := h.pkg()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		ret = append(ret, pkg)
+	}
+	return ret, nil
+}
+
+// rpm header tags and types we use
+const (
+	rpmTagName       = 1000
+	rpmTagVersion    = 1001
+	rpmTagRelease    = 1002
+	rpmTagEpoch      = 1003
+	rpmTagArch       = 1022
+	rpmTagDirIndexes = 1116
+	rpmTagBaseNames  = 1117
+	rpmTagDirNames   = 1118
+
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+// rpmHeader is a header blob as stored in the rpm database. The blob starts
+// with the index entry count and data length, followed by the index entries
+// and the data store.
+type rpmHeader struct {
+	blob []byte
+	il   uint32
+	data []byte
+}
+
+func newRpmHeader(blob []byte) (*rpmHeader, error) {
+	if len(blob) < 8 {
+		return nil, errors.New("rpm header too short")
+	}
+	il := binary.BigEndian.Uint32(blob[0:4])
+	dl := binary.BigEndian.Uint32(blob[4:8])
+	dataStart := 8 + uint64(il)*16
+	if dataStart+uint64(dl) > uint64(len(blob)) {
+		return nil, errors.New("rpm header index exceeds blob")
+	}
+	return &rpmHeader{blob: blob, il: il, data: blob[dataStart : dataStart+uint64(dl)]}, nil
+}
+
+// entry returns the type, data offset and count of tag, or ok false if the
+// header does not have it
+func (h *rpmHeader) entry(tag uint32) (typ, off, count uint32, ok bool) {
+	for i := uint32(0); i < h.il; i++ {
+		ent := h.blob[8+i*16 : 8+i*16+16]
+		if binary.BigEndian.Uint32(ent[0:4]) != tag {
+			continue
+		}
+		off = binary.BigEndian.Uint32(ent[8:12])
+		if off >= uint32(len(h.data)) {
+			return
+		}
+		return binary.BigEndian.Uint32(ent[4:8]), off, binary.BigEndian.Uint32(ent[12:16]), true
+	}
+	return
+}
+
+// strings returns the values of a string or string array tag. For a
+// translated string only the first translation is returned.
+func (h *rpmHeader) strings(tag uint32) (ret []string) {
+	typ, off, count, ok := h.entry(tag)
+	if !ok {
+		return
+	}
+	switch typ {
+	case rpmTypeString, rpmTypeI18NString:
+		count = 1
+	case rpmTypeStringArray:
+	default:
+		return
+	}
+	s := h.data[off:]
+	for i := uint32(0); i < count && len(s) > 0; i++ {
+		n := bytes.IndexByte(s, 0)
+		if n == -1 {
+			n = len(s)
+		}
+		ret = append(ret, string(s[:n]))
+		if n == len(s) {
+			break
+		}
+		s = s[n+1:]
+	}
+	return
+}
+
+// string returns the first value of a string tag, or the decimal value of an
+// integer tag
+func (h *rpmHeader) string(tag uint32) string {
+	if v := h.int32s(tag); len(v) > 0 {
+		return strconv.FormatUint(uint64(v[0]), 10)
+	}
+	if v := h.strings(tag); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// int32s returns the values of an integer tag
+func (h *rpmHeader) int32s(tag uint32) (ret []uint32) {
+	typ, off, count, ok := h.entry(tag)
+	if !ok || typ != rpmTypeInt32 || uint64(off)+uint64(count)*4 > uint64(len(h.data)) {
+		return
+	}
+	for i := uint32(0); i < count; i++ {
+		ret = append(ret, binary.BigEndian.Uint32(h.data[off+i*4:]))
+	}
+	return
+}
+
+// files returns the paths of the files in the package
+func (h *rpmHeader) files() (ret []string) {
+	dirs := h.strings(rpmTagDirNames)
+	bases := h.strings(rpmTagBaseNames)
+	idx := h.int32s(rpmTagDirIndexes)
+	for i, b := range bases {
+		if i >= len(idx) || int(idx[i]) >= len(dirs) {
+			break
+		}
+		ret = append(ret, dirs[idx[i]]+b)
+	}
+	return
+}
+
+// pkg returns the name, EVR and architecture of the package the header
+// describes
+func (h *rpmHeader) pkg() (pkg scribe.PackageInfo, err error) {
+	pkg.Name = h.string(rpmTagName)
+	version := h.string(rpmTagVersion)
+	release := h.string(rpmTagRelease)
+	epoch := h.string(rpmTagEpoch)
+	pkg.Arch = h.string(rpmTagArch)
+	if pkg.Name == "" || version == "" {
+		err = errors.New("rpm header has no name or version")
+		return
+	}
+	// Format the version the same way as rpm's %{EVR}
+	pkg.Version = version
+	if release != "" {
+		pkg.Version += "-" + release
+	}
+	if epoch != "" {
+		pkg.Version = epoch + ":" + pkg.Version
+	}
+	if pkg.Arch == "" {
+		// For example gpg-pubkey entries, which rpm -qa reports this way
+		pkg.Arch = "(none)"
+	}
+	pkg.Type = "rpm"
+	return
+}
+
+// Berkeley DB hash database constants
+const (
+	bdbHashMagic      = 0x061561
+	bdbPageHeaderSize = 26
+
+	bdbPageOverflow     = 7
+	bdbPageHashUnsorted = 2
+	bdbPageHash         = 13
+
+	bdbItemKeyData = 1
+	bdbItemOffPage = 3
+)
+
+// readBdbRpmDB returns the header blobs stored in a Berkeley DB hash database.
+// Rather than walking the hash buckets every page is scanned, and the value of
+// each key/value pair on a hash page is either stored inline or in a chain of
+// overflow pages.
+func readBdbRpmDB(path string) (ret [][]byte, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return
+	}
+	meta := make([]byte, 512)
+	_, err = io.ReadFull(fd, meta)
+	if err != nil {
+		return
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(meta[12:16]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(meta[12:16]) != bdbHashMagic {
+			return nil, errors.New("not a Berkeley DB hash database")
+		}
+	}
+	pageSize := order.Uint32(meta[20:24])
+	lastPage := order.Uint32(meta[32:36])
+	if pageSize < 512 || pageSize > 65536 {
+		return nil, fmt.Errorf("invalid page size %v", pageSize)
+	}
+	readPage := func(n uint32) ([]byte, error) {
+		buf := make([]byte, pageSize)
+		_, err := fd.ReadAt(buf, int64(n)*int64(pageSize))
+		return buf, err
+	}
+	for n := uint32(1); n <= lastPage; n++ {
+		page, err := readPage(n)
+		if err != nil {
+			return nil, err
+		}
+		if page[25] != bdbPageHash && page[25] != bdbPageHashUnsorted {
+			continue
+		}
+		entries := int(order.Uint16(page[20:22]))
+		if bdbPageHeaderSize+entries*2 > len(page) {
+			return nil, fmt.Errorf("invalid entry count on page %v", n)
+		}
+		// Entries alternate between keys and values, we only want values
+		for i := 1; i < entries; i += 2 {
+			off := int(order.Uint16(page[bdbPageHeaderSize+i*2:]))
+			end := int(order.Uint16(page[bdbPageHeaderSize+(i-1)*2:]))
+			if off >= len(page) || end > len(page) || end <= off {
+				continue
+			}
+			switch page[off] {
+			case bdbItemKeyData:
+				ret = append(ret, append([]byte(nil), page[off+1:end]...))
+			case bdbItemOffPage:
+				if off+12 > len(page) {
+					continue
+				}
+				pgno := order.Uint32(page[off+4 : off+8])
+				tlen := order.Uint32(page[off+8 : off+12])
+				if int64(tlen) > fi.Size() {
+					// The length is read from the file, so is checked
+					// before allocating for it
+					return nil, fmt.Errorf("overflow value on page %v exceeds database", n)
+				}
+				val, err := readBdbOverflow(readPage, order, pgno, tlen)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, val)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// readBdbOverflow reads a value of length tlen from a chain of overflow pages
+// starting at page pgno. The free area offset in each overflow page header holds
+// the number of bytes of the value stored on that page.
+func readBdbOverflow(readPage func(uint32) ([]byte, error), order binary.ByteOrder,
+	pgno, tlen uint32) ([]byte, error) {
+	ret := make([]byte, 0, tlen)
+	for pgno != 0 && uint32(len(ret)) < tlen {
+		page, err := readPage(pgno)
+		if err != nil {
+			return nil, err
+		}
+		if page[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("page %v is not an overflow page", pgno)
+		}
+		n := int(order.Uint16(page[22:24]))
+		if bdbPageHeaderSize+n > len(page) {
+			return nil, fmt.Errorf("invalid length on overflow page %v", pgno)
+		}
+		ret = append(ret, page[bdbPageHeaderSize:bdbPageHeaderSize+n]...)
+		pgno = order.Uint32(page[16:20])
+	}
+	if uint32(len(ret)) != tlen {
+		return nil, errors.New("truncated overflow chain")
+	}
+	return ret, nil
+}
+
+// ndb database constants, from rpm's lib/backend/ndb/rpmpkg.c
+const (
+	ndbHeaderMagic = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic   = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic   = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+	ndbPageSize    = 4096
+	ndbHeaderSize  = 32
+	ndbSlotSize    = 16
+	ndbBlockSize   = 16
+)
+
+// readNdbRpmDB returns the header blobs stored in an ndb package database. The
+// file starts with a header and slot table, and each used slot points at the
+// block holding the blob for one package.
+func readNdbRpmDB(path string) (ret [][]byte, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return
+	}
+	hdr := make([]byte, ndbHeaderSize)
+	_, err = io.ReadFull(fd, hdr)
+	if err != nil {
+		return
+	}
+	le := binary.LittleEndian
+	if le.Uint32(hdr[0:4]) != ndbHeaderMagic {
+		return nil, errors.New("not an ndb package database")
+	}
+	slotPages := le.Uint32(hdr[12:16])
+	if slotPages == 0 || slotPages > 2048 || int64(slotPages)*ndbPageSize > fi.Size() {
+		return nil, fmt.Errorf("invalid slot page count %v", slotPages)
+	}
+	slots := make([]byte, slotPages*ndbPageSize-ndbHeaderSize)
+	_, err = io.ReadFull(fd, slots)
+	if err != nil {
+		return
+	}
+	for i := 0; i+ndbSlotSize <= len(slots); i += ndbSlotSize {
+		s := slots[i : i+ndbSlotSize]
+		if le.Uint32(s[0:4]) != ndbSlotMagic {
+			continue
+		}
+		pkgIdx := le.Uint32(s[4:8])
+		blkOff := le.Uint32(s[8:12])
+		if pkgIdx == 0 {
+			// Free slot
+			continue
+		}
+		bh := make([]byte, 16)
+		_, err = fd.ReadAt(bh, int64(blkOff)*ndbBlockSize)
+		if err != nil {
+			return nil, err
+		}
+		if le.Uint32(bh[0:4]) != ndbBlobMagic || le.Uint32(bh[4:8]) != pkgIdx {
+			return nil, fmt.Errorf("invalid blob for package index %v", pkgIdx)
+		}
+		blobLen := le.Uint32(bh[12:16])
+		if int64(blkOff)*ndbBlockSize+16+int64(blobLen) > fi.Size() {
+			return nil, fmt.Errorf("blob for package index %v exceeds database", pkgIdx)
+		}
+		blob := make([]byte, blobLen)
+		_, err = fd.ReadAt(blob, int64(blkOff)*ndbBlockSize+16)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, blob)
+	}
+	return ret, nil
+}
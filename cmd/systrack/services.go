@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// systemdUnitDirs are the directories systemd loads unit files from, highest
+// precedence first
+var systemdUnitDirs = []string{
+	"/etc/systemd/system",
+	"/run/systemd/system",
+	"/usr/local/lib/systemd/system",
+	"/usr/lib/systemd/system",
+	"/lib/systemd/system",
+}
+
+// service is a systemd service or socket unit, or a SysV init script
+type service struct {
+	name    string // Unit name, or the init script name for SysV
+	manager string // systemd or sysv
+	path    string // Unit file or init script
+	enabled string // enabled, disabled, static or masked
+	active  string // Active state, if known
+	sub     string // Sub state, such as running or exited, if known
+	exec    string // Binary run by ExecStart
+	pkg     scribe.PackageInfo
+}
+
+// getServices returns the systemd units and SysV init scripts in the
+// filesystem at root. The active state of units is only known for the live
+// system, where it is read from systemctl.
+func getServices(root string, owners fileOwners) (ret []service) {
+	units := readSystemdUnits(root)
+	if root == "/" {
+		readSystemdStates(units)
+	}
+	for _, name := range sortedKeys(units) {
+		ret = append(ret, *units[name])
+	}
+	ret = append(ret, readInitScripts(root)...)
+	for i := range ret {
+		s := &ret[i]
+		var ok bool
+		if s.exec != "" {
+			s.pkg, ok = owners.owner(s.exec)
+		}
+		if !ok && s.path != "" {
+			// Services run by a script or an interpreter still belong to
+			// the package providing the unit
+			s.pkg, _ = owners.owner(s.path)
+		}
+	}
+	return
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]*service) (ret []string) {
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return
+}
+
+// readSystemdUnits returns the service and socket units in the unit
+// directories of the filesystem at root, by name. Enabled template instances,
+// such as getty@tty1.service, are returned as units of their own.
+func readSystemdUnits(root string) map[string]*service {
+	units := make(map[string]*service)
+	enabled := make(map[string]bool)
+	for _, dir := range systemdUnitDirs {
+		fis, err := ioutil.ReadDir(resolveInRoot(root, dir))
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			name := fi.Name()
+			if fi.IsDir() && (strings.HasSuffix(name, ".wants") || strings.HasSuffix(name, ".requires")) {
+				// Units linked here under /etc are enabled, elsewhere they
+				// are pulled in statically
+				if strings.HasPrefix(dir, "/etc/") {
+					links, _ := ioutil.ReadDir(filepath.Join(resolveInRoot(root, dir), name))
+					for _, l := range links {
+						enabled[l.Name()] = true
+					}
+				}
+				continue
+			}
+			if !strings.HasSuffix(name, ".service") && !strings.HasSuffix(name, ".socket") {
+				continue
+			}
+			if _, ok := units[name]; ok {
+				// Overridden by a directory with higher precedence
+				continue
+			}
+			u := &service{name: name, manager: "systemd", path: filepath.Join(dir, name)}
+			if target, err := os.Readlink(filepath.Join(resolveInRoot(root, dir), name)); err == nil {
+				if target == "/dev/null" {
+					u.enabled = "masked"
+				} else if filepath.Base(target) != name {
+					// An alias, such as sshd.service for ssh.service, which
+					// is reported under the name of the unit
+					continue
+				}
+			}
+			units[name] = u
+		}
+	}
+	for name := range enabled {
+		if _, ok := units[name]; ok {
+			continue
+		}
+		// An enabled instance of a template unit
+		i := strings.Index(name, "@")
+		j := strings.LastIndex(name, ".")
+		if i < 0 || j < i {
+			continue
+		}
+		if t, ok := units[name[:i+1]+name[j:]]; ok {
+			units[name] = &service{name: name, manager: "systemd", path: t.path}
+		}
+	}
+	for name, u := range units {
+		if strings.HasSuffix(name[:strings.LastIndex(name, ".")], "@") {
+			// Templates are only run through their instances
+			delete(units, name)
+			continue
+		}
+		if u.enabled == "masked" {
+			continue
+		}
+		install, exec := parseUnitFile(root, u.path, name)
+		switch {
+		case enabled[name]:
+			u.enabled = "enabled"
+		case install:
+			u.enabled = "disabled"
+		default:
+			u.enabled = "static"
+		}
+		u.exec = exec
+	}
+	return units
+}
+
+// parseUnitFile reads the unit file at path and its drop-in files for the
+// unit name, returning whether the unit has an [Install] section and so can be
+// enabled, and the binary run by the first ExecStart command
+func parseUnitFile(root, path, name string) (install bool, execStart string) {
+	files := []string{path}
+	dropins := make(map[string]string)
+	for _, dir := range systemdUnitDirs {
+		matches, _ := filepath.Glob(filepath.Join(resolveInRoot(root, dir), name+".d", "*.conf"))
+		for _, m := range matches {
+			if _, ok := dropins[filepath.Base(m)]; !ok {
+				dropins[filepath.Base(m)] = filepath.Join(dir, name+".d", filepath.Base(m))
+			}
+		}
+	}
+	var names []string
+	for n := range dropins {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		files = append(files, dropins[n])
+	}
+	var commands []string
+	for _, f := range files {
+		fd, err := os.Open(resolveInRoot(root, f))
+		if err != nil {
+			continue
+		}
+		var section string
+		scn := bufio.NewScanner(fd)
+		for scn.Scan() {
+			l := strings.TrimSpace(scn.Text())
+			if strings.HasPrefix(l, "[") {
+				section = l
+				install = install || section == "[Install]"
+				continue
+			}
+			if section != "[Service]" || !strings.HasPrefix(l, "ExecStart=") {
+				continue
+			}
+			v := strings.TrimSpace(strings.TrimPrefix(l, "ExecStart="))
+			if v == "" {
+				// An empty setting in a drop-in clears the commands
+				commands = nil
+				continue
+			}
+			commands = append(commands, v)
+		}
+		fd.Close()
+	}
+	if len(commands) > 0 {
+		// The command may be prefixed with flags such as - to ignore
+		// failure
+		f := strings.Fields(strings.TrimLeft(commands[0], "@-:+!"))
+		if len(f) > 0 && filepath.IsAbs(f[0]) {
+			execStart = f[0]
+		}
+	}
+	return
+}
+
+// readSystemdStates sets the active and sub state of the units systemd has
+// loaded, adding any that have no unit file, such as generated units
+func readSystemdStates(units map[string]*service) {
+	path, err := exec.LookPath("systemctl")
+	if err != nil {
+		return
+	}
+	out, err := exec.Command(path, "list-units", "--all", "--plain", "--no-legend",
+		"--no-pager", "--type=service,socket").Output()
+	if err != nil {
+		return
+	}
+	for _, l := range strings.Split(string(out), "\n") {
+		// UNIT LOAD ACTIVE SUB DESCRIPTION
+		f := strings.Fields(strings.TrimPrefix(strings.TrimSpace(l), "● "))
+		if len(f) < 4 || f[1] == "not-found" {
+			continue
+		}
+		u, ok := units[f[0]]
+		if !ok {
+			u = &service{name: f[0], manager: "systemd", enabled: "static"}
+			units[f[0]] = u
+		}
+		u.active, u.sub = f[2], f[3]
+	}
+}
+
+// readInitScripts returns the SysV init scripts in the filesystem at root,
+// which are enabled if they are started in any multi-user runlevel
+func readInitScripts(root string) (ret []service) {
+	fis, err := ioutil.ReadDir(resolveInRoot(root, "/etc/init.d"))
+	if err != nil {
+		return
+	}
+	started := make(map[string]bool)
+	for _, level := range []string{"2", "3", "4", "5"} {
+		for _, dir := range []string{"/etc/rc" + level + ".d", "/etc/rc.d/rc" + level + ".d"} {
+			links, _ := filepath.Glob(filepath.Join(resolveInRoot(root, dir), "S*"))
+			for _, l := range links {
+				// Links are named S<priority><script>
+				started[strings.TrimLeft(filepath.Base(l)[1:], "0123456789")] = true
+			}
+		}
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || fi.Mode()&0111 == 0 || strings.HasPrefix(name, ".") ||
+			name == "README" || name == "functions" || name == "rc" || name == "rcS" {
+			continue
+		}
+		s := service{
+			name:    name,
+			manager: "sysv",
+			path:    filepath.Join("/etc/init.d", name),
+			enabled: "disabled",
+		}
+		if started[name] {
+			s.enabled = "enabled"
+		}
+		ret = append(ret, s)
+	}
+	return
+}
+
+// emitServices writes a record for each systemd unit and SysV init script
+func emitServices(s sink, host logrus.Fields, services []service) error {
+	for _, x := range services {
+		err := emit(s, withFields(host, logrus.Fields{
+			"rectype":    "service",
+			"service":    x.name,
+			"manager":    x.manager,
+			"unitfile":   x.path,
+			"enabled":    x.enabled,
+			"active":     x.active,
+			"substate":   x.sub,
+			"exec":       x.exec,
+			"pkgname":    x.pkg.Name,
+			"pkgversion": x.pkg.Version,
+			"pkgtype":    x.pkg.Type,
+			"pkgarch":    x.pkg.Arch,
+		}), fmt.Sprintf("%v service %v is %v", x.manager, x.name, x.enabled))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mozilla/scribe"
+)
+
+func TestGetServices(t *testing.T) {
+	root := "testdata/services"
+	pkg := func(name, version string) scribe.PackageInfo {
+		return scribe.PackageInfo{Name: name, Version: version, Arch: "amd64", Type: "dpkg"}
+	}
+	// sshd.service is an alias of ssh.service and getty@.service a template,
+	// so neither is reported. The cron drop-in clears the ExecStart of the
+	// unit, so the wrapper is not owned and the unit file gives the package.
+	want := []service{
+		{name: "cron.service", manager: "systemd", path: "/lib/systemd/system/cron.service",
+			enabled: "disabled", exec: "/usr/local/bin/cron-wrapper", pkg: pkg("cron", "3.0pl1-162")},
+		{name: "dbus.service", manager: "systemd", path: "/lib/systemd/system/dbus.service",
+			enabled: "static", exec: "/usr/bin/dbus-daemon", pkg: pkg("dbus-daemon", "1.14.10-1~deb12u1")},
+		{name: "dbus.socket", manager: "systemd", path: "/lib/systemd/system/dbus.socket",
+			enabled: "static"},
+		{name: "getty@tty1.service", manager: "systemd", path: "/lib/systemd/system/getty@.service",
+			enabled: "enabled", exec: "/sbin/agetty"},
+		{name: "rsync.service", manager: "systemd", path: "/etc/systemd/system/rsync.service",
+			enabled: "static", exec: "/usr/local/bin/rsync"},
+		{name: "ssh.service", manager: "systemd", path: "/lib/systemd/system/ssh.service",
+			enabled: "enabled", exec: "/usr/sbin/sshd", pkg: pkg("openssh-server", "1:9.2p1-2+deb12u3")},
+		{name: "telnet.socket", manager: "systemd", path: "/etc/systemd/system/telnet.socket",
+			enabled: "masked"},
+		{name: "apache2", manager: "sysv", path: "/etc/init.d/apache2",
+			enabled: "enabled", pkg: pkg("apache2", "2.4.62-1~deb12u2")},
+		{name: "nfs-kernel-server", manager: "sysv", path: "/etc/init.d/nfs-kernel-server",
+			enabled: "disabled"},
+		{name: "sendmail", manager: "sysv", path: "/etc/init.d/sendmail",
+			enabled: "enabled"},
+	}
+	got := getServices(root, getFileOwners(root))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseUnitFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFile(t, dir, "/lib/systemd/system/app.service",
+		"[Service]\nExecStart=-/opt/app/bin/app --serve\n")
+	for _, tc := range []struct {
+		dropin  string
+		install bool
+		exec    string
+	}{
+		// Only the first command is reported
+		{"[Service]\nExecStart=/opt/app/bin/helper\n", false, "/opt/app/bin/app"},
+		// A command in another section is not the ExecStart of the service
+		{"[Install]\nExecStart=/opt/app/bin/helper\nWantedBy=multi-user.target\n", true, "/opt/app/bin/app"},
+		{"[Service]\nExecStart=\nExecStart=/usr/bin/env app\n", false, "/usr/bin/env"},
+		// A relative command is searched for in the path, so the binary
+		// is not known
+		{"[Service]\nExecStart=\nExecStart=app\n", false, ""},
+	} {
+		writeFile(t, dir, "/etc/systemd/system/app.service.d/local.conf", tc.dropin)
+		install, exec := parseUnitFile(dir, "/lib/systemd/system/app.service", "app.service")
+		if install != tc.install || exec != tc.exec {
+			t.Errorf("drop-in %q: got %v, %q, want %v, %q", tc.dropin, install, exec, tc.install, tc.exec)
+		}
+	}
+}
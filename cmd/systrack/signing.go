@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signature algorithms, as used in the sigalg field of signed records
+const (
+	sigAlgHMAC    = "hmac-sha256"
+	sigAlgEd25519 = "ed25519"
+)
+
+// signer signs each record if a signing key is configured
+var signer *recordSigner
+
+// recordSigner signs records with a per-host or per-fleet key, so the lambda
+// can reject records written to the stream by anyone else.
+//
+// The signature covers the canonical JSON encoding of the record fields, which
+// is the encoding Go produces for the fields once decoded into generic JSON
+// values, with object keys sorted. The sig field itself and the msg field added
+// by the log formatter are not covered. The sigalg and sigkeyid fields are, so
+// the algorithm and key cannot be swapped, and so is the sigtime field holding
+// the time of signing, so the lambda can refuse old records replayed into the
+// stream. The hostname and time in the mozlog envelope are not covered, so the
+// lambda reports the fqdn field and sigtime of signed records instead.
+type recordSigner struct {
+	alg     string
+	keyID   string
+	hmacKey []byte
+	edKey   ed25519.PrivateKey
+}
+
+// newRecordSigner loads the base64 encoded key at path for alg. An Ed25519
+// key may be either the 32 byte seed or the 64 byte private key.
+func newRecordSigner(alg, keyID, path string) (*recordSigner, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return nil, fmt.Errorf("%v: key is not base64 encoded: %v", path, err)
+	}
+	s := &recordSigner{alg: alg, keyID: keyID}
+	switch alg {
+	case sigAlgHMAC:
+		if len(key) < 32 {
+			return nil, fmt.Errorf("%v: HMAC key must be at least 32 bytes", path)
+		}
+		s.hmacKey = key
+	case sigAlgEd25519:
+		switch len(key) {
+		case ed25519.PrivateKeySize:
+			s.edKey = ed25519.PrivateKey(key)
+		case 32:
+			_, s.edKey, err = ed25519.GenerateKey(bytes.NewReader(key))
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%v: Ed25519 key must be a 32 byte seed or 64 byte private key", path)
+		}
+	default:
+		return nil, fmt.Errorf("unknown signature algorithm %q, expected %v or %v",
+			alg, sigAlgHMAC, sigAlgEd25519)
+	}
+	return s, nil
+}
+
+// sign returns a copy of fields with the signature fields added, signed at
+// now
+func (s *recordSigner) sign(fields logrus.Fields, now time.Time) (logrus.Fields, error) {
+	ret := withFields(fields, logrus.Fields{
+		"sigalg":   s.alg,
+		"sigkeyid": s.keyID,
+		"sigtime":  now.Unix(),
+	})
+	msg, err := canonicalFields(ret)
+	if err != nil {
+		return nil, err
+	}
+	var sig []byte
+	switch s.alg {
+	case sigAlgHMAC:
+		mac := hmac.New(sha256.New, s.hmacKey)
+		mac.Write(msg)
+		sig = mac.Sum(nil)
+	case sigAlgEd25519:
+		sig = ed25519.Sign(s.edKey, msg)
+	}
+	ret["sig"] = base64.StdEncoding.EncodeToString(sig)
+	return ret, nil
+}
+
+// canonicalFields returns the encoding of fields covered by the signature
+func canonicalFields(fields logrus.Fields) ([]byte, error) {
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	// Round trip through generic values so structs are encoded the same way
+	// the lambda will encode them, as objects with sorted keys
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var m map[string]interface{}
+	err = dec.Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	delete(m, "sig")
+	delete(m, "msg")
+	return json.Marshal(m)
+}
+
+// generateKey prints a new base64 encoded key for alg. For Ed25519 the public
+// key the lambda needs is printed too.
+func generateKey(alg string) error {
+	switch alg {
+	case sigAlgHMAC:
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("key: %v\n", base64.StdEncoding.EncodeToString(key))
+	case sigAlgEd25519:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("private key: %v\npublic key: %v\n",
+			base64.StdEncoding.EncodeToString(priv), base64.StdEncoding.EncodeToString(pub))
+	default:
+		return fmt.Errorf("unknown signature algorithm %q, expected %v or %v",
+			alg, sigAlgHMAC, sigAlgEd25519)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// sink is a destination for formatted systrack records. Each call to write
+// receives a single newline terminated JSON document. Sinks may buffer records
+// internally, so close must always be called to flush any pending data.
+type sink interface {
+	write(buf []byte) error
+	close() error
+}
+
+// flusher is implemented by sinks that send records in batches. flush sends
+// the records written since the last batch, and returns once they have been
+// accepted.
+type flusher interface {
+	flush() error
+}
+
+// newSink returns a sink based on the output specification spec, which can be
+// one of:
+//
+//	stdout            write JSONL records to standard output
+//	file:<path>       append JSONL records to the file at path
+//	http(s)://<url>   POST batches of JSONL records to url
+//	kinesis:<stream>  put records into the named Kinesis stream
+//
+// so holds the settings for http and kinesis outputs.
+func newSink(spec string, so sinkOptions) (sink, error) {
+	switch {
+	case spec == "stdout":
+		return &stdoutSink{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return newFileSink(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return newHTTPSink(spec, so), nil
+	case strings.HasPrefix(spec, "kinesis:"):
+		return newKinesisSink(strings.TrimPrefix(spec, "kinesis:"), so)
+	}
+	return nil, fmt.Errorf("unknown output %q", spec)
+}
+
+// sinkOptions configures the remote outputs
+type sinkOptions struct {
+	httpTimeout time.Duration
+	httpHeaders map[string]string // Added to each request, for example Authorization
+
+	// The region and credentials for Kinesis default to those found by the
+	// AWS SDK in the environment, shared configuration files or instance role
+	kinesisRegion          string
+	kinesisProfile         string
+	kinesisAccessKeyID     string
+	kinesisSecretAccessKey string
+}
+
+// checkOutput returns an error if spec is not a valid output specification,
+// without creating the sink
+func checkOutput(spec string) error {
+	for _, p := range []string{"file:", "http://", "https://", "kinesis:"} {
+		if strings.HasPrefix(spec, p) {
+			return nil
+		}
+	}
+	if spec == "stdout" {
+		return nil
+	}
+	return fmt.Errorf("unknown output %q", spec)
+}
+
+// stdoutSink writes records to standard output, which is mostly useful for
+// dry runs on development systems
+type stdoutSink struct{}
+
+func (s *stdoutSink) write(buf []byte) error {
+	_, err := os.Stdout.Write(buf)
+	return err
+}
+
+func (s *stdoutSink) close() error {
+	return nil
+}
+
+// fileSink appends records to a local file
+type fileSink struct {
+	fd *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file output requires a path")
+	}
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{fd: fd}, nil
+}
+
+func (s *fileSink) write(buf []byte) error {
+	_, err := s.fd.Write(buf)
+	return err
+}
+
+func (s *fileSink) close() error {
+	return s.fd.Close()
+}
+
+// httpBatchSize is the maximum number of records sent in a single POST
+const httpBatchSize = 500
+
+// httpSink POSTs records to a collector endpoint as newline delimited JSON
+type httpSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	buf     bytes.Buffer
+	count   int
+}
+
+func newHTTPSink(url string, so sinkOptions) *httpSink {
+	return &httpSink{
+		url:     url,
+		headers: so.httpHeaders,
+		client:  &http.Client{Timeout: so.httpTimeout},
+	}
+}
+
+func (s *httpSink) write(buf []byte) error {
+	s.buf.Write(buf)
+	s.count++
+	if s.count >= httpBatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *httpSink) flush() error {
+	if s.count == 0 {
+		return nil
+	}
+	defer func() {
+		s.buf.Reset()
+		s.count = 0
+	}()
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("invalid HTTP response code returned by %v: %v",
+			s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSink) close() error {
+	return s.flush()
+}
+
+// kinesisBatchSize is the maximum number of records Kinesis accepts in a single
+// PutRecords call
+const kinesisBatchSize = 500
+
+// kinesisBatchBytes is the maximum total size of the records in a single
+// PutRecords call, counting the data and partition key of each record. Snapshot
+// and container records can be large enough for a full batch to pass it.
+const kinesisBatchBytes = 5 << 20
+
+// kinesisSink puts records into a Kinesis stream, using the system hostname as
+// the partition key
+type kinesisSink struct {
+	stream       string
+	partitionKey string
+	client       *kinesis.Kinesis
+	records      []*kinesis.PutRecordsRequestEntry
+	size         int // Total size of records
+}
+
+func newKinesisSink(stream string, so sinkOptions) (*kinesisSink, error) {
+	if stream == "" {
+		return nil, fmt.Errorf("kinesis output requires a stream name")
+	}
+	opts := session.Options{Profile: so.kinesisProfile}
+	if so.kinesisRegion != "" {
+		opts.Config.Region = aws.String(so.kinesisRegion)
+	}
+	if so.kinesisAccessKeyID != "" {
+		opts.Config.Credentials = credentials.NewStaticCredentials(so.kinesisAccessKeyID,
+			so.kinesisSecretAccessKey, "")
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &kinesisSink{
+		stream:       stream,
+		partitionKey: getHostname(),
+		client:       kinesis.New(sess),
+	}, nil
+}
+
+func (s *kinesisSink) write(buf []byte) error {
+	n := len(buf) + len(s.partitionKey)
+	if s.size+n > kinesisBatchBytes {
+		err := s.flush()
+		if err != nil {
+			return err
+		}
+	}
+	// Copy the record as the caller is free to reuse buf
+	data := make([]byte, len(buf))
+	copy(data, buf)
+	s.records = append(s.records, &kinesis.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: aws.String(s.partitionKey),
+	})
+	s.size += n
+	if len(s.records) >= kinesisBatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *kinesisSink) flush() error {
+	if len(s.records) == 0 {
+		return nil
+	}
+	defer func() {
+		s.records = s.records[:0]
+		s.size = 0
+	}()
+	resp, err := s.client.PutRecords(&kinesis.PutRecordsInput{
+		StreamName: aws.String(s.stream),
+		Records:    s.records,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.FailedRecordCount != nil && *resp.FailedRecordCount > 0 {
+		return fmt.Errorf("kinesis rejected %v of %v records", *resp.FailedRecordCount,
+			len(s.records))
+	}
+	return nil
+}
+
+func (s *kinesisSink) close() error {
+	return s.flush()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// kinesisStandIn stands in for Kinesis taking PutRecords calls, keeping the
+// size of the records in each call
+type kinesisStandIn struct {
+	batches [][]int
+}
+
+func (k *kinesisStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Records []struct {
+			Data         []byte
+			PartitionKey string
+		}
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var sizes []int
+	for _, x := range req.Records {
+		sizes = append(sizes, len(x.Data)+len(x.PartitionKey))
+	}
+	k.batches = append(k.batches, sizes)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Write([]byte(`{"FailedRecordCount":0,"Records":[]}`))
+}
+
+func TestKinesisBatchBytes(t *testing.T) {
+	k := &kinesisStandIn{}
+	srv := httptest.NewServer(k)
+	defer srv.Close()
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &kinesisSink{stream: "inventory", partitionKey: "host1", client: kinesis.New(sess)}
+
+	// Twelve snapshot chunks of 512 KB pass the limit on the size of a
+	// call well before the limit on the number of records
+	chunk := []byte(strings.Repeat("x", snapshotMaxSize-len(s.partitionKey)-1) + "\n")
+	for i := 0; i < 12; i++ {
+		err = s.write(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.batches) != 2 || len(k.batches[0]) != 10 || len(k.batches[1]) != 2 {
+		var counts []int
+		for _, b := range k.batches {
+			counts = append(counts, len(b))
+		}
+		t.Errorf("got batches of %v records, want 10 and 2", counts)
+	}
+	for i, b := range k.batches {
+		total := 0
+		for _, n := range b {
+			total += n
+		}
+		if total > kinesisBatchBytes {
+			t.Errorf("batch %v has %v bytes", i, total)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// snapshotMaxSize is the approximate upper bound on the size of the package
+// list in a single snapshot record. Kinesis limits records to 1MiB, so leave
+// plenty of room for the host fields and the mozlog envelope.
+const snapshotMaxSize = 512 * 1024
+
+// snapshotPkg describes a package in a snapshot record
+type snapshotPkg struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	Arch    string `json:"arch"`
+
+	// The source package, if it has another name or version
+	Source        string `json:"source,omitempty"`
+	SourceVersion string `json:"sourceversion,omitempty"`
+}
+
+// emitSnapshot writes the host fields and the complete package list as a single
+// snapshot record. If the package list is too large for one record it is split
+// into chunks, each carrying the same snapshot id along with its position.
+func emitSnapshot(s sink, host logrus.Fields, pkgs []scribe.PackageInfo, sources pkgSources) error {
+	id, err := newSnapshotID()
+	if err != nil {
+		return err
+	}
+	chunks, err := chunkPackages(snapshotPkgs(pkgs, sources), snapshotMaxSize)
+	if err != nil {
+		return err
+	}
+	for i, c := range chunks {
+		err = emit(s, withFields(host, logrus.Fields{
+			"rectype":    "snapshot",
+			"snapshotid": id,
+			"chunk":      i,
+			"chunks":     len(chunks),
+			"packages":   c,
+		}), fmt.Sprintf("snapshot %v chunk %v/%v with %v packages", id, i+1, len(chunks), len(c)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkPackages splits pkgs into groups whose encoded size does not exceed
+// max bytes. There is always at least one group, so a host with no packages
+// still reports a snapshot.
+func chunkPackages(pkgs []snapshotPkg, max int) ([][]snapshotPkg, error) {
+	var (
+		ret  [][]snapshotPkg
+		cur  = []snapshotPkg{}
+		size int
+	)
+	for _, sp := range pkgs {
+		buf, err := json.Marshal(sp)
+		if err != nil {
+			return nil, err
+		}
+		if len(cur) > 0 && size+len(buf)+1 > max {
+			ret = append(ret, cur)
+			cur = []snapshotPkg{}
+			size = 0
+		}
+		cur = append(cur, sp)
+		size += len(buf) + 1
+	}
+	return append(ret, cur), nil
+}
+
+// snapshotPkgs converts the packages returned by scribe into snapshot entries,
+// with their source packages from sources
+func snapshotPkgs(pkgs []scribe.PackageInfo, sources pkgSources) []snapshotPkg {
+	ret := make([]snapshotPkg, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		src := sources[sourceKey(pkg)]
+		ret = append(ret, snapshotPkg{Name: pkg.Name, Version: pkg.Version, Type: pkg.Type, Arch: pkg.Arch,
+			Source: src.name, SourceVersion: src.version})
+	}
+	return ret
+}
+
+// newSnapshotID returns a random identifier used to group the chunks of a
+// snapshot
+func newSnapshotID() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
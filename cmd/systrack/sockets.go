@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla/scribe"
+	"github.com/sirupsen/logrus"
+)
+
+// socketState values in /proc/net, from include/net/tcp_states.h
+const (
+	tcpListen = 0x0a
+	// An unconnected UDP socket is reported in the close state, as are
+	// sockets bound for a connection that has not been made yet, so the
+	// remote address must be checked too
+	udpUnconnected = 0x07
+)
+
+// nativeEndian is the byte order of the host, which /proc/net addresses are
+// written in
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	switch runtime.GOARCH {
+	case "ppc64", "s390x", "mips", "mips64", "sparc64":
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// listenSocket is a socket accepting connections or datagrams
+type listenSocket struct {
+	proto   string // tcp, tcp6, udp or udp6
+	addr    net.IP
+	port    int
+	inode   uint64
+	pid     int
+	process string // Process name, from /proc/<pid>/comm
+	exe     string
+	pkg     scribe.PackageInfo
+}
+
+// exposed returns true if the socket accepts connections on an interface
+// other than loopback
+func (s *listenSocket) exposed() bool {
+	return !s.addr.IsLoopback()
+}
+
+// getListenSockets returns the listening TCP sockets and bound UDP sockets in
+// the network namespace of systrack, with the process owning each socket and
+// the package its executable belongs to
+func getListenSockets(owners fileOwners) (ret []listenSocket, err error) {
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		s, err := readProcNet("/proc/net/"+proto, proto)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// IPv6 may be disabled
+				continue
+			}
+			return nil, err
+		}
+		ret = append(ret, s...)
+	}
+	pids := socketPids()
+	for i := range ret {
+		s := &ret[i]
+		s.pid = pids[s.inode]
+		if s.pid == 0 {
+			// The socket belongs to the kernel, or to a process we
+			// cannot inspect
+			continue
+		}
+		buf, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/comm", s.pid))
+		if err == nil {
+			s.process = strings.TrimSpace(string(buf))
+		}
+		s.exe, err = os.Readlink(fmt.Sprintf("/proc/%v/exe", s.pid))
+		if err != nil {
+			continue
+		}
+		// The executable of a process in a container is a path in the
+		// container filesystem, which host packages say nothing about
+		if !sameRoot(s.pid) {
+			continue
+		}
+		s.pkg, _ = owners.owner(strings.TrimSuffix(s.exe, " (deleted)"))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].proto != ret[j].proto {
+			return ret[i].proto < ret[j].proto
+		}
+		return ret[i].port < ret[j].port
+	})
+	return ret, nil
+}
+
+// readProcNet parses one of the socket tables in /proc/net, returning the
+// listening sockets
+func readProcNet(path, proto string) (ret []listenSocket, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	want := int64(tcpListen)
+	if strings.HasPrefix(proto, "udp") {
+		want = udpUnconnected
+	}
+	scn := bufio.NewScanner(fd)
+	scn.Scan() // Header
+	for scn.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+		// retrnsmt uid timeout inode
+		f := strings.Fields(scn.Text())
+		if len(f) < 10 {
+			continue
+		}
+		st, err := strconv.ParseInt(f[3], 16, 64)
+		if err != nil || st != want {
+			continue
+		}
+		// A connected UDP socket, such as a client's, has a remote
+		// address and is not accepting datagrams from anyone
+		if want == udpUnconnected && strings.Trim(f[2], "0:") != "" {
+			continue
+		}
+		addr, port, err := parseProcNetAddr(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		inode, err := strconv.ParseUint(f[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v: invalid inode %q", path, f[9])
+		}
+		ret = append(ret, listenSocket{proto: proto, addr: addr, port: port, inode: inode})
+	}
+	err = scn.Err()
+	return
+}
+
+// parseProcNetAddr parses an address and port in /proc/net format, where the
+// address is written as 32 bit words in host byte order and the port in
+// network byte order, for example 0100007F:0016 is 127.0.0.1:22 on a little
+// endian host
+func parseProcNetAddr(s string) (net.IP, int, error) {
+	f := strings.Split(s, ":")
+	if len(f) != 2 {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	buf, err := hex.DecodeString(f[0])
+	if err != nil || (len(buf) != 4 && len(buf) != 16) {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(f[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port in %q", s)
+	}
+	ip := make(net.IP, len(buf))
+	for i := 0; i < len(buf); i += 4 {
+		w := nativeEndian.Uint32(buf[i : i+4])
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = byte(w>>24), byte(w>>16), byte(w>>8), byte(w)
+	}
+	return ip, int(port), nil
+}
+
+// socketPids maps socket inodes to the pid of a process holding the socket
+// open. Sockets shared by several processes, such as a forking server, are
+// attributed to the process with the lowest pid, usually the parent.
+func socketPids() map[uint64]int {
+	ret := make(map[uint64]int)
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, p := range procs {
+		pid, err := strconv.Atoi(filepath.Base(p))
+		if err != nil {
+			continue
+		}
+		fds, err := ioutil.ReadDir(filepath.Join(p, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fi := range fds {
+			link, err := os.Readlink(filepath.Join(p, "fd", fi.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(link[8:], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if cur, ok := ret[inode]; !ok || pid < cur {
+				ret[inode] = pid
+			}
+		}
+	}
+	return ret
+}
+
+// sameRoot returns true if the process pid has the same root directory as
+// systrack, so is not running in a container or chroot
+func sameRoot(pid int) bool {
+	a, err := os.Stat(fmt.Sprintf("/proc/%v/root/", pid))
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat("/")
+	if err != nil {
+		return false
+	}
+	return os.SameFile(a, b)
+}
+
+// emitSockets writes a record for each listening socket, including the
+// package owning the executable of the process listening on it and its source
+// package from sources
+func emitSockets(s sink, host logrus.Fields, sockets []listenSocket, sources pkgSources) error {
+	for _, x := range sockets {
+		err := emit(s, sources.add(withFields(host, logrus.Fields{
+			"rectype":    "socket",
+			"proto":      x.proto,
+			"address":    x.addr.String(),
+			"port":       x.port,
+			"exposed":    x.exposed(),
+			"pid":        x.pid,
+			"process":    x.process,
+			"exe":        x.exe,
+			"pkgname":    x.pkg.Name,
+			"pkgversion": x.pkg.Version,
+			"pkgtype":    x.pkg.Type,
+			"pkgarch":    x.pkg.Arch,
+		}), x.pkg), fmt.Sprintf("listening %v %v port %v %v", x.proto, x.addr, x.port, x.process))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestReadProcNet(t *testing.T) {
+	saved := nativeEndian
+	defer func() { nativeEndian = saved }()
+	// The fixtures were captured on a little endian host
+	nativeEndian = binary.LittleEndian
+
+	tests := []struct {
+		proto string
+		want  []listenSocket
+	}{
+		// The established connection is not listening
+		{"tcp", []listenSocket{
+			{proto: "tcp", addr: net.IPv4(0, 0, 0, 0).To4(), port: 22, inode: 19411},
+			{proto: "tcp", addr: net.IPv4(127, 0, 0, 1).To4(), port: 631, inode: 20035},
+		}},
+		// The connected client socket is in the same state as the bound
+		// sockets, but has a remote address
+		{"udp", []listenSocket{
+			{proto: "udp", addr: net.IPv4(127, 0, 0, 53).To4(), port: 53, inode: 18371},
+			{proto: "udp", addr: net.IPv4(0, 0, 0, 0).To4(), port: 68, inode: 21406},
+		}},
+	}
+	for _, tc := range tests {
+		got, err := readProcNet("testdata/procnet/"+tc.proto, tc.proto)
+		if err != nil {
+			t.Errorf("%v: %v", tc.proto, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %+v\nwant %+v", tc.proto, got, tc.want)
+		}
+	}
+}
+
+func TestParseProcNetAddr(t *testing.T) {
+	saved := nativeEndian
+	defer func() { nativeEndian = saved }()
+	tests := []struct {
+		order binary.ByteOrder
+		addr  string
+		want  string
+	}{
+		{binary.LittleEndian, "0100007F:0016", "127.0.0.1:22"},
+		{binary.BigEndian, "7F000001:0016", "127.0.0.1:22"},
+		{binary.LittleEndian, "00000000000000000000000001000000:01BB", "[::1]:443"},
+		{binary.BigEndian, "00000000000000000000000000000001:01BB", "[::1]:443"},
+	}
+	for _, tc := range tests {
+		nativeEndian = tc.order
+		ip, port, err := parseProcNetAddr(tc.addr)
+		if err != nil {
+			t.Errorf("%v: %v", tc.addr, err)
+			continue
+		}
+		if got := net.JoinHostPort(ip.String(), strconv.Itoa(port)); got != tc.want {
+			t.Errorf("%v: got %v, want %v", tc.addr, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// spoolRetryBase is the delay before retrying after the first failed
+	// delivery, doubling with each consecutive failure up to spoolRetryMax
+	spoolRetryBase = 5 * time.Minute
+	spoolRetryMax  = 6 * time.Hour
+	// spoolQueueFile holds the retry state of the spool
+	spoolQueueFile = "queue.json"
+	// spoolLockFile is locked while the spool is being delivered
+	spoolLockFile = ".lock"
+	// spoolBatchSize is the number of records delivered before the progress
+	// through a run is saved, the same as the batch size of the http and
+	// kinesis outputs
+	spoolBatchSize = 500
+)
+
+// spoolSink writes the records of a run to a file in a local spool directory
+// rather than directly to the output. When the run is complete the file is
+// committed to the spool, and every run in the spool is then delivered to the
+// output in the order the runs were made. Records are delivered in batches,
+// and the spool tracks how much of each run has been accepted, so a run that
+// fails part way is resumed from the first batch that was not delivered.
+type spoolSink struct {
+	dir    string
+	output string // Output specification runs are delivered to
+	so     sinkOptions
+	maxAge time.Duration
+	// maxSize is the maximum total size of the runs in the spool in bytes,
+	// the oldest runs are dropped to stay under it
+	maxSize int64
+	fd      *os.File
+	w       *bufio.Writer
+}
+
+// spoolQueue is the retry state of the spool, kept between invocations
+type spoolQueue struct {
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"lasterror,omitempty"`
+	NextAttempt time.Time `json:"nextattempt"`
+	// Delivered holds the number of bytes at the start of each partly
+	// delivered run whose records have been accepted
+	Delivered map[string]int64 `json:"delivered,omitempty"`
+}
+
+func newSpoolSink(dir, output string, so sinkOptions, maxSize int64, maxAge time.Duration) (*spoolSink, error) {
+	// Check the output specification now rather than when delivering
+	err := checkOutput(output)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	// The name sorts in the order runs are made, and the pid keeps concurrent
+	// runs apart
+	name := fmt.Sprintf("%020d-%d.jsonl", time.Now().UnixNano(), os.Getpid())
+	fd, err := os.OpenFile(filepath.Join(dir, name+".tmp"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &spoolSink{
+		dir:     dir,
+		output:  output,
+		so:      so,
+		maxAge:  maxAge,
+		maxSize: maxSize,
+		fd:      fd,
+		w:       bufio.NewWriter(fd),
+	}, nil
+}
+
+func (s *spoolSink) write(buf []byte) error {
+	_, err := s.w.Write(buf)
+	return err
+}
+
+// close commits the run to the spool and delivers the spool. Once the run has
+// been committed it is safe, so delivery failures are logged rather than
+// returned and the run is retried later.
+func (s *spoolSink) close() error {
+	tmp := s.fd.Name()
+	err := s.w.Flush()
+	if err == nil {
+		err = s.fd.Sync()
+	}
+	if cerr := s.fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, strings.TrimSuffix(tmp, ".tmp"))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = deliverSpool(s.dir, s.output, s.so, s.maxSize, s.maxAge, false)
+	if err != nil {
+		log.Printf("spooled run not delivered: %v\n", err)
+	}
+	return nil
+}
+
+// deliverSpool sends the runs in the spool directory to output, oldest first,
+// removing each run once it has been delivered. Delivery stops at the first
+// failure, and is not attempted again until the retry delay has passed unless
+// force is set.
+func deliverSpool(dir, output string, so sinkOptions, maxSize int64, maxAge time.Duration, force bool) error {
+	lock, err := os.OpenFile(filepath.Join(dir, spoolLockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		// Another invocation is delivering the spool, and will pick up
+		// our run too
+		return nil
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	runs, err := trimSpool(dir, maxSize, maxAge)
+	if err != nil {
+		return err
+	}
+	q := loadSpoolQueue(dir)
+	if len(runs) == 0 {
+		return nil
+	}
+	for name := range q.Delivered {
+		// Forget runs that were dropped from the spool
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			delete(q.Delivered, name)
+		}
+	}
+	if !force && time.Now().Before(q.NextAttempt) {
+		return fmt.Errorf("%v runs spooled, next attempt at %v after %v failures: %v",
+			len(runs), q.NextAttempt.Format(time.RFC3339), q.Failures, q.LastError)
+	}
+	for _, r := range runs {
+		name := filepath.Base(r)
+		err = deliverRun(r, output, so, q.Delivered[name], func(off int64) error {
+			if q.Delivered == nil {
+				q.Delivered = make(map[string]int64)
+			}
+			q.Delivered[name] = off
+			return saveSpoolQueue(dir, q)
+		})
+		if err != nil {
+			q.Failures++
+			q.LastError = err.Error()
+			q.NextAttempt = time.Now().Add(spoolBackoff(q.Failures))
+			if serr := saveSpoolQueue(dir, q); serr != nil {
+				log.Printf("%v\n", serr)
+			}
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		err = os.Remove(r)
+		if err != nil {
+			return err
+		}
+		delete(q.Delivered, name)
+	}
+	return saveSpoolQueue(dir, spoolQueue{})
+}
+
+// spoolBackoff returns the delay before the next delivery attempt after the
+// given number of consecutive failures
+func spoolBackoff(failures int) time.Duration {
+	d := spoolRetryBase
+	for i := 1; i < failures && d < spoolRetryMax; i++ {
+		d *= 2
+	}
+	if d > spoolRetryMax {
+		d = spoolRetryMax
+	}
+	return d
+}
+
+// deliverRun sends the records in the spooled run at path to a new sink for
+// output, starting at byte offset start. Once each batch of records has been
+// accepted, delivered is called with the offset of the end of the batch.
+func deliverRun(path, output string, so sinkOptions, start int64, delivered func(int64) error) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	_, err = fd.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	out, err := newSink(output, so)
+	if err != nil {
+		return err
+	}
+	var (
+		off   = start
+		count int
+	)
+	// endBatch flushes the records written so far if the output batches
+	// them, and records that they were delivered
+	endBatch := func() error {
+		if f, ok := out.(flusher); ok {
+			if err := f.flush(); err != nil {
+				return err
+			}
+		}
+		return delivered(off)
+	}
+	scn := bufio.NewScanner(fd)
+	scn.Buffer(make([]byte, 0, 64*1024), 2*snapshotMaxSize)
+	for scn.Scan() {
+		err = out.write(append(scn.Bytes(), '\n'))
+		if err != nil {
+			out.close()
+			return err
+		}
+		off += int64(len(scn.Bytes())) + 1
+		count++
+		if count%spoolBatchSize == 0 {
+			if err = endBatch(); err != nil {
+				out.close()
+				return err
+			}
+		}
+	}
+	if err = scn.Err(); err != nil {
+		out.close()
+		return err
+	}
+	if err = endBatch(); err != nil {
+		out.close()
+		return err
+	}
+	return out.close()
+}
+
+// trimSpool returns the committed runs in the spool, oldest first, after
+// removing runs that exceed the age or size limits and any runs left
+// incomplete by an invocation that did not finish
+func trimSpool(dir string, maxSize int64, maxAge time.Duration) (runs []string, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var (
+		keep  []os.FileInfo
+		total int64
+	)
+	for _, fi := range entries {
+		switch {
+		case strings.HasSuffix(fi.Name(), ".jsonl.tmp"):
+			// Runs in progress are left alone for a while, as they may
+			// belong to a concurrent invocation
+			if time.Since(fi.ModTime()) > time.Hour {
+				os.Remove(filepath.Join(dir, fi.Name()))
+			}
+		case strings.HasSuffix(fi.Name(), ".jsonl"):
+			if maxAge > 0 && time.Since(fi.ModTime()) > maxAge {
+				log.Printf("dropping spooled run %v older than %v\n", fi.Name(), maxAge)
+				os.Remove(filepath.Join(dir, fi.Name()))
+				continue
+			}
+			keep = append(keep, fi)
+			total += fi.Size()
+		}
+	}
+	// ReadDir sorts by name, so runs are already oldest first
+	for maxSize > 0 && total > maxSize && len(keep) > 0 {
+		log.Printf("dropping spooled run %v, spool exceeds %v bytes\n", keep[0].Name(), maxSize)
+		os.Remove(filepath.Join(dir, keep[0].Name()))
+		total -= keep[0].Size()
+		keep = keep[1:]
+	}
+	for _, fi := range keep {
+		runs = append(runs, filepath.Join(dir, fi.Name()))
+	}
+	return runs, nil
+}
+
+// loadSpoolQueue reads the retry state of the spool, an unreadable state is
+// treated as no previous failures
+func loadSpoolQueue(dir string) (q spoolQueue) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, spoolQueueFile))
+	if err != nil {
+		return
+	}
+	if json.Unmarshal(buf, &q) != nil {
+		return spoolQueue{}
+	}
+	return
+}
+
+func saveSpoolQueue(dir string, q spoolQueue) error {
+	buf, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, spoolQueueFile+".tmp")
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, spoolQueueFile))
+}
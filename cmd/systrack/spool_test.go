@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// collectorStandIn stands in for a remote collector taking batches of records
+// over HTTP. While failing is set every request is rejected, and if failAt is
+// set the request with that number is rejected, counting from 1.
+type collectorStandIn struct {
+	records  []string
+	requests int
+	failing  bool
+	failAt   int
+}
+
+func (c *collectorStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.requests++
+	if c.failing || c.requests == c.failAt {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	scn := bufio.NewScanner(r.Body)
+	for scn.Scan() {
+		c.records = append(c.records, scn.Text())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// spoolRun writes a run of n records through a spool sink for output
+func spoolRun(t *testing.T, dir, output string, n int) {
+	s, err := newSpoolSink(dir, output, sinkOptions{httpTimeout: 5 * time.Second}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		err = s.write([]byte(fmt.Sprintf("{\"n\":%d}\n", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// spooledRuns returns the names of the committed runs in the spool
+func spooledRuns(t *testing.T, dir string) []string {
+	runs, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runs
+}
+
+// checkRecords checks that records holds each of the n records of a run once,
+// in order
+func checkRecords(t *testing.T, records []string, n int) {
+	if len(records) != n {
+		t.Fatalf("got %v records, want %v", len(records), n)
+	}
+	for i, r := range records {
+		if want := fmt.Sprintf("{\"n\":%d}", i); r != want {
+			t.Fatalf("record %v is %v, want %v", i, r, want)
+		}
+	}
+}
+
+// spoolRecords returns records from to to as written by spoolRun
+func spoolRecords(from, to int) string {
+	var ret []string
+	for i := from; i < to; i++ {
+		ret = append(ret, fmt.Sprintf("{\"n\":%d}\n", i))
+	}
+	return strings.Join(ret, "")
+}
+
+func TestSpoolDelivery(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := &collectorStandIn{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	spoolRun(t, dir, srv.URL, 1200)
+	checkRecords(t, c.records, 1200)
+	if c.requests != 3 {
+		t.Errorf("got %v requests, want 3 batches", c.requests)
+	}
+	if runs := spooledRuns(t, dir); len(runs) != 0 {
+		t.Errorf("runs left in spool: %v", runs)
+	}
+}
+
+func TestSpoolRetry(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := &collectorStandIn{failing: true}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	// Runs that fail stay in the spool, and are not tried again until the
+	// retry delay has passed
+	spoolRun(t, dir, srv.URL, 10)
+	spoolRun(t, dir, srv.URL, 20)
+	if runs := spooledRuns(t, dir); len(runs) != 2 {
+		t.Fatalf("got %v runs in spool, want 2", len(runs))
+	}
+	if c.requests != 1 {
+		t.Errorf("got %v requests, want 1 before the retry delay", c.requests)
+	}
+	q := loadSpoolQueue(dir)
+	if q.Failures != 1 || q.LastError == "" || time.Until(q.NextAttempt) < spoolRetryBase-time.Minute {
+		t.Errorf("unexpected queue state %+v", q)
+	}
+
+	// Runs are delivered oldest first once the output accepts them
+	c.failing = false
+	err := deliverSpool(dir, srv.URL, sinkOptions{httpTimeout: 5 * time.Second}, 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, c.records[:10], 10)
+	checkRecords(t, c.records[10:], 20)
+	if runs := spooledRuns(t, dir); len(runs) != 0 {
+		t.Errorf("runs left in spool: %v", runs)
+	}
+	if q := loadSpoolQueue(dir); q.Failures != 0 || len(q.Delivered) != 0 {
+		t.Errorf("queue not reset: %+v", q)
+	}
+}
+
+func TestSpoolPartialDelivery(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// The second batch of the run fails
+	c := &collectorStandIn{failAt: 2}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	spoolRun(t, dir, srv.URL, 1200)
+	checkRecords(t, c.records, 500)
+	runs := spooledRuns(t, dir)
+	if len(runs) != 1 {
+		t.Fatalf("got %v runs in spool, want 1", len(runs))
+	}
+	q := loadSpoolQueue(dir)
+	if off := q.Delivered[filepath.Base(runs[0])]; off != int64(len(spoolRecords(0, 500))) {
+		t.Errorf("delivered offset %v, want the end of the first batch", off)
+	}
+
+	// Only the batches that were not accepted are sent again
+	err := deliverSpool(dir, srv.URL, sinkOptions{httpTimeout: 5 * time.Second}, 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, c.records, 1200)
+	if runs := spooledRuns(t, dir); len(runs) != 0 {
+		t.Errorf("runs left in spool: %v", runs)
+	}
+}
+
+func TestTrimSpool(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, r := range []struct {
+		name  string
+		size  int
+		mtime time.Time
+	}{
+		{"00000000000000000001-1.jsonl", 100, old},
+		{"00000000000000000002-1.jsonl", 100, time.Now()},
+		{"00000000000000000003-1.jsonl", 100, time.Now()},
+		{"00000000000000000004-1.jsonl", 100, time.Now()},
+		{"00000000000000000005-1.jsonl.tmp", 100, old},
+		{"00000000000000000006-1.jsonl.tmp", 100, time.Now()},
+	} {
+		path := writeFile(t, dir, r.name, strings.Repeat("x", r.size))
+		err := os.Chtimes(path, r.mtime, r.mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := trimSpool(dir, 250, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The run older than a day is dropped, then the oldest run to bring the
+	// spool under 250 bytes, and the abandoned run in progress
+	want := []string{
+		filepath.Join(dir, "00000000000000000003-1.jsonl"),
+		filepath.Join(dir, "00000000000000000004-1.jsonl"),
+	}
+	if strings.Join(runs, " ") != strings.Join(want, " ") {
+		t.Errorf("got runs %v, want %v", runs, want)
+	}
+	left, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range left {
+		names = append(names, fi.Name())
+	}
+	if got := strings.Join(names, " "); got != "00000000000000000003-1.jsonl "+
+		"00000000000000000004-1.jsonl 00000000000000000006-1.jsonl.tmp" {
+		t.Errorf("spool holds %v", got)
+	}
+}
+
+func TestSpoolBackoff(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:  5 * time.Minute,
+		2:  10 * time.Minute,
+		4:  40 * time.Minute,
+		7:  320 * time.Minute,
+		8:  6 * time.Hour,
+		30: 6 * time.Hour,
+	} {
+		if got := spoolBackoff(failures); got != want {
+			t.Errorf("backoff after %v failures is %v, want %v", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// A minimal read-only SQLite reader, sufficient to read the Packages table of
+// an rpm sqlite database without cgo. Only table b-trees are walked, indexes
+// are ignored, and committed pages in the write-ahead log are used in place of
+// the pages in the database file.
+//
+// See https://www.sqlite.org/fileformat2.html
+
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// sqlite b-tree page types
+const (
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+)
+
+type sqliteDB struct {
+	fd       *os.File
+	pageSize uint32
+	usable   uint32            // Page size less the reserved space at the end of each page
+	pages    uint64            // Pages in the database file and write-ahead log
+	wal      map[uint32][]byte // Pages taken from the write-ahead log
+}
+
+// readSqliteRpmDB returns the header blobs stored in an rpm sqlite database
+func readSqliteRpmDB(path string) ([][]byte, error) {
+	db, err := openSqlite(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.fd.Close()
+	root, err := db.tableRoot("Packages")
+	if err != nil {
+		return nil, err
+	}
+	var ret [][]byte
+	err = db.walkTable(root, 0, func(rec []byte) error {
+		// The table is (hnum INTEGER PRIMARY KEY, blob BLOB), hnum is an
+		// alias for the rowid so is stored as NULL
+		vals, err := sqliteRecord(rec)
+		if err != nil {
+			return err
+		}
+		if len(vals) == 2 {
+			if b, ok := vals[1].([]byte); ok {
+				ret = append(ret, b)
+			}
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// openSqlite opens the database at path, loading committed frames from the
+// write-ahead log if there is one
+func openSqlite(path string) (db *sqliteDB, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	hdr := make([]byte, 100)
+	_, err = io.ReadFull(fd, hdr)
+	if err != nil {
+		fd.Close()
+		return
+	}
+	if !bytes.HasPrefix(hdr, sqliteMagic) {
+		fd.Close()
+		return nil, errors.New("not a SQLite database")
+	}
+	db = &sqliteDB{fd: fd, pageSize: uint32(binary.BigEndian.Uint16(hdr[16:18]))}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		fd.Close()
+		return nil, fmt.Errorf("invalid page size %v", db.pageSize)
+	}
+	db.usable = db.pageSize - uint32(hdr[20])
+	if db.usable < 480 {
+		fd.Close()
+		return nil, fmt.Errorf("invalid reserved space %v", hdr[20])
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	db.wal, err = readSqliteWAL(path+"-wal", db.pageSize)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	db.pages = uint64(fi.Size())/uint64(db.pageSize) + uint64(len(db.wal))
+	return db, nil
+}
+
+// readSqliteWAL returns the most recent version of each page written to the
+// write-ahead log by a committed transaction. A missing log is not an error.
+func readSqliteWAL(path string, pageSize uint32) (map[uint32][]byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(buf) < 32 {
+		return nil, nil
+	}
+	magic := binary.BigEndian.Uint32(buf[0:4])
+	if magic != 0x377f0682 && magic != 0x377f0683 {
+		return nil, errors.New("invalid write-ahead log header")
+	}
+	if binary.BigEndian.Uint32(buf[8:12]) != pageSize {
+		return nil, errors.New("write-ahead log page size does not match database")
+	}
+	salt := buf[16:24]
+	var (
+		ret     = make(map[uint32][]byte)
+		pending = make(map[uint32][]byte)
+	)
+	// Frames left over from an earlier checkpoint have a different salt,
+	// which marks the end of the log
+	for off := 32; off+24+int(pageSize) <= len(buf); off += 24 + int(pageSize) {
+		frame := buf[off : off+24+int(pageSize)]
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+		pending[binary.BigEndian.Uint32(frame[0:4])] = frame[24:]
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			// Commit frame, the transaction is complete
+			for k, v := range pending {
+				ret[k] = v
+			}
+			pending = make(map[uint32][]byte)
+		}
+	}
+	return ret, nil
+}
+
+// page returns page n, where pages are numbered from 1
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	if n == 0 {
+		return nil, errors.New("invalid page number 0")
+	}
+	if p, ok := db.wal[n]; ok {
+		return p, nil
+	}
+	buf := make([]byte, db.pageSize)
+	_, err := db.fd.ReadAt(buf, int64(n-1)*int64(db.pageSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading page %v: %v", n, err)
+	}
+	return buf, nil
+}
+
+// tableRoot returns the root page of the named table from the schema table,
+// which is always rooted at page 1
+func (db *sqliteDB) tableRoot(name string) (root uint32, err error) {
+	err = db.walkTable(1, 0, func(rec []byte) error {
+		vals, err := sqliteRecord(rec)
+		if err != nil {
+			return err
+		}
+		// type, name, tbl_name, rootpage, sql
+		if len(vals) < 4 {
+			return nil
+		}
+		if t, _ := vals[0].(string); t != "table" {
+			return nil
+		}
+		if n, _ := vals[1].(string); n != name {
+			return nil
+		}
+		if r, ok := vals[3].(int64); ok {
+			root = uint32(r)
+		}
+		return nil
+	})
+	if err == nil && root == 0 {
+		err = fmt.Errorf("table %v not found", name)
+	}
+	return
+}
+
+// walkTable calls fn with the payload of each row in the table b-tree rooted
+// at page n, in rowid order
+func (db *sqliteDB) walkTable(n uint32, depth int, fn func([]byte) error) error {
+	if depth > 20 {
+		return errors.New("b-tree too deep")
+	}
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	hdr := page
+	if n == 1 {
+		// The first page starts with the database header
+		hdr = page[100:]
+	}
+	ncells := int(binary.BigEndian.Uint16(hdr[3:5]))
+	switch hdr[0] {
+	case sqliteInteriorTable:
+		if 12+ncells*2 > len(hdr) {
+			return fmt.Errorf("invalid cell count on page %v", n)
+		}
+		for i := 0; i < ncells; i++ {
+			off := int(binary.BigEndian.Uint16(hdr[12+i*2:]))
+			if off+4 > len(page) {
+				return fmt.Errorf("invalid cell offset on page %v", n)
+			}
+			err = db.walkTable(binary.BigEndian.Uint32(page[off:off+4]), depth+1, fn)
+			if err != nil {
+				return err
+			}
+		}
+		return db.walkTable(binary.BigEndian.Uint32(hdr[8:12]), depth+1, fn)
+	case sqliteLeafTable:
+		if 8+ncells*2 > len(hdr) {
+			return fmt.Errorf("invalid cell count on page %v", n)
+		}
+		for i := 0; i < ncells; i++ {
+			off := int(binary.BigEndian.Uint16(hdr[8+i*2:]))
+			if off >= len(page) {
+				return fmt.Errorf("invalid cell offset on page %v", n)
+			}
+			payload, err := db.leafPayload(page[off:])
+			if err != nil {
+				return fmt.Errorf("page %v: %v", n, err)
+			}
+			err = fn(payload)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("page %v is not a table b-tree page", n)
+}
+
+// leafPayload returns the payload of a table leaf cell, following the chain of
+// overflow pages if the payload does not fit in the cell
+func (db *sqliteDB) leafPayload(cell []byte) ([]byte, error) {
+	size, l := sqliteVarint(cell)
+	if l == 0 {
+		return nil, errors.New("invalid cell")
+	}
+	cell = cell[l:]
+	_, l = sqliteVarint(cell) // rowid
+	if l == 0 {
+		return nil, errors.New("invalid cell")
+	}
+	cell = cell[l:]
+	u := uint64(db.usable)
+	if size > db.pages*u {
+		// The size is read from the file, so is checked before
+		// allocating for it
+		return nil, errors.New("cell payload exceeds database")
+	}
+	x := u - 35
+	if size <= x {
+		if size > uint64(len(cell)) {
+			return nil, errors.New("cell exceeds page")
+		}
+		return cell[:size], nil
+	}
+	// The amount stored in the cell itself is chosen so the remainder fills
+	// whole overflow pages where possible
+	m := ((u-12)*32)/255 - 23
+	local := m + (size-m)%(u-4)
+	if local > x {
+		local = m
+	}
+	if local+4 > uint64(len(cell)) {
+		return nil, errors.New("cell exceeds page")
+	}
+	ret := make([]byte, 0, size)
+	ret = append(ret, cell[:local]...)
+	next := binary.BigEndian.Uint32(cell[local : local+4])
+	for uint64(len(ret)) < size {
+		if next == 0 {
+			return nil, errors.New("truncated overflow chain")
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		n := size - uint64(len(ret))
+		if n > u-4 {
+			n = u - 4
+		}
+		ret = append(ret, page[4:4+n]...)
+		next = binary.BigEndian.Uint32(page[0:4])
+	}
+	return ret, nil
+}
+
+// sqliteVarint decodes a SQLite variable length integer, returning the value
+// and the number of bytes read, or 0 bytes if buf is too short
+func sqliteVarint(buf []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(buf) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(buf[i]), 9
+		}
+		v = v<<7 | uint64(buf[i]&0x7f)
+		if buf[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// sqliteRecord decodes a record into its column values, which are nil,
+// int64, float64 (returned as nil), string or []byte
+func sqliteRecord(rec []byte) ([]interface{}, error) {
+	hlen, l := sqliteVarint(rec)
+	if l == 0 || hlen > uint64(len(rec)) {
+		return nil, errors.New("invalid record header")
+	}
+	var (
+		types []uint64
+		pos   = uint64(l)
+	)
+	for pos < hlen {
+		t, l := sqliteVarint(rec[pos:hlen])
+		if l == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		types = append(types, t)
+		pos += uint64(l)
+	}
+	ret := make([]interface{}, 0, len(types))
+	body := rec[hlen:]
+	for _, t := range types {
+		var n uint64
+		switch {
+		case t >= 1 && t <= 4:
+			n = t
+		case t == 5:
+			n = 6
+		case t == 6 || t == 7:
+			n = 8
+		case t >= 12:
+			n = (t - 12) / 2
+		}
+		if n > uint64(len(body)) {
+			return nil, errors.New("record exceeds payload")
+		}
+		v := body[:n]
+		body = body[n:]
+		switch {
+		case t == 0 || t == 7:
+			ret = append(ret, nil)
+		case t <= 6:
+			// Big-endian two's complement integer
+			i := int64(int8(v[0]))
+			for _, b := range v[1:] {
+				i = i<<8 | int64(b)
+			}
+			ret = append(ret, i)
+		case t == 8:
+			ret = append(ret, int64(0))
+		case t == 9:
+			ret = append(ret, int64(1))
+		case t >= 12 && t%2 == 0:
+			ret = append(ret, v)
+		case t >= 13:
+			ret = append(ret, string(v))
+		default:
+			return nil, fmt.Errorf("invalid serial type %v", t)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// inventoryState is stored in the local state file, and records the inventory
+// last reported by systrack so later runs can report only what has changed
+type inventoryState struct {
+	Runs     int           `json:"runs"`    // Differential runs since the last full report
+	LastRun  time.Time     `json:"lastrun"` // Time the inventory was reported
+	Packages []snapshotPkg `json:"packages"`
+}
+
+// pkgChange describes a difference between the reported and current inventory
+type pkgChange struct {
+	change     string // added, removed, upgraded or downgraded
+	name       string
+	pkgType    string
+	arch       string
+	oldVersion string
+	newVersion string
+
+	// The source package, if it has another name or version
+	source        string
+	sourceVersion string
+}
+
+// loadState reads the state file at path. If the file does not exist, nil is
+// returned without an error.
+func loadState(path string) (*inventoryState, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var st inventoryState
+	err = json.Unmarshal(buf, &st)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state file %v: %v", path, err)
+	}
+	return &st, nil
+}
+
+// saveState writes st to the state file at path. The state is written to a
+// temporary file first and renamed into place, so an interrupted run will not
+// leave a truncated state file behind.
+func saveState(path string, st *inventoryState) error {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	fd, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(buf)
+	if err == nil {
+		err = fd.Sync()
+	}
+	cerr := fd.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// pkgKey identifies a package independent of its version
+func pkgKey(p snapshotPkg) string {
+	return p.Type + "/" + p.Name + "/" + p.Arch
+}
+
+// diffPackages compares the previously reported packages in old with the
+// current packages in cur. A package name can have more than one version
+// installed at once (for example the kernel), so versions are compared as
+// sets, and a single removed version replaced by a single added version is
+// reported as an upgrade or downgrade.
+func diffPackages(old, cur []snapshotPkg) []pkgChange {
+	oldVers := make(map[string]map[string]bool)
+	curVers := make(map[string]map[string]bool)
+	pkgs := make(map[string]snapshotPkg)
+	for _, p := range old {
+		k := pkgKey(p)
+		if oldVers[k] == nil {
+			oldVers[k] = make(map[string]bool)
+		}
+		oldVers[k][p.Version] = true
+		pkgs[k] = p
+	}
+	for _, p := range cur {
+		k := pkgKey(p)
+		if curVers[k] == nil {
+			curVers[k] = make(map[string]bool)
+		}
+		curVers[k][p.Version] = true
+		pkgs[k] = p
+	}
+	keys := make([]string, 0, len(pkgs))
+	for k := range pkgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret []pkgChange
+	for _, k := range keys {
+		var added, removed []string
+		for v := range curVers[k] {
+			if !oldVers[k][v] {
+				added = append(added, v)
+			}
+		}
+		for v := range oldVers[k] {
+			if !curVers[k][v] {
+				removed = append(removed, v)
+			}
+		}
+		sort.Strings(added)
+		sort.Strings(removed)
+		p := pkgs[k]
+		if len(added) == 1 && len(removed) == 1 {
+			ret = append(ret, pkgChange{
+				change:        versionChange(p.Type, removed[0], added[0]),
+				name:          p.Name,
+				pkgType:       p.Type,
+				arch:          p.Arch,
+				oldVersion:    removed[0],
+				newVersion:    added[0],
+				source:        p.Source,
+				sourceVersion: p.SourceVersion,
+			})
+			continue
+		}
+		for _, v := range removed {
+			ret = append(ret, pkgChange{change: "removed", name: p.Name, pkgType: p.Type,
+				arch: p.Arch, oldVersion: v, source: p.Source})
+		}
+		for _, v := range added {
+			ret = append(ret, pkgChange{change: "added", name: p.Name, pkgType: p.Type,
+				arch: p.Arch, newVersion: v, source: p.Source, sourceVersion: p.SourceVersion})
+		}
+	}
+	return ret
+}
+
+// versionChange returns upgraded if newv is a later version than oldv, and
+// downgraded if it is earlier, comparing them with the rules of the package
+// manager of pkgType
+func versionChange(pkgType, oldv, newv string) string {
+	c, err := compareVersions(pkgType, oldv, newv)
+	if err == nil && c > 0 {
+		return "downgraded"
+	}
+	return "upgraded"
+}
+
+// emitChanges writes a change record for each difference between the inventory
+// in st and the current packages
+func emitChanges(s sink, host logrus.Fields, st *inventoryState, cur []snapshotPkg) error {
+	for _, c := range diffPackages(st.Packages, cur) {
+		version := c.newVersion
+		if c.change == "removed" {
+			version = c.oldVersion
+		}
+		fields := withFields(host, logrus.Fields{
+			"rectype":    "change",
+			"changetype": c.change,
+			"since":      st.LastRun,
+			"pkgname":    c.name,
+			"pkgversion": version,
+			"pkgtype":    c.pkgType,
+			"pkgarch":    c.arch,
+			"oldversion": c.oldVersion,
+			"newversion": c.newVersion,
+		})
+		if c.source != "" {
+			fields["pkgsource"] = c.source
+		}
+		if c.sourceVersion != "" {
+			fields["pkgsourceversion"] = c.sourceVersion
+		}
+		err := emit(s, fields, fmt.Sprintf("package %v %v %v %v -> %v", c.name, c.change, c.arch,
+			c.oldVersion, c.newVersion))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
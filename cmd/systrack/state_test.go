@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCompareDpkgVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1-1", "1.0~rc2-1", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0+b1", "1.0", 1},
+		{"1.0+dfsg-1", "1.0-1", 1},
+		{"1.0a", "1.0+", -1},
+		{"1:1.0", "2.0", 1},
+		{"1.10", "1.9", 1},
+		{"1.010", "1.10", 0},
+		{"3.0.11-1~deb12u2", "3.0.11-1", -1},
+		{"7.88.1-10+deb12u5", "7.88.1-10+deb12u4", 1},
+		{"2.36-9+deb12u4", "2.36-9+deb12u10", -1},
+		{"1.2.3-0ubuntu0.22.04.1", "1.2.3-0ubuntu0.20.04.1", 1},
+	}
+	for _, tt := range tests {
+		if got := compareDpkgVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareDpkgVersions(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareDpkgVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareDpkgVersions(%q, %q) = %v, want %v", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestDiffPackages(t *testing.T) {
+	old := []snapshotPkg{
+		{Name: "libssl3", Version: "3.0.11-1~deb12u1", Type: "dpkg", Arch: "amd64", Source: "openssl"},
+		{Name: "tzdata", Version: "2024a-0+deb12u1", Type: "dpkg", Arch: "all"},
+		{Name: "busybox", Version: "1.36.1-r5", Type: "apk", Arch: "x86_64"},
+		{Name: "musl", Version: "1.2.4-r2", Type: "apk", Arch: "x86_64"},
+		{Name: "bash", Version: "4.2.46-34.el7", Type: "rpm", Arch: "x86_64"},
+		{Name: "kernel", Version: "3.10.0-1160.el7", Type: "rpm", Arch: "x86_64"},
+		{Name: "telnet", Version: "0.17-66.el7", Type: "rpm", Arch: "x86_64"},
+	}
+	cur := []snapshotPkg{
+		// dpkg: ~ sorts before the end of a version, + after it
+		{Name: "libssl3", Version: "3.0.11-1~deb12u2", Type: "dpkg", Arch: "amd64", Source: "openssl"},
+		{Name: "tzdata", Version: "2024a-0", Type: "dpkg", Arch: "all"},
+		// apk: a pre-release is lower than the release
+		{Name: "busybox", Version: "1.36.1_rc1-r0", Type: "apk", Arch: "x86_64"},
+		{Name: "musl", Version: "1.2.4_git20230717-r4", Type: "apk", Arch: "x86_64"},
+		{Name: "bash", Version: "4.2.46-35.el7_9", Type: "rpm", Arch: "x86_64"},
+		{Name: "kernel", Version: "3.10.0-1160.el7", Type: "rpm", Arch: "x86_64"},
+		{Name: "kernel", Version: "3.10.0-1160.108.1.el7", Type: "rpm", Arch: "x86_64"},
+		{Name: "nginx", Version: "1.24.0-r7", Type: "apk", Arch: "x86_64"},
+	}
+	want := []pkgChange{
+		{"downgraded", "busybox", "apk", "x86_64", "1.36.1-r5", "1.36.1_rc1-r0", "", ""},
+		{"upgraded", "musl", "apk", "x86_64", "1.2.4-r2", "1.2.4_git20230717-r4", "", ""},
+		{"added", "nginx", "apk", "x86_64", "", "1.24.0-r7", "", ""},
+		{"upgraded", "libssl3", "dpkg", "amd64", "3.0.11-1~deb12u1", "3.0.11-1~deb12u2", "openssl", ""},
+		{"downgraded", "tzdata", "dpkg", "all", "2024a-0+deb12u1", "2024a-0", "", ""},
+		{"upgraded", "bash", "rpm", "x86_64", "4.2.46-34.el7", "4.2.46-35.el7_9", "", ""},
+		{"added", "kernel", "rpm", "x86_64", "", "3.10.0-1160.108.1.el7", "", ""},
+		{"removed", "telnet", "rpm", "x86_64", "0.17-66.el7", "", "", ""},
+	}
+	got := diffPackages(old, cur)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	st, err := loadState(path)
+	if st != nil || err != nil {
+		t.Fatalf("missing state file gave %v, %v", st, err)
+	}
+	want := &inventoryState{Runs: 3, Packages: []snapshotPkg{
+		{Name: "bash", Version: "5.2.15-2+b2", Type: "dpkg", Arch: "amd64"},
+	}}
+	if err = saveState(path, want); err != nil {
+		t.Fatal(err)
+	}
+	st, err = loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(st, want) {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+	writeFile(t, dir, "state.json", "{")
+	if _, err = loadState(path); err == nil {
+		t.Errorf("truncated state file accepted")
+	}
+}
+
+// recordSink keeps the records written to it
+type recordSink struct {
+	records []string
+}
+
+func (s *recordSink) write(buf []byte) error {
+	s.records = append(s.records, string(buf))
+	return nil
+}
+
+func (s *recordSink) close() error {
+	return nil
+}
+
+func TestLangState(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	pkgs, err := readDpkgStatus("testdata/pkgdb/dpkg/var/lib/dpkg/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := options{
+		root:      "testdata/lang",
+		format:    "package",
+		statePath: filepath.Join(dir, "state.json"),
+		fullEvery: 24,
+	}
+	// runOnce runs the packages and lang collectors, saving their state, and
+	// returns the records written
+	runOnce := func() []string {
+		out := &recordSink{}
+		r := &run{opts: &o, out: out, pkgs: pkgs, host: logrus.Fields{"fqdn": "host1"},
+			states: make(map[string]*inventoryState)}
+		for _, c := range collectors {
+			if c.name != "packages" && c.name != "lang" {
+				continue
+			}
+			if err := c.collect(r); err != nil {
+				t.Fatalf("%v: %v", c.name, err)
+			}
+		}
+		for path, st := range r.states {
+			if err := saveState(path, st); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return out.records
+	}
+
+	// The first run is a full report of the 7 system and 8 language packages
+	if got := runOnce(); len(got) != 15 {
+		t.Fatalf("first run sent %v records, want 15", len(got))
+	}
+	if got := runOnce(); len(got) != 0 {
+		t.Errorf("unchanged run sent %v records", len(got))
+	}
+	// Language packages are compared with their own state, so removing them
+	// does not change what is reported for the system packages
+	o.root = dir
+	got := runOnce()
+	if len(got) != 8 {
+		t.Fatalf("run without language packages sent %v records, want 8", len(got))
+	}
+	for _, rec := range got {
+		if !strings.Contains(rec, `"changetype":"removed"`) || strings.Contains(rec, `"pkgtype":"dpkg"`) {
+			t.Errorf("unexpected record %v", rec)
+		}
+	}
+}
@@ -43,14 +43,21 @@ func getHostname() string {
 	return kernhostname
 }
 
-// getSysVersion returns the version of the linux system
-func getSysInfo() (sysinfo string, err error) {
+// getSysInfo returns the version of the linux system installed under root. For
+// the live system it is read from lsb_release, which describes the host rather
+// than root, so for any other root it is read from the PRETTY_NAME in
+// os-release instead. Both fall back to the first line of /etc/issue.
+func getSysInfo(root string) (sysinfo string, err error) {
 	var err1 error
-	sysinfo, err = getLSBRelease()
+	if root == "/" {
+		sysinfo, err = getLSBRelease()
+	} else {
+		sysinfo, err = getOSReleaseName(root)
+	}
 	if err != nil {
-		sysinfo, err1 = getIssue()
+		sysinfo, err1 = getIssue(root)
 		if err1 != nil {
-			err = fmt.Errorf("failed to read sysinfo from lsb (err was %q) and issue (err was %q)", err.Error(), err1.Error())
+			err = fmt.Errorf("failed to read sysinfo from lsb or os-release (err was %q) and issue (err was %q)", err.Error(), err1.Error())
 		} else {
 			err = nil
 		}
@@ -73,43 +80,31 @@ func getLSBRelease() (desc string, err error) {
 	return
 }
 
-// getIssue parses /etc/issue and returns the first line
-func getIssue() (string, error) {
-	issue, err := ioutil.ReadFile("/etc/issue")
-	if err != nil {
-		return "", err
-	}
-	loc := bytes.IndexAny(issue, "\n")
-	if loc < 2 {
-		return "", fmt.Errorf("issue string not found")
+// getOSReleaseName returns the PRETTY_NAME in the os-release file under root
+func getOSReleaseName(root string) (string, error) {
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		vals, err := readKeyValueFile(resolveInRoot(root, p))
+		if err != nil {
+			continue
+		}
+		if vals["PRETTY_NAME"] != "" {
+			return cleanString(vals["PRETTY_NAME"]), nil
+		}
 	}
-	return fmt.Sprintf("%s", issue[0:loc]), nil
+	return "", fmt.Errorf("no PRETTY_NAME in os-release under %v", root)
 }
 
-// getDist parses various distribution files to find the distro version
-func getDist() (string, error) {
-	data, err := ioutil.ReadFile("/etc/centos-release")
+// getIssue parses /etc/issue under root and returns the first line
+func getIssue(root string) (string, error) {
+	issue, err := ioutil.ReadFile(resolveInRoot(root, "/etc/issue"))
 	if err != nil {
-		return "", nil
+		return "", err
 	}
-	loc := bytes.IndexAny(data, "\n")
+	loc := bytes.IndexAny(issue, "\n")
 	if loc < 2 {
 		return "", fmt.Errorf("issue string not found")
 	}
-	issue := fmt.Sprintf("%s", data[0:loc])
-	if strings.HasPrefix(issue, "CentOS Linux release 5") {
-		return "centos:5", nil
-	}
-	if strings.HasPrefix(issue, "CentOS Linux release 6") {
-		return "centos:6", nil
-	}
-	if strings.HasPrefix(issue, "CentOS Linux release 7") {
-		return "centos:7", nil
-	}
-	if strings.HasPrefix(issue, "CentOS Linux release 8") {
-		return "centos:8", nil
-	}
-	return issue, nil
+	return fmt.Sprintf("%s", issue[0:loc]), nil
 }
 
 // cleanString removes spaces, quotes and newlines
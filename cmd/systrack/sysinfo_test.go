@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetSysInfoRoot(t *testing.T) {
+	// The name in os-release under root is used, not lsb_release of the
+	// live host
+	got, err := getSysInfo("testdata/dist/debian12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Debian GNU/Linux 12 (bookworm)" {
+		t.Errorf("got %q from os-release", got)
+	}
+
+	// Without os-release the first line of issue under root is used
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	writeFile(t, root, "/etc/issue", "CentOS release 6.10 (Final)\nKernel \\r on an \\m\n\n")
+	got, err = getSysInfo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "CentOS release 6.10 (Final)" {
+		t.Errorf("got %q from issue", got)
+	}
+
+	os.Remove(root + "/etc/issue")
+	if _, err := getSysInfo(root); err == nil {
+		t.Error("no error with neither os-release nor issue")
+	}
+}
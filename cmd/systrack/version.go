@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla/scribe"
+)
+
+// compareVersions compares versions a and b of a package of type pkgType with
+// the rules of its package manager, returning -1, 0 or 1 if a is lower than,
+// equal to or greater than b. Versions of packages other than dpkg and apk
+// packages are compared as rpm versions.
+func compareVersions(pkgType, a, b string) (int, error) {
+	switch pkgType {
+	case "dpkg":
+		return compareDpkgVersions(a, b), nil
+	case "apk":
+		return compareApkVersions(a, b)
+	}
+	gt, err := scribe.TestEvrCompare(scribe.EvropGreaterThan, a, b)
+	if err != nil {
+		return 0, err
+	}
+	if gt {
+		return 1, nil
+	}
+	lt, err := scribe.TestEvrCompare(scribe.EvropLessThan, a, b)
+	if err != nil {
+		return 0, err
+	}
+	if lt {
+		return -1, nil
+	}
+	return 0, nil
+}
+
+// compareDpkgVersions compares dpkg versions a and b as dpkg does, where ~
+// sorts before anything, even the end of a version, so 1.0~rc1 is lower than
+// 1.0, and + after letters, so 1.0+b1 is greater than 1.0
+func compareDpkgVersions(a, b string) int {
+	ae, au, ar := splitDpkgVersion(a)
+	be, bu, br := splitDpkgVersion(b)
+	if ae != be {
+		return compareInts(ae, be)
+	}
+	if c := compareDpkgPart(au, bu); c != 0 {
+		return c
+	}
+	return compareDpkgPart(ar, br)
+}
+
+// splitDpkgVersion splits a dpkg version into its epoch, upstream version and
+// Debian revision
+func splitDpkgVersion(v string) (epoch int, upstream, revision string) {
+	v = strings.TrimSpace(v)
+	if i := strings.Index(v, ":"); i != -1 {
+		epoch, _ = strconv.Atoi(v[:i])
+		v = v[i+1:]
+	}
+	if i := strings.LastIndex(v, "-"); i != -1 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+// dpkgOrder returns the weight of a non-digit character of a dpkg version, or
+// of the end of the string if c is 0
+func dpkgOrder(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return 0
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == '~':
+		return -1
+	case c != 0:
+		return int(c) + 256
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// compareDpkgPart compares the upstream versions or revisions a and b, which
+// alternate between non-digit strings compared by dpkgOrder and numbers
+func compareDpkgPart(a, b string) int {
+	at := func(s string, i int) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return 0
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := dpkgOrder(at(a, i)), dpkgOrder(at(b, j))
+			if ac != bc {
+				return compareInts(ac, bc)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		diff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if diff == 0 {
+				diff = compareInts(int(a[i]), int(b[j]))
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if diff != 0 {
+			return diff
+		}
+	}
+	return 0
+}
+
+// The apk version rules below are the same as those the lambda uses to check
+// Alpine packages against the secdb.
+
+// Token types in an apk version, in the order apk ranks them when two
+// versions differ in their type at the same position, where a later type
+// means a lower version. For example 1.2.1 is greater than 1.2a, which is
+// greater than 1.2_p1, which is greater than 1.2-r1, which is greater than
+// 1.2.
+const (
+	apkTokenDigit = iota
+	apkTokenLetter
+	apkTokenSuffix
+	apkTokenRevision
+	apkTokenEnd
+)
+
+// apkSuffixes are the suffixes allowed in an apk version in increasing order.
+// Those before the empty string are pre-releases, which are lower than the
+// version without a suffix.
+var apkSuffixes = []string{"alpha", "beta", "pre", "rc", "", "cvs", "svn", "git", "hg", "p"}
+
+// apkPreReleases is the number of pre-release suffixes in apkSuffixes
+const apkPreReleases = 4
+
+// apkToken is a component of an apk version
+type apkToken struct {
+	typ    int
+	digits string // Digit tokens
+	n      int    // Letter, suffix rank or revision
+	suffix int    // Number following a suffix
+}
+
+// apkParseVersion splits an apk version such as 1.2.3_rc1-r0 into its
+// components
+func apkParseVersion(v string) (ret []apkToken, err error) {
+	bad := fmt.Errorf("invalid apk version %q", v)
+	s := v
+	digits := func() string {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		d := s[:i]
+		s = s[i:]
+		return d
+	}
+	d := digits()
+	if d == "" {
+		return nil, bad
+	}
+	ret = append(ret, apkToken{typ: apkTokenDigit, digits: d})
+	for len(s) > 0 && s[0] == '.' {
+		s = s[1:]
+		d = digits()
+		if d == "" {
+			return nil, bad
+		}
+		ret = append(ret, apkToken{typ: apkTokenDigit, digits: d})
+	}
+	if len(s) > 0 && s[0] >= 'a' && s[0] <= 'z' {
+		ret = append(ret, apkToken{typ: apkTokenLetter, n: int(s[0])})
+		s = s[1:]
+	}
+	for len(s) > 0 && s[0] == '_' {
+		i := 1
+		for i < len(s) && s[i] >= 'a' && s[i] <= 'z' {
+			i++
+		}
+		rank := -1
+		for j, x := range apkSuffixes {
+			if x != "" && x == s[1:i] {
+				rank = j
+			}
+		}
+		if rank == -1 {
+			return nil, bad
+		}
+		s = s[i:]
+		n, _ := strconv.Atoi(digits())
+		ret = append(ret, apkToken{typ: apkTokenSuffix, n: rank, suffix: n})
+	}
+	if len(s) > 0 && s[0] == '~' {
+		// A commit hash, which is not ordered
+		i := strings.Index(s, "-")
+		if i == -1 {
+			i = len(s)
+		}
+		s = s[i:]
+	}
+	if strings.HasPrefix(s, "-r") {
+		s = s[2:]
+		d = digits()
+		if d == "" {
+			return nil, bad
+		}
+		n, err := strconv.Atoi(d)
+		if err != nil {
+			return nil, bad
+		}
+		ret = append(ret, apkToken{typ: apkTokenRevision, n: n})
+	}
+	if s != "" {
+		return nil, bad
+	}
+	return ret, nil
+}
+
+// compareApkVersions compares apk versions a and b following the rules of apk,
+// returning -1, 0 or 1 if a is lower than, equal to or greater than b
+func compareApkVersions(a, b string) (int, error) {
+	at, err := apkParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	bt, err := apkParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; ; i++ {
+		x, y := apkToken{typ: apkTokenEnd}, apkToken{typ: apkTokenEnd}
+		if i < len(at) {
+			x = at[i]
+		}
+		if i < len(bt) {
+			y = bt[i]
+		}
+		if x.typ != y.typ {
+			// A pre-release suffix is lower than anything else, otherwise
+			// the version with the earlier token type is greater
+			switch {
+			case x.typ == apkTokenSuffix && x.n < apkPreReleases:
+				return -1, nil
+			case y.typ == apkTokenSuffix && y.n < apkPreReleases:
+				return 1, nil
+			case x.typ > y.typ:
+				return -1, nil
+			}
+			return 1, nil
+		}
+		c := 0
+		switch x.typ {
+		case apkTokenEnd:
+			return 0, nil
+		case apkTokenDigit:
+			c = compareApkDigits(x.digits, y.digits, i == 0)
+		case apkTokenSuffix:
+			c = compareInts(x.n, y.n)
+			if c == 0 {
+				c = compareInts(x.suffix, y.suffix)
+			}
+		default:
+			c = compareInts(x.n, y.n)
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+}
+
+// compareApkDigits compares the digits of a version component. Components
+// after the first with a leading zero are compared as strings, so 1.02 is
+// lower than 1.1, and others as numbers.
+func compareApkDigits(a, b string, first bool) int {
+	if !first && (a[0] == '0' || b[0] == '0') {
+		return strings.Compare(a, b)
+	}
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return compareInts(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// compareInts returns -1, 0 or 1 if a is less than, equal to or greater than b
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
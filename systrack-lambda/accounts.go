@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/coreos/clair/database"
+)
+
+// accountRecTypes are the record types systrack uses to describe who can log
+// in to a host. These are checked against the account policy rather than for
+// vulnerabilities.
+var accountRecTypes = map[string]bool{
+	"account": true,
+	"group":   true,
+	"sudoers": true,
+	"sshkey":  true,
+}
+
+// Names of the findings reported for account records
+const (
+	findingUID0   = "unexpected-uid0"
+	findingSSHKey = "unexpected-sshkey"
+)
+
+// loadAccountConfig reads the accounts allowed to have UID 0 and the allowed
+// SSH key fingerprints from the environment. If no fingerprints are given,
+// SSH keys are not checked.
+func loadAccountConfig() error {
+	cfg.uid0Accounts = make(map[string]bool)
+	names := os.Getenv("UID0_ACCOUNTS")
+	if names == "" {
+		names = "root"
+	}
+	for _, n := range strings.Split(names, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			cfg.uid0Accounts[n] = true
+		}
+	}
+	cfg.sshKeys = make(map[string]bool)
+	addKeys := func(spec string) {
+		scn := bufio.NewScanner(strings.NewReader(strings.Replace(spec, ",", "\n", -1)))
+		for scn.Scan() {
+			line := strings.TrimSpace(scn.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			cfg.sshKeys[line] = true
+		}
+	}
+	addKeys(os.Getenv("SSHKEY_ALLOWLIST"))
+	if path := os.Getenv("SSHKEY_ALLOWFILE"); path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		addKeys(string(buf))
+	}
+	return nil
+}
+
+// checkAccount checks an account record against the account policy, returning
+// output lines for UID 0 accounts that are not allowed and for SSH keys not in
+// the allowlist. Keys authorized for root are critical, other keys high.
+func checkAccount(p pkgLogEnt) (ret []string) {
+	var v database.VulnerabilityWithAffected
+	switch p.Fields.RecType {
+	case "account":
+		if p.Fields.UID != 0 || cfg.uid0Accounts[p.Fields.Username] {
+			return
+		}
+		v.Name, v.Severity = findingUID0, database.CriticalSeverity
+	case "sshkey":
+		if len(cfg.sshKeys) == 0 || cfg.sshKeys[p.Fields.Fingerprint] {
+			return
+		}
+		v.Name, v.Severity = findingSSHKey, database.HighSeverity
+		if p.Fields.Username == "root" {
+			v.Severity = database.CriticalSeverity
+		}
+	default:
+		// Groups and sudoers rules are only inventoried
+		return
+	}
+	log.Printf("%v %v on %v\n", v.Name, p.Fields.Username, p.Hostname)
+	p.Fields.setDefaults()
+	p.Fields.PkgArch, p.Fields.PkgName, p.Fields.PkgVersion = "none", "none", "none"
+	return []string{p.toLogEntry(v)}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/versionfmt/rpm"
+	"github.com/coreos/clair/ext/vulnsrc"
+	"github.com/coreos/clair/pkg/commonerr"
+)
+
+const (
+	alasURI           = "https://alas.aws.amazon.com/"
+	alasUpdaterFlag   = "amazonUpdater"
+	alasSecurityType  = "security"
+	alasRepomdPath    = "repodata/repomd.xml"
+	alasUpdateinfoKey = "updateinfo"
+)
+
+// alasRelease is an Amazon Linux release whose security advisories are read
+// from the updateinfo of its core repository
+type alasRelease struct {
+	namespace  string
+	mirrorList string // Lists the URLs of the core repository
+	linkDir    string // Directory of the release advisories on alasURI
+}
+
+var alasReleases = []alasRelease{
+	{"amzn:2", "https://cdn.amazonlinux.com/2/core/latest/x86_64/mirror.list", "AL2"},
+	{"amzn:2023", "https://cdn.amazonlinux.com/al2023/core/mirrors/latest/x86_64/mirror.list", "AL2023"},
+}
+
+// updateinfo is the advisory metadata of a yum repository
+type updateinfo struct {
+	Updates []alasUpdate `xml:"update"`
+}
+
+type alasUpdate struct {
+	Type        string `xml:"type,attr"`
+	ID          string `xml:"id"`
+	Title       string `xml:"title"`
+	Severity    string `xml:"severity"`
+	Description string `xml:"description"`
+	References  []struct {
+		Href string `xml:"href,attr"`
+		ID   string `xml:"id,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"references>reference"`
+	Packages []struct {
+		Name    string `xml:"name,attr"`
+		Epoch   string `xml:"epoch,attr"`
+		Version string `xml:"version,attr"`
+		Release string `xml:"release,attr"`
+	} `xml:"pkglist>collection>package"`
+}
+
+// repomd is the index of the metadata files of a yum repository
+type repomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+// fetchALAS reads the Amazon Linux security advisories. If ALAS_UPDATEINFO is
+// set, they are read from the saved updateinfo files it lists as
+// namespace=path entries, such as amzn:2=/tmp/updateinfo.xml.gz, rather than
+// downloaded from the repositories of each release.
+func fetchALAS() (resp vulnsrc.UpdateResponse, err error) {
+	if x := os.Getenv("ALAS_UPDATEINFO"); x != "" {
+		for _, e := range strings.Split(x, ",") {
+			i := strings.LastIndex(e, "=")
+			if i == -1 {
+				return resp, fmt.Errorf("ALAS_UPDATEINFO entry %q is not namespace=path", e)
+			}
+			rel, ok := alasReleaseFor(strings.TrimSpace(e[:i]))
+			if !ok {
+				return resp, fmt.Errorf("ALAS_UPDATEINFO namespace %q is not an Amazon Linux release", e[:i])
+			}
+			fd, err := os.Open(strings.TrimSpace(e[i+1:]))
+			if err != nil {
+				return resp, err
+			}
+			vs, err := parseUpdateinfo(fd, rel)
+			fd.Close()
+			if err != nil {
+				return resp, err
+			}
+			resp.Vulnerabilities = append(resp.Vulnerabilities, vs...)
+		}
+	} else {
+		for _, rel := range alasReleases {
+			vs, err := fetchUpdateinfo(rel)
+			if err != nil {
+				return resp, err
+			}
+			resp.Vulnerabilities = append(resp.Vulnerabilities, vs...)
+		}
+	}
+	resp.FlagName = alasUpdaterFlag
+	return resp, nil
+}
+
+// alasReleaseFor returns the Amazon Linux release with namespace ns
+func alasReleaseFor(ns string) (alasRelease, bool) {
+	for _, rel := range alasReleases {
+		if rel.namespace == ns {
+			return rel, true
+		}
+	}
+	return alasRelease{}, false
+}
+
+// fetchUpdateinfo downloads and parses the updateinfo of the core repository
+// of rel, finding the repository from the mirror list and the updateinfo from
+// the repository index
+func fetchUpdateinfo(rel alasRelease) ([]database.VulnerabilityWithAffected, error) {
+	r, err := httpGetOK(rel.mirrorList)
+	if err != nil {
+		return nil, err
+	}
+	var mirror string
+	scn := bufio.NewScanner(r.Body)
+	for scn.Scan() && mirror == "" {
+		mirror = strings.TrimSpace(scn.Text())
+	}
+	r.Body.Close()
+	if mirror == "" {
+		return nil, commonerr.ErrCouldNotDownload
+	}
+	mirror = strings.TrimSuffix(mirror, "/") + "/"
+
+	r, err = httpGetOK(mirror + alasRepomdPath)
+	if err != nil {
+		return nil, err
+	}
+	var md repomd
+	err = xml.NewDecoder(r.Body).Decode(&md)
+	r.Body.Close()
+	if err != nil {
+		return nil, commonerr.ErrCouldNotParse
+	}
+	for _, d := range md.Data {
+		if d.Type != alasUpdateinfoKey {
+			continue
+		}
+		r, err = httpGetOK(mirror + d.Location.Href)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Body.Close()
+		return parseUpdateinfo(r.Body, rel)
+	}
+	return nil, fmt.Errorf("no updateinfo in %v repository", rel.namespace)
+}
+
+// httpGetOK requests uri, returning an error unless the response is 200 OK
+func httpGetOK(uri string) (*http.Response, error) {
+	r, err := http.Get(uri)
+	if err != nil {
+		return nil, commonerr.ErrCouldNotDownload
+	}
+	if r.StatusCode != http.StatusOK {
+		r.Body.Close()
+		return nil, commonerr.ErrCouldNotDownload
+	}
+	return r, nil
+}
+
+// parseUpdateinfo parses the security advisories of release rel in an
+// updateinfo file, which may be gzip compressed as it is in the repository
+func parseUpdateinfo(r io.Reader, rel alasRelease) (vulnerabilities []database.VulnerabilityWithAffected, err error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, commonerr.ErrCouldNotParse
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	var ui updateinfo
+	err = xml.NewDecoder(r).Decode(&ui)
+	if err != nil {
+		return nil, commonerr.ErrCouldNotParse
+	}
+
+	for _, u := range ui.Updates {
+		if u.Type != alasSecurityType {
+			continue
+		}
+		vulnerability := database.VulnerabilityWithAffected{
+			Vulnerability: database.Vulnerability{
+				Name:        u.ID,
+				Link:        alasLink(u, rel),
+				Severity:    alasSeverity(u.Severity),
+				Description: strings.TrimSpace(u.Description),
+			},
+		}
+		// Packages are listed once for each architecture, with the same
+		// version
+		seen := make(map[string]bool)
+		for _, p := range u.Packages {
+			if seen[p.Name] || p.Name == "" || p.Version == "" || p.Release == "" {
+				continue
+			}
+			seen[p.Name] = true
+			epoch := p.Epoch
+			if epoch == "" {
+				epoch = "0"
+			}
+			version := epoch + ":" + p.Version + "-" + p.Release
+			vulnerability.Affected = append(vulnerability.Affected, database.AffectedFeature{
+				FeatureName:     p.Name,
+				AffectedVersion: version,
+				FixedInVersion:  version,
+				Namespace: database.Namespace{
+					Name:          rel.namespace,
+					VersionFormat: rpm.ParserName,
+				},
+			})
+		}
+		if len(vulnerability.Affected) == 0 {
+			continue
+		}
+		sort.Slice(vulnerability.Affected, func(i, j int) bool {
+			return vulnerability.Affected[i].FeatureName < vulnerability.Affected[j].FeatureName
+		})
+		vulnerabilities = append(vulnerabilities, vulnerability)
+	}
+	return vulnerabilities, nil
+}
+
+// alasLink returns the address of the advisory page for u. Advisories are
+// published as, for example, AL2/ALAS-2023-2000.html for ALAS2-2023-2000.
+func alasLink(u alasUpdate, rel alasRelease) string {
+	for _, ref := range u.References {
+		if ref.Type == "self" && ref.Href != "" {
+			return ref.Href
+		}
+	}
+	id := u.ID
+	if i := strings.Index(id, "-"); i != -1 && strings.HasPrefix(id, "ALAS") {
+		id = "ALAS" + id[i:]
+	}
+	return alasURI + rel.linkDir + "/" + id + ".html"
+}
+
+func alasSeverity(sev string) database.Severity {
+	switch strings.ToLower(strings.TrimSpace(sev)) {
+	case "low":
+		return database.LowSeverity
+	case "medium", "moderate":
+		return database.MediumSeverity
+	case "important":
+		return database.HighSeverity
+	case "critical":
+		return database.CriticalSeverity
+	default:
+		fmt.Fprintf(os.Stderr, "could not determine vulnerability severity from: %s.\n", sev)
+		return database.UnknownSeverity
+	}
+}
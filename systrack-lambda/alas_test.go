@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/versionfmt/rpm"
+)
+
+func TestParseUpdateinfo(t *testing.T) {
+	type feature struct {
+		ns, name, fixed string
+	}
+	type advisory struct {
+		link     string
+		severity database.Severity
+		features []feature
+	}
+	want := map[string]advisory{
+		// The link is made from the ALAS2 id, as there is no self reference
+		"ALAS2-2023-2000": {"https://alas.aws.amazon.com/AL2/ALAS-2023-2000.html",
+			database.MediumSeverity, []feature{
+				{"amzn:2", "curl", "0:7.88.1-1.amzn2.0.4"},
+				{"amzn:2", "libcurl", "0:7.88.1-1.amzn2.0.4"},
+			}},
+		"ALAS2-2023-2050": {"https://alas.aws.amazon.com/AL2/ALAS-2023-2050.html",
+			database.HighSeverity, []feature{
+				{"amzn:2", "openssl", "1:1.0.2k-24.amzn2.0.6"},
+				{"amzn:2", "openssl-libs", "1:1.0.2k-24.amzn2.0.6"},
+			}},
+		"ALAS2023-2023-127": {"https://alas.aws.amazon.com/AL2023/ALAS-2023-127.html",
+			database.HighSeverity, []feature{
+				{"amzn:2023", "openssl", "1:3.0.8-1.amzn2023.0.6"},
+				{"amzn:2023", "openssl-libs", "1:3.0.8-1.amzn2023.0.6"},
+			}},
+		// Packages without an epoch have epoch 0, and the self reference
+		// is the link
+		"ALAS2023-2023-181": {"https://alas.aws.amazon.com/AL2023/ALAS-2023-181.html",
+			database.MediumSeverity, []feature{
+				{"amzn:2023", "curl-minimal", "0:8.0.1-1.amzn2023.0.1"},
+				{"amzn:2023", "libcurl-minimal", "0:8.0.1-1.amzn2023.0.1"},
+			}},
+	}
+	got := make(map[string]advisory)
+	for path, ns := range map[string]string{
+		"sample/alas/amzn2-updateinfo.xml":    "amzn:2",
+		"sample/alas/amzn2023-updateinfo.xml": "amzn:2023",
+	} {
+		rel, ok := alasReleaseFor(ns)
+		if !ok {
+			t.Fatalf("no release %v", ns)
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vulns, err := parseUpdateinfo(bytes.NewReader(buf), rel)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		// The updateinfo in the repository is compressed
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		w.Write(buf)
+		w.Close()
+		gzVulns, err := parseUpdateinfo(&gz, rel)
+		if err != nil {
+			t.Fatalf("%v compressed: %v", path, err)
+		}
+		if !reflect.DeepEqual(vulns, gzVulns) {
+			t.Errorf("%v: compressed updateinfo parsed as %+v", path, gzVulns)
+		}
+		for _, v := range vulns {
+			a := advisory{link: v.Link, severity: v.Severity}
+			for _, f := range v.Affected {
+				if f.Namespace.VersionFormat != rpm.ParserName || f.AffectedVersion != f.FixedInVersion {
+					t.Errorf("%v: unexpected feature %+v", v.Name, f)
+				}
+				a.features = append(a.features, feature{f.Namespace.Name, f.FeatureName,
+					f.FixedInVersion})
+			}
+			got[v.Name] = a
+		}
+	}
+	// The tzdata bugfix update is not a security advisory
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2017 clair authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This is a slightly modified version of the alpine-secdb parser from the
+// clair project. The secdb is now published as JSON for each release and
+// repository rather than in a git repository, vulnerabilities are grouped by
+// name, and versions are compared with apk rules rather than as dpkg versions.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/vulnsrc"
+	"github.com/coreos/clair/pkg/commonerr"
+)
+
+const (
+	alpineSecdbURI    = "https://secdb.alpinelinux.org/"
+	alpineVulnURI     = "https://security.alpinelinux.org/vuln/"
+	alpineUpdaterFlag = "alpine-secdbUpdater"
+	apkVersionFormat  = "apk"
+)
+
+var (
+	// alpineRepos are the repositories fetched for each release
+	alpineRepos = []string{"main", "community"}
+
+	alpineReleaseRegexp = regexp.MustCompile(`href="v(\d+\.\d+)/"`)
+)
+
+type secDBFile struct {
+	Distro   string `json:"distroversion"`
+	Packages []struct {
+		Pkg struct {
+			Name  string              `json:"name"`
+			Fixes map[string][]string `json:"secfixes"`
+		} `json:"pkg"`
+	} `json:"packages"`
+}
+
+func fetchAlpine() (resp vulnsrc.UpdateResponse, err error) {
+	r, err := http.Get(alpineSecdbURI)
+	if err != nil {
+		return resp, commonerr.ErrCouldNotDownload
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil || r.StatusCode != http.StatusOK {
+		return resp, commonerr.ErrCouldNotDownload
+	}
+
+	var files []secDBFile
+	for _, m := range alpineReleaseRegexp.FindAllStringSubmatch(string(buf), -1) {
+		for _, repo := range alpineRepos {
+			r, err := http.Get(alpineSecdbURI + "v" + m[1] + "/" + repo + ".json")
+			if err != nil {
+				return resp, commonerr.ErrCouldNotDownload
+			}
+			if r.StatusCode == http.StatusNotFound {
+				// Not every release has every repository
+				r.Body.Close()
+				continue
+			}
+			if r.StatusCode != http.StatusOK {
+				r.Body.Close()
+				return resp, commonerr.ErrCouldNotDownload
+			}
+			f, err := parseSecDB(r.Body)
+			r.Body.Close()
+			if err != nil {
+				return resp, err
+			}
+			files = append(files, f)
+		}
+	}
+	return buildAlpineResponse(files), nil
+}
+
+func parseSecDB(r io.Reader) (file secDBFile, err error) {
+	err = json.NewDecoder(r).Decode(&file)
+	if err != nil {
+		return file, commonerr.ErrCouldNotParse
+	}
+	return
+}
+
+func buildAlpineResponse(files []secDBFile) (resp vulnsrc.UpdateResponse) {
+	mvulnerabilities := make(map[string]*database.VulnerabilityWithAffected)
+	for _, file := range files {
+		namespace := "alpine:" + strings.TrimPrefix(file.Distro, "v")
+		for _, pack := range file.Packages {
+			pkg := pack.Pkg
+			for version, vulnStrs := range pkg.Fixes {
+				// A fixed version of 0 means the package was never
+				// affected in this release.
+				if version == "0" {
+					continue
+				}
+				if _, err := apkParseVersion(version); err != nil {
+					continue
+				}
+				for _, vulnStr := range vulnStrs {
+					// Entries can list several identifiers, or have a
+					// comment after the identifier
+					for _, name := range strings.Fields(vulnStr) {
+						if strings.HasPrefix(name, "(") {
+							break
+						}
+						vulnerability, ok := mvulnerabilities[name]
+						if !ok {
+							vulnerability = &database.VulnerabilityWithAffected{
+								Vulnerability: database.Vulnerability{
+									Name:     name,
+									Link:     alpineVulnURI + name,
+									Severity: database.UnknownSeverity,
+								},
+							}
+							mvulnerabilities[name] = vulnerability
+						}
+						vulnerability.Affected = append(vulnerability.Affected, database.AffectedFeature{
+							FeatureName:     pkg.Name,
+							AffectedVersion: version,
+							FixedInVersion:  version,
+							Namespace: database.Namespace{
+								Name:          namespace,
+								VersionFormat: apkVersionFormat,
+							},
+						})
+					}
+				}
+			}
+		}
+	}
+
+	// Convert the vulnerabilities map to a slice, sorted so the cache is the
+	// same for the same data
+	for _, v := range mvulnerabilities {
+		sort.Slice(v.Affected, func(i, j int) bool {
+			if v.Affected[i].Namespace.Name != v.Affected[j].Namespace.Name {
+				return v.Affected[i].Namespace.Name < v.Affected[j].Namespace.Name
+			}
+			return v.Affected[i].FeatureName < v.Affected[j].FeatureName
+		})
+		resp.Vulnerabilities = append(resp.Vulnerabilities, *v)
+	}
+	sort.Slice(resp.Vulnerabilities, func(i, j int) bool {
+		return resp.Vulnerabilities[i].Name < resp.Vulnerabilities[j].Name
+	})
+	resp.FlagName = alpineUpdaterFlag
+	return resp
+}
+
+// Token types in an apk version, in the order apk ranks them when two
+// versions differ in their type at the same position, where a later type
+// means a lower version. For example 1.2.1 is greater than 1.2a, which is
+// greater than 1.2_p1, which is greater than 1.2-r1, which is greater than
+// 1.2.
+const (
+	apkTokenDigit = iota
+	apkTokenLetter
+	apkTokenSuffix
+	apkTokenRevision
+	apkTokenEnd
+)
+
+// apkSuffixes are the suffixes allowed in an apk version in increasing order.
+// Those before the empty string are pre-releases, which are lower than the
+// version without a suffix.
+var apkSuffixes = []string{"alpha", "beta", "pre", "rc", "", "cvs", "svn", "git", "hg", "p"}
+
+// apkPreReleases is the number of pre-release suffixes in apkSuffixes
+const apkPreReleases = 4
+
+// apkToken is a component of an apk version
+type apkToken struct {
+	typ    int
+	digits string // Digit tokens
+	n      int    // Letter, suffix rank or revision
+	suffix int    // Number following a suffix
+}
+
+// apkParseVersion splits an apk version such as 1.2.3_rc1-r0 into its
+// components
+func apkParseVersion(v string) (ret []apkToken, err error) {
+	bad := fmt.Errorf("invalid apk version %q", v)
+	s := v
+	digits := func() string {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		d := s[:i]
+		s = s[i:]
+		return d
+	}
+	d := digits()
+	if d == "" {
+		return nil, bad
+	}
+	ret = append(ret, apkToken{typ: apkTokenDigit, digits: d})
+	for len(s) > 0 && s[0] == '.' {
+		s = s[1:]
+		d = digits()
+		if d == "" {
+			return nil, bad
+		}
+		ret = append(ret, apkToken{typ: apkTokenDigit, digits: d})
+	}
+	if len(s) > 0 && s[0] >= 'a' && s[0] <= 'z' {
+		ret = append(ret, apkToken{typ: apkTokenLetter, n: int(s[0])})
+		s = s[1:]
+	}
+	for len(s) > 0 && s[0] == '_' {
+		i := 1
+		for i < len(s) && s[i] >= 'a' && s[i] <= 'z' {
+			i++
+		}
+		rank := -1
+		for j, x := range apkSuffixes {
+			if x != "" && x == s[1:i] {
+				rank = j
+			}
+		}
+		if rank == -1 {
+			return nil, bad
+		}
+		s = s[i:]
+		n, _ := strconv.Atoi(digits())
+		ret = append(ret, apkToken{typ: apkTokenSuffix, n: rank, suffix: n})
+	}
+	if len(s) > 0 && s[0] == '~' {
+		// A commit hash, which is not ordered
+		i := strings.Index(s, "-")
+		if i == -1 {
+			i = len(s)
+		}
+		s = s[i:]
+	}
+	if strings.HasPrefix(s, "-r") {
+		s = s[2:]
+		d = digits()
+		if d == "" {
+			return nil, bad
+		}
+		n, err := strconv.Atoi(d)
+		if err != nil {
+			return nil, bad
+		}
+		ret = append(ret, apkToken{typ: apkTokenRevision, n: n})
+	}
+	if s != "" {
+		return nil, bad
+	}
+	return ret, nil
+}
+
+// compareApkVersions compares apk versions a and b following the rules of apk,
+// returning -1, 0 or 1 if a is lower than, equal to or greater than b
+func compareApkVersions(a, b string) (int, error) {
+	at, err := apkParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	bt, err := apkParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; ; i++ {
+		x, y := apkToken{typ: apkTokenEnd}, apkToken{typ: apkTokenEnd}
+		if i < len(at) {
+			x = at[i]
+		}
+		if i < len(bt) {
+			y = bt[i]
+		}
+		if x.typ != y.typ {
+			// A pre-release suffix is lower than anything else, otherwise
+			// the version with the earlier token type is greater
+			switch {
+			case x.typ == apkTokenSuffix && x.n < apkPreReleases:
+				return -1, nil
+			case y.typ == apkTokenSuffix && y.n < apkPreReleases:
+				return 1, nil
+			case x.typ > y.typ:
+				return -1, nil
+			}
+			return 1, nil
+		}
+		c := 0
+		switch x.typ {
+		case apkTokenEnd:
+			return 0, nil
+		case apkTokenDigit:
+			c = compareApkDigits(x.digits, y.digits, i == 0)
+		case apkTokenSuffix:
+			c = compareInts(x.n, y.n)
+			if c == 0 {
+				c = compareInts(x.suffix, y.suffix)
+			}
+		default:
+			c = compareInts(x.n, y.n)
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+}
+
+// compareApkDigits compares the digits of a version component. Components
+// after the first with a leading zero are compared as strings, so 1.02 is
+// lower than 1.1, and others as numbers.
+func compareApkDigits(a, b string, first bool) int {
+	if !first && (a[0] == '0' || b[0] == '0') {
+		return strings.Compare(a, b)
+	}
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return compareInts(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// compareInts returns -1, 0 or 1 if a is less than, equal to or greater than b
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestCompareApkVersions(t *testing.T) {
+	// Cases in the style of the version tests of apk-tools
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.0", -1},
+		{"1.0.1", "1.1", -1},
+		{"2.2.39-r1", "1.0.4-r3", 1},
+		{"1.10", "1.9", 1},
+		// Suffixes before the version without one are pre-releases
+		{"1.0_alpha", "1.0_beta", -1},
+		{"1.0_beta", "1.0_pre", -1},
+		{"1.0_pre", "1.0_rc", -1},
+		{"1.0_rc", "1.0", -1},
+		{"1.0", "1.0_cvs", -1},
+		{"1.0_cvs", "1.0_svn", -1},
+		{"1.0_svn", "1.0_git", -1},
+		{"1.0_git", "1.0_hg", -1},
+		{"1.0_hg", "1.0_p", -1},
+		{"1.0_alpha", "1.0_alpha1", -1},
+		{"1.0_alpha2", "1.0_alpha10", -1},
+		{"1.0_rc1", "0.9", 1},
+		{"1.2.4_git20230717", "1.2.4", 1},
+		{"1.0_p1", "1.0-r5", 1},
+		{"1.0_rc1", "1.0-r5", -1},
+		{"1.0_alpha_p1", "1.0_alpha", 1},
+		// A letter follows the last number
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0b", -1},
+		{"1.0z", "1.0.1", -1},
+		{"1.0b", "1.0b_p1", -1},
+		// Numbers after the first with a leading zero compare as strings
+		{"1.02", "1.1", -1},
+		{"1.010", "1.01", 1},
+		{"1.002", "1.01", -1},
+		{"01.1", "1.1", 0},
+		// A commit hash is not ordered
+		{"1.0_git20240101~abc123", "1.0_git20240101~def456", 0},
+		{"1.0_git20240101~abc123-r1", "1.0_git20240101-r0", 1},
+		// Revisions
+		{"1.0", "1.0-r0", -1},
+		{"1.0-r0", "1.0-r1", -1},
+		{"1.0-r9", "1.0-r10", -1},
+		{"3.1.4-r5", "3.1.4-r1", 1},
+	}
+	for _, tc := range tests {
+		for _, x := range []struct {
+			a, b string
+			want int
+		}{{tc.a, tc.b, tc.want}, {tc.b, tc.a, -tc.want}} {
+			c, err := compareApkVersions(x.a, x.b)
+			if err != nil {
+				t.Errorf("%v %v: %v", x.a, x.b, err)
+			} else if c != x.want {
+				t.Errorf("compareApkVersions(%v, %v) = %v, want %v", x.a, x.b, c, x.want)
+			}
+		}
+	}
+	for _, v := range []string{"", "a1", "1.", "1..0", "1.0_foo", "1.0-r", "1.0-rc1", "1.0-1", "1.0 "} {
+		if _, err := compareApkVersions(v, "1.0"); err == nil {
+			t.Errorf("compareApkVersions(%q) had no error", v)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 clair authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This is a slightly modified version of the Debian Security Tracker parser
+// from the clair project. Only fixed vulnerabilities are kept, and releases
+// are mapped to versions here as the clair mapping stops at buster.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/versionfmt"
+	"github.com/coreos/clair/ext/versionfmt/dpkg"
+	"github.com/coreos/clair/ext/vulnsrc"
+	"github.com/coreos/clair/ext/vulnsrc/debian"
+	"github.com/coreos/clair/pkg/commonerr"
+)
+
+const (
+	debianURI          = "https://security-tracker.debian.org/tracker/data/json"
+	debianCVEURIPrefix = "https://security-tracker.debian.org/tracker/"
+	debianUpdaterFlag  = "debianUpdater"
+)
+
+// debianReleases maps Debian code names to the version used in the namespace
+var debianReleases = map[string]string{
+	"jessie":   "8",
+	"stretch":  "9",
+	"buster":   "10",
+	"bullseye": "11",
+	"bookworm": "12",
+	"trixie":   "13",
+	"forky":    "14",
+}
+
+type debianData map[string]map[string]debianVuln
+
+type debianVuln struct {
+	Description string                `json:"description"`
+	Releases    map[string]debianRels `json:"releases"`
+}
+
+type debianRels struct {
+	FixedVersion string `json:"fixed_version"`
+	Status       string `json:"status"`
+	Urgency      string `json:"urgency"`
+}
+
+func fetchDebian() (resp vulnsrc.UpdateResponse, err error) {
+	r, err := http.Get(debianURI)
+	if err != nil {
+		return resp, commonerr.ErrCouldNotDownload
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return resp, commonerr.ErrCouldNotDownload
+	}
+	return parseDebian(r.Body)
+}
+
+func parseDebian(jsonReader io.Reader) (resp vulnsrc.UpdateResponse, err error) {
+	var data debianData
+	err = json.NewDecoder(jsonReader).Decode(&data)
+	if err != nil {
+		return resp, commonerr.ErrCouldNotParse
+	}
+
+	mvulnerabilities := make(map[string]*database.VulnerabilityWithAffected)
+	unknownReleases := make(map[string]bool)
+	for pkgName, pkgNode := range data {
+		for vulnName, vulnNode := range pkgNode {
+			for releaseName, releaseNode := range vulnNode.Releases {
+				// Attempt to detect the release number.
+				releaseVersion, ok := debianReleases[releaseName]
+				if !ok {
+					unknownReleases[releaseName] = true
+					continue
+				}
+
+				// Skip temporary vulnerabilities, and any that are not
+				// fixed, as packages are reported by comparing them
+				// against the fixed version.
+				if !strings.HasPrefix(vulnName, "CVE-") || releaseNode.Status != "resolved" {
+					continue
+				}
+
+				// A fixed version of 0 means the package was never
+				// affected in this release.
+				if releaseNode.FixedVersion == "0" {
+					continue
+				}
+				err := versionfmt.Valid(dpkg.ParserName, releaseNode.FixedVersion)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "could not parse package version %v. skipping\n",
+						releaseNode.FixedVersion)
+					continue
+				}
+
+				// Get or create the vulnerability.
+				vulnerability, ok := mvulnerabilities[vulnName]
+				if !ok {
+					vulnerability = &database.VulnerabilityWithAffected{
+						Vulnerability: database.Vulnerability{
+							Name:        vulnName,
+							Link:        debianCVEURIPrefix + vulnName,
+							Severity:    database.UnknownSeverity,
+							Description: vulnNode.Description,
+						},
+					}
+					mvulnerabilities[vulnName] = vulnerability
+				}
+
+				// In the JSON, a vulnerability has one urgency per package
+				// it affects, and the highest urgency is the one set.
+				severity := debian.SeverityFromUrgency(releaseNode.Urgency)
+				if severity.Compare(vulnerability.Severity) > 0 {
+					vulnerability.Severity = severity
+				}
+
+				vulnerability.Affected = append(vulnerability.Affected, database.AffectedFeature{
+					FeatureName:     pkgName,
+					AffectedVersion: releaseNode.FixedVersion,
+					FixedInVersion:  releaseNode.FixedVersion,
+					Namespace: database.Namespace{
+						Name:          "debian:" + releaseVersion,
+						VersionFormat: dpkg.ParserName,
+					},
+				})
+			}
+		}
+	}
+
+	// Convert the vulnerabilities map to a slice, sorted so the cache is the
+	// same for the same data
+	for _, v := range mvulnerabilities {
+		sort.Slice(v.Affected, func(i, j int) bool {
+			if v.Affected[i].Namespace.Name != v.Affected[j].Namespace.Name {
+				return v.Affected[i].Namespace.Name < v.Affected[j].Namespace.Name
+			}
+			return v.Affected[i].FeatureName < v.Affected[j].FeatureName
+		})
+		resp.Vulnerabilities = append(resp.Vulnerabilities, *v)
+	}
+	sort.Slice(resp.Vulnerabilities, func(i, j int) bool {
+		return resp.Vulnerabilities[i].Name < resp.Vulnerabilities[j].Name
+	})
+	for k := range unknownReleases {
+		resp.Notes = append(resp.Notes, fmt.Sprintf("Debian %v is not mapped to a version number", k))
+	}
+	sort.Strings(resp.Notes)
+	resp.FlagName = debianUpdaterFlag
+	return resp, nil
+}
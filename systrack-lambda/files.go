@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/coreos/clair/database"
+)
+
+// findingSetuid is the name of the finding reported for a setuid or setgid
+// file that no package installed
+const findingSetuid = "unpackaged-setuid"
+
+// loadFileConfig reads the hashes of unpackaged setuid and setgid files that
+// are expected, such as agents installed from a tarball, from the environment
+func loadFileConfig() {
+	cfg.setuidHashes = make(map[string]bool)
+	for _, h := range strings.Split(os.Getenv("SETUID_ALLOWLIST"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			cfg.setuidHashes[h] = true
+		}
+	}
+}
+
+// checkFile checks a file audit record, returning an output line for a setuid
+// or setgid file that does not belong to a package and whose hash is not in
+// the allowlist. World-writable files are only inventoried.
+func checkFile(p pkgLogEnt) (ret []string) {
+	if p.Fields.RecType != "file" || p.Fields.Packaged {
+		return
+	}
+	if !p.Fields.Setuid && !p.Fields.Setgid {
+		return
+	}
+	if cfg.setuidHashes[p.Fields.SHA256] {
+		return
+	}
+	log.Printf("%v %v on %v\n", findingSetuid, p.Fields.Path, p.Hostname)
+	p.Fields.setDefaults()
+	p.Fields.PkgArch, p.Fields.PkgName, p.Fields.PkgVersion = "none", "none", "none"
+	var v database.VulnerabilityWithAffected
+	v.Name, v.Severity = findingSetuid, database.HighSeverity
+	return []string{p.toLogEntry(v)}
+}
@@ -3,13 +3,12 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net"
 	"os"
-	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,7 +17,6 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/firehose"
 	"github.com/coreos/clair/database"
-	"github.com/coreos/clair/ext/vulnsrc"
 	"github.com/mozilla/scribe"
 )
 
@@ -29,6 +27,14 @@ type pkgLogEnt struct {
 	Timestamp int64           `json:"Timestamp"`
 	Time      time.Time       `json:"Time"`
 	Fields    pkgLogEntFields `json:"Fields"`
+
+	// SigStatus is the result of verifying the record signature, or empty if
+	// signatures are not checked
+	SigStatus string `json:"-"`
+
+	// Sockets lists the sockets of a socket record merged with the other
+	// socket records for the same package
+	Sockets []string `json:"-"`
 }
 
 func (p *pkgLogEnt) validate() error {
@@ -38,6 +44,32 @@ func (p *pkgLogEnt) validate() error {
 	return p.Fields.validate()
 }
 
+// expand returns the package entries described by p. A snapshot record is
+// converted into one entry per package it contains, and a change record for a
+// removed package has no entries. Any other record is returned as is.
+func (p *pkgLogEnt) expand() []pkgLogEnt {
+	if p.Fields.RecType == "change" && p.Fields.ChangeType == "removed" {
+		return nil
+	}
+	if p.Fields.RecType != "snapshot" {
+		return []pkgLogEnt{*p}
+	}
+	ret := make([]pkgLogEnt, 0, len(p.Fields.Packages))
+	for _, x := range p.Fields.Packages {
+		n := *p
+		n.Fields.RecType = "package"
+		n.Fields.Packages = nil
+		n.Fields.PkgName = x.Name
+		n.Fields.PkgType = x.Type
+		n.Fields.PkgVersion = x.Version
+		n.Fields.PkgArch = x.Arch
+		n.Fields.PkgSource = x.Source
+		n.Fields.PkgSourceVersion = x.SourceVersion
+		ret = append(ret, n)
+	}
+	return ret
+}
+
 // toLogEntry converts a pkgLogEnt that has been identified as vulnerable into the line format
 // that will we push to firehose for storage in s3. v represents the vulnerability data applicable
 // to the package.
@@ -53,10 +85,82 @@ func (p *pkgLogEnt) toLogEntry(v database.VulnerabilityWithAffected) string {
 			appname = e[1]
 		}
 	}
-	return fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v",
+	// Packages installed on the host itself are not part of a container image
+	image, digest := "none", "none"
+	if p.Fields.Image != "" || p.Fields.ImageDigest != "" {
+		image, digest = p.Fields.Image, p.Fields.ImageDigest
+	}
+	sigstatus := p.SigStatus
+	if sigstatus == "" {
+		sigstatus = "unchecked"
+	}
+	// Findings for a package with a process listening on a socket include the
+	// socket, and are more severe if it is reachable from other hosts.
+	// Findings for a process still running an upgraded package include the
+	// process.
+	detail, severity, version := "none", v.Severity, p.Fields.PkgVersion
+	switch p.Fields.RecType {
+	case "socket":
+		detail = p.socket()
+		if len(p.Sockets) > 0 {
+			detail = strings.Join(p.Sockets, ", ")
+		}
+		if p.Fields.Exposed {
+			severity = raiseSeverity(severity)
+		}
+	case "restart":
+		detail = fmt.Sprintf("restart %v (%v)", p.Fields.Process, p.Fields.PID)
+		if p.Fields.RunningVersion != "" {
+			version = p.Fields.RunningVersion
+		}
+	case "account":
+		detail = fmt.Sprintf("account %v uid %v", p.Fields.Username, p.Fields.UID)
+	case "sshkey":
+		detail = fmt.Sprintf("sshkey %v %v", p.Fields.Username, p.Fields.Fingerprint)
+		if p.Fields.Interactive != nil && !*p.Fields.Interactive {
+			detail += " noninteractive"
+		}
+	case "file":
+		detail = fmt.Sprintf("file %v %v %v", p.Fields.Path, p.Fields.Mode, p.Fields.SHA256)
+	case "policy":
+		detail = fmt.Sprintf("policy %v %v", p.Fields.Policy, p.Fields.TestName)
+	case "service":
+		detail = fmt.Sprintf("service %v %v", p.Fields.Service, p.Fields.Enabled)
+		if p.Fields.Active != "" {
+			detail += " " + p.Fields.Active
+		}
+	}
+	cols := []string{
 		p.Time.Format("2006-01-02 15:04:05"), p.Hostname, p.Fields.InstanceID, p.Fields.InstanceType,
-		p.Fields.AMI, p.Fields.PkgArch, p.Fields.PkgName, p.Fields.PkgVersion,
-		v.Name, v.Severity, appname)
+		p.Fields.AMI, p.Fields.PkgArch, p.Fields.PkgName, version,
+		v.Name, string(severity), appname, image, digest, sigstatus, detail,
+	}
+	if cfg.outputFormat == 1 {
+		// The original format ends with the app name
+		cols = cols[:11]
+	}
+	return strings.Join(cols, "\t")
+}
+
+// socket returns the socket described by a socket record
+func (p *pkgLogEnt) socket() string {
+	return fmt.Sprintf("%v %v", p.Fields.Proto,
+		net.JoinHostPort(p.Fields.Address, strconv.Itoa(p.Fields.Port)))
+}
+
+// raiseSeverity returns the severity one level above s. Unknown and negligible
+// severities are left as they are, and a finding is never raised beyond
+// critical, as the level above is assigned by hand.
+func raiseSeverity(s database.Severity) database.Severity {
+	switch s {
+	case database.LowSeverity:
+		return database.MediumSeverity
+	case database.MediumSeverity:
+		return database.HighSeverity
+	case database.HighSeverity:
+		return database.CriticalSeverity
+	}
+	return s
 }
 
 // pkgLogEntFields includes the fields within the log structure we need for
@@ -70,7 +174,116 @@ type pkgLogEntFields struct {
 	InstanceTags []string `json:"instancetags"`
 	PkgArch      string   `json:"pkgarch"`
 	PkgName      string   `json:"pkgname"`
+	PkgType      string   `json:"pkgtype"`
 	PkgVersion   string   `json:"pkgversion"`
+
+	// The source package a dpkg package, or the origin an apk package, was
+	// built from, and its version if it differs from the package version.
+	// The Debian and Ubuntu trackers and the Alpine secdb list
+	// vulnerabilities by source package.
+	PkgSource        string `json:"pkgsource"`
+	PkgSourceVersion string `json:"pkgsourceversion"`
+
+	// Fields present in snapshot records, which include the complete package
+	// list for a host rather than a single package
+	RecType    string        `json:"rectype"`
+	SnapshotID string        `json:"snapshotid"`
+	Chunk      int           `json:"chunk"`
+	Chunks     int           `json:"chunks"`
+	Packages   []snapshotPkg `json:"packages"`
+
+	// Set in change records sent by systrack when reporting differences
+	// from the previously reported inventory
+	ChangeType string `json:"changetype"`
+	OldVersion string `json:"oldversion"`
+
+	// Set on records describing the packages in the image of running
+	// containers
+	Image       string `json:"image"`
+	ImageDigest string `json:"imagedigest"`
+
+	// The running kernel release, set on all records from hosts that report
+	// their running kernel in a separate kernel record
+	Kernel string `json:"kernel"`
+
+	// The key a signed record was signed with, and when it was signed
+	SigKeyID string `json:"sigkeyid"`
+	SigTime  int64  `json:"sigtime"`
+
+	// Set on socket records, which describe a listening socket and the
+	// package owning the process listening on it
+	Proto   string `json:"proto"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Exposed bool   `json:"exposed"`
+
+	// Set on restart records, which describe a process still using the
+	// files of a package version that has since been upgraded.
+	// RunningVersion is the version the process is using, if known.
+	PID            int    `json:"pid"`
+	Process        string `json:"process"`
+	RunningVersion string `json:"runningversion"`
+
+	// Set on account and sshkey records, which describe local accounts and
+	// the SSH keys authorized to log in to them
+	Username    string `json:"username"`
+	UID         int    `json:"uid"`
+	Fingerprint string `json:"fingerprint"`
+
+	// Set on sshkey records to false if the account has no login shell.
+	// Older versions of systrack do not set it, and only report keys of
+	// accounts with a login shell.
+	Interactive *bool `json:"interactive"`
+
+	// Set on service records, which describe a systemd unit or SysV init
+	// script
+	Service string `json:"service"`
+	Enabled string `json:"enabled"`
+	Active  string `json:"active"`
+
+	// Set on file records, which describe a setuid, setgid or world-writable
+	// file found by the file audit
+	Path     string `json:"path"`
+	Mode     string `json:"mode"`
+	SHA256   string `json:"sha256"`
+	Setuid   bool   `json:"setuid"`
+	Setgid   bool   `json:"setgid"`
+	Packaged bool   `json:"packaged"`
+
+	// Set on policy records, which give the result of a test in a scribe
+	// policy document
+	Policy   string            `json:"policy"`
+	TestID   string            `json:"testid"`
+	TestName string            `json:"testname"`
+	TestTags map[string]string `json:"testtags"`
+	Result   string            `json:"result"`
+	Error    string            `json:"error"`
+}
+
+// langPkgTypes are the package types systrack uses for language ecosystem
+// packages, mapped to the name of the ecosystem advisories are published for
+var langPkgTypes = map[string]string{
+	"gem": "RubyGems",
+	"go":  "Go",
+	"npm": "npm",
+	"pip": "PyPI",
+}
+
+// kernelPkgs are the names of packages that provide a bootable kernel
+var kernelPkgs = map[string]bool{
+	"kernel":      true,
+	"kernel-core": true,
+	"kernel-uek":  true,
+}
+
+// snapshotPkg describes a package in a snapshot record
+type snapshotPkg struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Type          string `json:"type"`
+	Arch          string `json:"arch"`
+	Source        string `json:"source"`
+	SourceVersion string `json:"sourceversion"`
 }
 
 func (p *pkgLogEntFields) validate() error {
@@ -86,6 +299,13 @@ func (p *pkgLogEntFields) validate() error {
 	if p.Dist == "" {
 		return errors.New("package entry had no distribution identifier")
 	}
+	p.setDefaults()
+	return nil
+}
+
+// setDefaults fills in the host fields that are missing, as hosts outside of a
+// cloud provider do not have them
+func (p *pkgLogEntFields) setDefaults() {
 	if p.FQDN == "" {
 		p.FQDN = "unknown"
 	}
@@ -98,7 +318,6 @@ func (p *pkgLogEntFields) validate() error {
 	if p.InstanceType == "" {
 		p.InstanceType = "unknown"
 	}
-	return nil
 }
 
 type config struct {
@@ -106,12 +325,35 @@ type config struct {
 	inputSample  string // If set, read and process an input sample from path
 	makeCache    bool   // If true, cache will be generated
 	outputStream string // Kinesis Firehose output stream
+	outputFormat int    // Version of the output line format
+
+	sigPolicy string               // How unsigned or invalid records are handled
+	sigKeys   map[string]verifyKey // Signature verification keys by key id
+	sigMaxAge time.Duration        // Age after which signed records are refused
+
+	uid0Accounts map[string]bool // Accounts allowed to have UID 0
+	sshKeys      map[string]bool // Allowed SSH key fingerprints, if checked
+
+	deniedServices []string        // Patterns of service names that should not run
+	setuidHashes   map[string]bool // Hashes of expected unpackaged setuid files
 
-	rhelData vulnsrc.UpdateResponse
+	vulnSources map[string]bool                      // Sources that must be in the cache, if not all
+	vulns       []database.VulnerabilityWithAffected // Advisories from every source
+	vulnIndex   vulnIndex                            // vulns indexed by namespace and package
 }
 
 var cfg config
 
+// maxOutputFormat is the latest version of the output line format. Version 1
+// is the original format, and version 2 adds the image, image digest,
+// signature status and detail columns. Any change to the columns needs a new
+// version, as the output is read by position.
+const maxOutputFormat = 2
+
+// maxRecordSize is the largest input record we will read in sample mode, which
+// matches the Kinesis record size limit
+const maxRecordSize = 1024 * 1024
+
 func kinesisWrite(dmap []string) error {
 	log.Printf("attempting to write %v records to firehose\n", len(dmap))
 	sess := session.Must(session.NewSession())
@@ -145,59 +387,228 @@ func kinesisWrite(dmap []string) error {
 	return nil
 }
 
-func checkVuln(p pkgLogEnt) (ret []string, err error) {
-	err = p.validate()
+// checkVuln checks the package described by p against the advisories for its
+// dist, returning output lines for any that it is affected by. A version that
+// cannot be compared with an advisory is logged and the package skipped, so
+// one bad record does not fail the others in its batch.
+func checkVuln(p pkgLogEnt) (ret []string) {
+	if eco, ok := langPkgTypes[p.Fields.PkgType]; ok {
+		return checkLangVuln(p, eco)
+	}
+	if p.Fields.RecType == "socket" && p.Fields.PkgName == "" {
+		// Sockets of processes not installed by a package, or that
+		// systrack could not inspect, have nothing to check
+		return ret
+	}
+	err := p.validate()
 	if err != nil {
 		// Don't treat as fatal but log it
 		log.Printf("%v\n", err)
-		return ret, nil
+		return ret
 	}
-	// Only centos:6 and centos:7 for now
-	if !strings.HasPrefix(p.Fields.Dist, "centos:") {
+	ns, ok := vulnNamespace(p.Fields.Dist)
+	if !ok {
 		log.Printf("skipping unsupported dist %v for %v\n", p.Fields.Dist, p.Hostname)
-		return ret, nil
+		return ret
+	}
+	if p.Fields.Kernel != "" && p.Fields.RecType != "kernel" && kernelPkgs[p.Fields.PkgName] {
+		// An installed kernel package is only exploitable if it is running, and
+		// the running kernel is checked using the kernel record for the host
+		return ret
 	}
 	log.Printf("check %v on %v (%v)\n", p.Fields.PkgName, p.Hostname, p.Fields.PkgVersion)
-	for _, v := range cfg.rhelData.Vulnerabilities {
-		for _, w := range v.Affected {
-			if p.Fields.Dist != w.Namespace.Name {
-				continue
-			}
-			if w.FeatureName != p.Fields.PkgName {
+	// Findings are reported for the binary package, even if the advisory is
+	// for its source
+	name, src := p.sourcePackage()
+	for _, e := range cfg.vulnIndex.lookup(ns, name) {
+		f, err := affected(src, e)
+		if err != nil {
+			log.Printf("skipping %v %v on %v: %v\n", p.Fields.PkgName, p.Fields.PkgVersion,
+				p.Hostname, err)
+			return nil
+		}
+		if f {
+			ret = append(ret, p.toLogEntry(*e.vuln))
+		}
+	}
+	return ret
+}
+
+// sourcePackage returns the name of the source package the package described
+// by p was built from, and a copy of p with the version of the source, as
+// advisories listed by source package are compared against it. Packages with
+// no source recorded are their own source.
+func (p pkgLogEnt) sourcePackage() (string, pkgLogEnt) {
+	name := p.Fields.PkgName
+	if p.Fields.PkgSource != "" {
+		name = p.Fields.PkgSource
+	}
+	if p.Fields.PkgSourceVersion != "" {
+		p.Fields.PkgVersion = p.Fields.PkgSourceVersion
+	}
+	return name, p
+}
+
+// checkLangVuln checks a language package against the OSV advisories of its
+// ecosystem eco. These packages are installed independently of the
+// distribution, so have no architecture and are checked whatever the dist.
+func checkLangVuln(p pkgLogEnt, eco string) (ret []string) {
+	if p.Hostname == "" || p.Fields.PkgName == "" || p.Fields.PkgVersion == "" {
+		log.Printf("%v package entry had no hostname, package name or version\n", eco)
+		return ret
+	}
+	p.Fields.setDefaults()
+	name := osvPackageName(eco, p.Fields.PkgName)
+	for _, e := range cfg.vulnIndex.lookup(osvNamespace(eco), name) {
+		f, err := affected(p, e)
+		if err != nil {
+			log.Printf("skipping %v %v on %v: %v\n", p.Fields.PkgName, p.Fields.PkgVersion,
+				p.Hostname, err)
+			return nil
+		}
+		if f {
+			ret = append(ret, p.toLogEntry(*e.vuln))
+		}
+	}
+	return ret
+}
+
+// affected returns true if the package described by p is affected by the
+// vulnerability in e, comparing versions in the version format of the
+// advisory. Versions before the first affected version of e, if it has one,
+// are not affected.
+//
+// For a restart record the installed package is not checked, as a package
+// record covers it, but the version the process is still running. Only
+// vulnerabilities the installed version fixes are reported, so they are not
+// reported twice. If the running version is not known, the vulnerabilities
+// fixed by the installed version itself are reported, as the process started
+// before the upgrade that fixed them.
+func affected(p pkgLogEnt, e vulnEntry) (bool, error) {
+	format, fixed := e.format, e.fixed
+	if e.introduced != "" {
+		version := p.Fields.PkgVersion
+		if p.Fields.RecType == "restart" && p.Fields.RunningVersion != "" {
+			version = p.Fields.RunningVersion
+		}
+		f, err := testVersion(format, scribe.EvropGreaterThan, e.introduced, version)
+		if err != nil || f {
+			return false, err
+		}
+	}
+	if p.Fields.RecType != "restart" {
+		return testVersion(format, scribe.EvropGreaterThan, fixed, p.Fields.PkgVersion)
+	}
+	if p.Fields.RunningVersion == "" {
+		return testVersion(format, scribe.EvropEquals, fixed, p.Fields.PkgVersion)
+	}
+	f, err := testVersion(format, scribe.EvropGreaterThan, fixed, p.Fields.PkgVersion)
+	if err != nil || f {
+		return false, err
+	}
+	return testVersion(format, scribe.EvropGreaterThan, fixed, p.Fields.RunningVersion)
+}
+
+// rhelNamespace returns the namespace in the RHEL advisory data that applies to
+// dist. RHEL and its rebuilds share the same advisories, which are stored under
+// centos:N.
+func rhelNamespace(dist string) (string, bool) {
+	for _, prefix := range []string{"centos:", "rhel:", "rocky:", "alma:"} {
+		if strings.HasPrefix(dist, prefix) {
+			return "centos:" + strings.TrimPrefix(dist, prefix), true
+		}
+	}
+	return "", false
+}
+
+// listenKey returns the key identifying the host package described by p, used
+// to match socket records with package records
+func listenKey(p pkgLogEnt) string {
+	f := p.Fields
+	return strings.Join([]string{p.Hostname, f.PkgType, f.PkgName, f.PkgVersion, f.PkgArch}, "|")
+}
+
+// checkRecords checks the records recs, returning output lines for any that
+// are vulnerable.
+//
+// A package with processes listening on sockets is reported in a package
+// record and in a socket record for each socket, and would have the same
+// findings reported for each. The socket records for a package are merged, so
+// its findings are reported once with every socket, and its package records in
+// recs are not checked, as the socket findings replace theirs.
+func checkRecords(recs []pkgLogEnt) (ret []string) {
+	var merged []pkgLogEnt
+	listening := make(map[string]int)
+	for _, p := range recs {
+		if p.Fields.RecType != "socket" || p.Fields.PkgName == "" {
+			merged = append(merged, p)
+			continue
+		}
+		k := listenKey(p)
+		i, ok := listening[k]
+		if !ok {
+			p.Sockets = []string{p.socket()}
+			listening[k] = len(merged)
+			merged = append(merged, p)
+			continue
+		}
+		m := &merged[i]
+		m.Sockets = append(m.Sockets, p.socket())
+		m.Fields.Exposed = m.Fields.Exposed || p.Fields.Exposed
+	}
+	for _, p := range merged {
+		ret = append(ret, checkRecord(p, listening)...)
+	}
+	return ret
+}
+
+// checkRecord checks each package described by the record p for
+// vulnerabilities, returning output lines for any that are vulnerable.
+// Packages of the host in listening are skipped, as their socket records are
+// checked instead.
+func checkRecord(p pkgLogEnt, listening map[string]int) (ret []string) {
+	if accountRecTypes[p.Fields.RecType] {
+		return checkAccount(p)
+	}
+	switch p.Fields.RecType {
+	case "service":
+		return checkService(p)
+	case "file", "fileaudit":
+		return checkFile(p)
+	case "policy":
+		return checkPolicy(p)
+	}
+	if p.Fields.RecType == "snapshot" {
+		log.Printf("snapshot %v chunk %v/%v from %v with %v packages\n", p.Fields.SnapshotID,
+			p.Fields.Chunk+1, p.Fields.Chunks, p.Hostname, len(p.Fields.Packages))
+	}
+	for _, x := range p.expand() {
+		if x.Fields.RecType == "package" || x.Fields.RecType == "change" {
+			if _, ok := listening[listenKey(x)]; ok && x.Fields.Image == "" {
 				continue
 			}
-			f, err := scribe.TestEvrCompare(scribe.EvropGreaterThan,
-				w.FixedInVersion, p.Fields.PkgVersion)
-			if err != nil {
-				return ret, err
-			}
-			if f {
-				ret = append(ret, p.toLogEntry(v))
-			}
 		}
+		ret = append(ret, checkVuln(x)...)
 	}
-	return ret, nil
+	return ret
 }
 
 func handler(ctx context.Context, kinesisEvent events.KinesisEvent) error {
 	log.Printf("handler executing for %v records\n", len(kinesisEvent.Records))
-	var obuf []string
+	var recs []pkgLogEnt
 	for _, r := range kinesisEvent.Records {
-		var p pkgLogEnt
-		err := json.Unmarshal(r.Kinesis.Data, &p)
+		p, ok, err := parseRecord(r.Kinesis.Data)
 		if err != nil {
 			// Don't treat this as fatal but log it
 			log.Printf("%v\n", err)
 			continue
 		}
-		s, err := checkVuln(p)
-		if err != nil {
-			return err
-		}
-		if len(s) > 0 {
-			obuf = append(obuf, s...)
+		if !ok {
+			continue
 		}
+		recs = append(recs, p)
 	}
+	obuf := checkRecords(recs)
 	if len(obuf) > 0 {
 		err := kinesisWrite(obuf)
 		if err != nil {
@@ -214,31 +625,49 @@ func main() {
 	}
 	cfg.inputSample = os.Getenv("INPUTSAMPLE")
 	cfg.outputStream = os.Getenv("OUTPUTSTREAM")
+	cfg.outputFormat = 1
+	if x := os.Getenv("OUTPUT_FORMAT"); x != "" {
+		n, err := strconv.Atoi(x)
+		if err != nil || n < 1 || n > maxOutputFormat {
+			log.Fatalf("OUTPUT_FORMAT must be 1 or 2, not %q\n", x)
+		}
+		cfg.outputFormat = n
+	}
+	err := loadSigningConfig()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	err = loadAccountConfig()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	err = loadServiceConfig()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	loadFileConfig()
+	err = loadSourceConfig()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
 	if os.Getenv("MAKECACHE") != "" {
 		// Cache mode, cache vulnerability data in the cache directory
 		// and just exit
-		err := cacheRHEL()
+		err := cacheVulns()
 		if err != nil {
 			log.Fatalf("%v\n", err)
 		}
 		os.Exit(0)
 	}
-	buf, err := ioutil.ReadFile(path.Join(cfg.cacheDir, "rheldata"))
-	if err != nil {
-		log.Fatalf("%v\n", err)
-	}
-	err = json.Unmarshal(buf, &cfg.rhelData)
+	cfg.vulns, err = loadVulns()
 	if err != nil {
 		log.Fatalf("%v\n", err)
 	}
+	cfg.vulnIndex = newVulnIndex(cfg.vulns)
 
 	if cfg.inputSample != "" {
 		// If in sample mode, just compare the sample data set against vulnerability
 		// data in the cache
-		var (
-			le     pkgLogEnt
-			outbuf []string
-		)
 		// Load a sample file, which should be JSON mozlog entries with package
 		// information, one log line per entry
 		fd, err := os.Open(cfg.inputSample)
@@ -247,24 +676,23 @@ func main() {
 		}
 		defer fd.Close()
 		scn := bufio.NewScanner(fd)
+		// Snapshot records can be much larger than the default scanner limit
+		scn.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+		var recs []pkgLogEnt
 		for scn.Scan() {
-			buf := scn.Text()
-			err = json.Unmarshal([]byte(buf), &le)
+			le, ok, err := parseRecord(scn.Bytes())
 			if err != nil {
 				log.Fatalf("%v\n", err)
 			}
-			lns, err := checkVuln(le)
-			if err != nil {
-				log.Fatalf("%v\n", err)
-			}
-			if len(lns) > 0 {
-				outbuf = append(outbuf, lns...)
+			if !ok {
+				continue
 			}
+			recs = append(recs, le)
 		}
 		if scn.Err() != nil {
 			log.Fatalf("%v\n", scn.Err())
 		}
-		for _, x := range outbuf {
+		for _, x := range checkRecords(recs) {
 			log.Printf("%v\n", x)
 		}
 	} else {
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/versionfmt/dpkg"
+)
+
+func TestToLogEntryFormat(t *testing.T) {
+	saved := cfg.outputFormat
+	defer func() { cfg.outputFormat = saved }()
+
+	p := pkgLogEnt{
+		Hostname: "host1",
+		Time:     time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+	}
+	p.Fields.InstanceID = "i-0123"
+	p.Fields.InstanceType = "t3.small"
+	p.Fields.AMI = "ami-0456"
+	p.Fields.InstanceTags = []string{"Name=web", "App=shop"}
+	p.Fields.PkgArch = "x86_64"
+	p.Fields.PkgName = "openssh-server"
+	p.Fields.PkgVersion = "8.7p1-34.el9"
+	p.Fields.RecType = "socket"
+	p.Fields.Proto = "tcp"
+	p.Fields.Address = "0.0.0.0"
+	p.Fields.Port = 22
+	v := database.VulnerabilityWithAffected{}
+	v.Name = "RHSA-2024:0001"
+	v.Severity = database.LowSeverity
+
+	base := []string{"2024-03-01 12:30:00", "host1", "i-0123", "t3.small", "ami-0456",
+		"x86_64", "openssh-server", "8.7p1-34.el9", "RHSA-2024:0001", "Low", "shop"}
+	tests := []struct {
+		format int
+		want   []string
+	}{
+		{1, base},
+		{2, append(append([]string{}, base...), "none", "none", "unchecked", "tcp 0.0.0.0:22")},
+	}
+	for _, tc := range tests {
+		cfg.outputFormat = tc.format
+		got := strings.Split(p.toLogEntry(v), "\t")
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("format %v: got %q, want %q", tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestCheckRecordsMergesSockets(t *testing.T) {
+	savedIndex, savedFormat := cfg.vulnIndex, cfg.outputFormat
+	defer func() { cfg.vulnIndex, cfg.outputFormat = savedIndex, savedFormat }()
+	v := database.VulnerabilityWithAffected{}
+	v.Name = "DSA-0001-1"
+	v.Severity = database.MediumSeverity
+	v.Affected = []database.AffectedFeature{{
+		Namespace:       database.Namespace{Name: "debian:12", VersionFormat: dpkg.ParserName},
+		FeatureName:     "openssh-server",
+		AffectedVersion: "1:9.2p1-2+deb12u3",
+		FixedInVersion:  "1:9.2p1-2+deb12u3",
+	}}
+	cfg.vulnIndex = newVulnIndex([]database.VulnerabilityWithAffected{v})
+	cfg.outputFormat = 2
+
+	rec := func(rectype, proto, addr string, exposed bool) pkgLogEnt {
+		p := pkgLogEnt{Hostname: "host1"}
+		p.Fields.Dist = "debian:12"
+		p.Fields.RecType = rectype
+		p.Fields.PkgType = "dpkg"
+		p.Fields.PkgName = "openssh-server"
+		p.Fields.PkgVersion = "1:9.2p1-2+deb12u2"
+		p.Fields.PkgArch = "amd64"
+		p.Fields.Proto = proto
+		p.Fields.Address = addr
+		p.Fields.Port = 22
+		p.Fields.Exposed = exposed
+		return p
+	}
+	recs := []pkgLogEnt{
+		rec("package", "", "", false),
+		rec("socket", "tcp", "127.0.0.1", false),
+		rec("socket", "tcp6", "::", true),
+	}
+	lines := checkRecords(recs)
+	// One finding with both sockets, raised as one socket is exposed
+	if len(lines) != 1 {
+		t.Fatalf("got %q, want one line", lines)
+	}
+	cols := strings.Split(lines[0], "\t")
+	if cols[9] != "High" || cols[14] != "tcp 127.0.0.1:22, tcp6 [::]:22" {
+		t.Errorf("got %q", lines[0])
+	}
+
+	// Without socket records the package record is reported
+	lines = checkRecords(recs[:1])
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], "\tnone") {
+		t.Errorf("got %q, want the package finding", lines)
+	}
+}
+
+func TestCheckVulnSource(t *testing.T) {
+	saved := cfg.vulnIndex
+	defer func() { cfg.vulnIndex = saved }()
+	dsa := database.VulnerabilityWithAffected{}
+	dsa.Name = "DSA-5764-1"
+	dsa.Affected = []database.AffectedFeature{{
+		Namespace:       database.Namespace{Name: "debian:12", VersionFormat: dpkg.ParserName},
+		FeatureName:     "openssl",
+		AffectedVersion: "3.0.14-1~deb12u2",
+		FixedInVersion:  "3.0.14-1~deb12u2",
+	}}
+	secdb := database.VulnerabilityWithAffected{}
+	secdb.Name = "CVE-2024-0727"
+	secdb.Affected = []database.AffectedFeature{{
+		Namespace:       database.Namespace{Name: "alpine:3.18", VersionFormat: apkVersionFormat},
+		FeatureName:     "openssl",
+		AffectedVersion: "3.1.4-r5",
+		FixedInVersion:  "3.1.4-r5",
+	}}
+	cfg.vulnIndex = newVulnIndex([]database.VulnerabilityWithAffected{dsa, secdb})
+
+	tests := []struct {
+		dist, pkgtype                        string
+		name, version, source, sourceVersion string
+		want                                 int
+	}{
+		{"debian:12", "dpkg", "libssl3", "3.0.11-1~deb12u2", "openssl", "", 1},
+		{"debian:12", "dpkg", "libssl3", "3.0.14-1~deb12u2", "openssl", "", 0},
+		// Without its source the binary package has no advisories
+		{"debian:12", "dpkg", "libssl3", "3.0.11-1~deb12u2", "", "", 0},
+		// A binary-only rebuild is compared using the version of its source
+		{"debian:12", "dpkg", "libssl3", "3.0.14-1~deb12u2+b1", "openssl", "3.0.11-1~deb12u2", 1},
+		// A version that cannot be compared skips the package
+		{"debian:12", "dpkg", "libssl3", "3.0.11:1", "openssl", "", 0},
+		// apk packages are matched by their origin
+		{"alpine:3.18", "apk", "libcrypto3", "3.1.4-r4", "openssl", "", 1},
+		{"alpine:3.18", "apk", "libcrypto3", "3.1.4-r5", "openssl", "", 0},
+		{"alpine:3.18", "apk", "libcrypto3", "3.1.4-r4", "", "", 0},
+	}
+	for _, tc := range tests {
+		p := pkgLogEnt{Hostname: "host1"}
+		p.Fields.Dist = tc.dist
+		p.Fields.RecType = "package"
+		p.Fields.PkgType = tc.pkgtype
+		p.Fields.PkgName = tc.name
+		p.Fields.PkgVersion = tc.version
+		p.Fields.PkgArch = "amd64"
+		p.Fields.PkgSource = tc.source
+		p.Fields.PkgSourceVersion = tc.sourceVersion
+		lines := checkVuln(p)
+		if len(lines) != tc.want {
+			t.Errorf("%v %v source %v %v: got %q", tc.name, tc.version, tc.source,
+				tc.sourceVersion, lines)
+			continue
+		}
+		// Findings name the binary package
+		for _, l := range lines {
+			cols := strings.Split(l, "\t")
+			if cols[6] != tc.name || cols[7] != tc.version {
+				t.Errorf("got %q", l)
+			}
+		}
+	}
+}
+
+func TestCheckAccountNonInteractive(t *testing.T) {
+	saved, savedFormat := cfg.sshKeys, cfg.outputFormat
+	defer func() { cfg.sshKeys, cfg.outputFormat = saved, savedFormat }()
+	cfg.sshKeys = map[string]bool{"SHA256:allowed": true}
+	cfg.outputFormat = 2
+
+	no := false
+	tests := []struct {
+		interactive *bool
+		want        string
+	}{
+		{nil, "sshkey git SHA256:other"},
+		{&no, "sshkey git SHA256:other noninteractive"},
+	}
+	for _, tc := range tests {
+		p := pkgLogEnt{Hostname: "host1"}
+		p.Fields.RecType = "sshkey"
+		p.Fields.Username = "git"
+		p.Fields.Fingerprint = "SHA256:other"
+		p.Fields.Interactive = tc.interactive
+		lines := checkAccount(p)
+		if len(lines) != 1 || !strings.HasSuffix(lines[0], "\t"+tc.want) {
+			t.Errorf("got %q, want detail %v", lines, tc.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 clair authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This is a slightly modified version of the Oracle Linux OVAL parser from the
+// clair project. ELSAs are read from the single file Oracle publishes with all
+// of them, and ksplice builds of packages are kept rather than ignored, so
+// hosts running them can be checked against the ksplice fixes.
+
+import (
+	"compress/bzip2"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/versionfmt"
+	"github.com/coreos/clair/ext/versionfmt/rpm"
+	"github.com/coreos/clair/ext/vulnsrc"
+	"github.com/coreos/clair/pkg/commonerr"
+)
+
+const (
+	// Before this, ELSAs only cover Oracle Linux 4 and earlier
+	firstConsideredOracle = 5
+
+	elsaURI           = "https://linux.oracle.com/security/oval/com.oracle.elsa-all.xml.bz2"
+	oracleUpdaterFlag = "oracleUpdater"
+
+	// oracleVersionFormat is the version format of Oracle namespaces. Versions
+	// are rpm versions, but ksplice builds are only compared with each other.
+	oracleVersionFormat = "oracle"
+)
+
+func fetchOracle() (resp vulnsrc.UpdateResponse, err error) {
+	r, err := http.Get(elsaURI)
+	if err != nil {
+		return resp, commonerr.ErrCouldNotDownload
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return resp, commonerr.ErrCouldNotDownload
+	}
+	resp.Vulnerabilities, err = parseELSA(bzip2.NewReader(r.Body))
+	if err != nil {
+		return resp, err
+	}
+	resp.FlagName = oracleUpdaterFlag
+	return resp, nil
+}
+
+func parseELSA(ovalReader io.Reader) (vulnerabilities []database.VulnerabilityWithAffected, err error) {
+	// Decode the XML.
+	var ov oval
+	err = xml.NewDecoder(ovalReader).Decode(&ov)
+	if err != nil {
+		err = commonerr.ErrCouldNotParse
+		return
+	}
+
+	// Iterate over the definitions and collect any vulnerabilities that affect
+	// at least one package.
+	for _, definition := range ov.Definitions {
+		pkgs := oracleFeatures(definition.Criteria)
+		if len(pkgs) > 0 {
+			vulnerability := database.VulnerabilityWithAffected{
+				Vulnerability: database.Vulnerability{
+					Name:        name(definition),
+					Link:        oracleLink(definition),
+					Severity:    oracleSeverity(definition),
+					Description: description(definition),
+				},
+			}
+			for _, p := range pkgs {
+				vulnerability.Affected = append(vulnerability.Affected, p)
+			}
+			vulnerabilities = append(vulnerabilities, vulnerability)
+		}
+	}
+
+	return
+}
+
+// isKsplice returns true if version is a ksplice build of a package, such as
+// 2:2.17-326.0.1.ksplice1.el7_9.2. Ksplice builds have their own version
+// stream, so are only compared with other ksplice builds.
+func isKsplice(version string) bool {
+	return strings.Contains(version, ".ksplice")
+}
+
+func oracleFeatures(criteria criteria) []database.AffectedFeature {
+	// There are duplicates in Oracle .xml files, such as for each
+	// architecture. This map is for deduplication.
+	featureVersionParameters := make(map[string]database.AffectedFeature)
+
+	// The criterions signing packages with the Oracle key and selecting the
+	// architecture are kept, but are neither installed nor earlier than
+	// tests, so have no effect
+	possibilities := getPossibilities(criteria)
+	for _, criterions := range possibilities {
+		var (
+			featureVersion database.AffectedFeature
+			osVersion      int
+			err            error
+		)
+
+		// Attempt to parse package data from trees of criterions.
+		for _, c := range criterions {
+			if strings.HasPrefix(c.Comment, "Oracle Linux ") && strings.HasSuffix(c.Comment, " is installed") {
+				const prefixLen = len("Oracle Linux ")
+				osVersion, err = strconv.Atoi(strings.TrimSpace(c.Comment[prefixLen : prefixLen+strings.Index(c.Comment[prefixLen:], " ")]))
+				if err != nil {
+					fmt.Fprint(os.Stderr, "could not parse Oracle Linux release version from criterion comment\n")
+				}
+			} else if strings.Contains(c.Comment, " is earlier than ") {
+				const prefixLen = len(" is earlier than ")
+				featureVersion.FeatureName = strings.TrimSpace(c.Comment[:strings.Index(c.Comment, " is earlier than ")])
+				version := c.Comment[strings.Index(c.Comment, " is earlier than ")+prefixLen:]
+				err := versionfmt.Valid(rpm.ParserName, version)
+				if err != nil {
+					fmt.Fprint(os.Stderr, "could not parse package version. skipping\n")
+				} else {
+					featureVersion.AffectedVersion = version
+					if version != versionfmt.MaxVersion {
+						featureVersion.FixedInVersion = version
+					}
+					featureVersion.Namespace.VersionFormat = oracleVersionFormat
+				}
+			}
+		}
+
+		if osVersion < firstConsideredOracle {
+			continue
+		}
+		featureVersion.Namespace.Name = "oracle" + ":" + strconv.Itoa(osVersion)
+
+		if featureVersion.FeatureName != "" && featureVersion.AffectedVersion != "" && featureVersion.FixedInVersion != "" {
+			key := featureVersion.Namespace.Name + ":" + featureVersion.FeatureName
+			if isKsplice(featureVersion.FixedInVersion) {
+				key += ":ksplice"
+			}
+			featureVersionParameters[key] = featureVersion
+		} else {
+			fmt.Fprint(os.Stderr, "could not determine a valid package from criterions\n")
+		}
+	}
+
+	// Convert the map to a slice, sorted so the cache is the same for the
+	// same data
+	var featureVersionParametersArray []database.AffectedFeature
+	for _, fv := range featureVersionParameters {
+		featureVersionParametersArray = append(featureVersionParametersArray, fv)
+	}
+	sort.Slice(featureVersionParametersArray, func(i, j int) bool {
+		a, b := featureVersionParametersArray[i], featureVersionParametersArray[j]
+		if a.Namespace.Name != b.Namespace.Name {
+			return a.Namespace.Name < b.Namespace.Name
+		}
+		if a.FeatureName != b.FeatureName {
+			return a.FeatureName < b.FeatureName
+		}
+		// A package and its ksplice build
+		return a.FixedInVersion < b.FixedInVersion
+	})
+
+	return featureVersionParametersArray
+}
+
+func oracleLink(def definition) (link string) {
+	for _, reference := range def.References {
+		if reference.Source == "elsa" {
+			link = reference.URI
+			break
+		}
+	}
+
+	return
+}
+
+func oracleSeverity(def definition) database.Severity {
+	switch strings.ToLower(strings.TrimSpace(def.Severity)) {
+	case "n/a":
+		return database.NegligibleSeverity
+	case "low":
+		return database.LowSeverity
+	case "moderate":
+		return database.MediumSeverity
+	case "important":
+		return database.HighSeverity
+	case "critical":
+		return database.CriticalSeverity
+	default:
+		fmt.Fprintf(os.Stderr, "could not determine vulnerability severity from: %s.\n", def.Severity)
+		return database.UnknownSeverity
+	}
+}
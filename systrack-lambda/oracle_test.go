@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testELSA is an ELSA fixing glibc and its ksplice build on two releases, with
+// the criteria for each architecture duplicated as in the published file
+const testELSA = `<oval_definitions>
+<definitions>
+<definition>
+<metadata>
+<title>ELSA-2024-0001:  glibc security update (IMPORTANT)</title>
+<reference source="elsa" ref_url="https://linux.oracle.com/errata/ELSA-2024-0001.html"/>
+<advisory><severity>IMPORTANT</severity></advisory>
+</metadata>
+<criteria operator="OR">
+<criteria operator="AND">
+<criterion comment="Oracle Linux 8 is installed"/>
+<criteria operator="OR">
+<criteria operator="AND">
+<criterion comment="Oracle Linux arch is x86_64"/>
+<criteria operator="OR">
+<criterion comment="nscd is earlier than 0:2.28-236.0.1.el8"/>
+<criterion comment="glibc is earlier than 0:2.28-236.0.1.el8"/>
+<criterion comment="glibc is earlier than 2:2.28-236.0.1.ksplice1.el8"/>
+</criteria>
+</criteria>
+<criteria operator="AND">
+<criterion comment="Oracle Linux arch is aarch64"/>
+<criteria operator="OR">
+<criterion comment="glibc is earlier than 2:2.28-236.0.1.ksplice1.el8"/>
+<criterion comment="glibc is earlier than 0:2.28-236.0.1.el8"/>
+<criterion comment="nscd is earlier than 0:2.28-236.0.1.el8"/>
+</criteria>
+</criteria>
+</criteria>
+</criteria>
+<criteria operator="AND">
+<criterion comment="Oracle Linux 7 is installed"/>
+<criteria operator="OR">
+<criterion comment="glibc is earlier than 0:2.17-326.0.9.el7_9"/>
+</criteria>
+</criteria>
+</criteria>
+</definition>
+</definitions>
+</oval_definitions>`
+
+func TestParseELSA(t *testing.T) {
+	want := []string{
+		"oracle:7 glibc 0:2.17-326.0.9.el7_9",
+		"oracle:8 glibc 0:2.28-236.0.1.el8",
+		"oracle:8 glibc 2:2.28-236.0.1.ksplice1.el8",
+		"oracle:8 nscd 0:2.28-236.0.1.el8",
+	}
+	// The features are in the same order however the parse is run
+	for i := 0; i < 10; i++ {
+		vulns, err := parseELSA(strings.NewReader(testELSA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vulns) != 1 || vulns[0].Name != "ELSA-2024-0001" {
+			t.Fatalf("got %+v", vulns)
+		}
+		var got []string
+		for _, a := range vulns[0].Affected {
+			got = append(got, a.Namespace.Name+" "+a.FeatureName+" "+a.FixedInVersion)
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
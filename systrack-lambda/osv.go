@@ -0,0 +1,569 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/vulnsrc"
+	"github.com/coreos/clair/pkg/commonerr"
+)
+
+// Language ecosystem advisories are read from the OSV database, which
+// publishes every advisory for an ecosystem in a single zip file
+const (
+	osvURI         = "https://osv-vulnerabilities.storage.googleapis.com/"
+	osvLinkURI     = "https://osv.dev/vulnerability/"
+	osvUpdaterFlag = "osvUpdater"
+
+	// Version formats of the ecosystems
+	pep440VersionFormat = "pep440"
+	semverVersionFormat = "semver"
+	gemVersionFormat    = "gem"
+)
+
+// osvEcosystems are the OSV ecosystems fetched, with the version format of
+// each
+var osvEcosystems = []struct {
+	name   string
+	format string
+}{
+	{"PyPI", pep440VersionFormat},
+	{"npm", semverVersionFormat},
+	{"RubyGems", gemVersionFormat},
+	{"Go", semverVersionFormat},
+}
+
+// osvEntry includes the fields of an OSV advisory we use
+type osvEntry struct {
+	ID               string `json:"id"`
+	Summary          string `json:"summary"`
+	Details          string `json:"details"`
+	Withdrawn        string `json:"withdrawn"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string `json:"type"`
+			Events []struct {
+				Introduced string `json:"introduced"`
+				Fixed      string `json:"fixed"`
+			} `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+}
+
+// osvNamespace returns the namespace the advisories for the packages of an OSV
+// ecosystem are stored under
+func osvNamespace(ecosystem string) string {
+	return "osv:" + ecosystem
+}
+
+// osvPackageName returns name in the form it is indexed under for ecosystem.
+// Python package names are compared case insensitively, treating runs of -, _
+// and . as the same.
+func osvPackageName(ecosystem, name string) string {
+	if ecosystem != "PyPI" {
+		return name
+	}
+	name = strings.ToLower(name)
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	}), "-")
+}
+
+func fetchOSV() (resp vulnsrc.UpdateResponse, err error) {
+	for _, eco := range osvEcosystems {
+		vs, err := fetchOSVEcosystem(eco.name, eco.format)
+		if err != nil {
+			return resp, fmt.Errorf("%v: %v", eco.name, err)
+		}
+		resp.Vulnerabilities = append(resp.Vulnerabilities, vs...)
+	}
+	resp.FlagName = osvUpdaterFlag
+	return resp, nil
+}
+
+// fetchOSVEcosystem downloads the advisories of ecosystem. The zip file is
+// saved to a temporary file first, as some ecosystems have hundreds of
+// megabytes of advisories.
+func fetchOSVEcosystem(ecosystem, format string) ([]database.VulnerabilityWithAffected, error) {
+	r, err := http.Get(osvURI + ecosystem + "/all.zip")
+	if err != nil {
+		return nil, commonerr.ErrCouldNotDownload
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, commonerr.ErrCouldNotDownload
+	}
+	fd, err := ioutil.TempFile("", "osv")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+	n, err := io.Copy(fd, r.Body)
+	if err != nil {
+		return nil, commonerr.ErrCouldNotDownload
+	}
+	zr, err := zip.NewReader(fd, n)
+	if err != nil {
+		return nil, commonerr.ErrCouldNotParse
+	}
+	var ret []database.VulnerabilityWithAffected
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, commonerr.ErrCouldNotParse
+		}
+		v, ok, err := parseOSV(rc, ecosystem, format)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", f.Name, err)
+		}
+		if ok {
+			ret = append(ret, v)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+// parseOSV parses an OSV advisory, returning false if it has no fixed version
+// of a package in ecosystem. Each range of affected versions is stored as an
+// affected feature whose AffectedVersion is the first affected version, where
+// 0 means every version before the fix.
+func parseOSV(r io.Reader, ecosystem, format string) (v database.VulnerabilityWithAffected, ok bool, err error) {
+	var e osvEntry
+	err = json.NewDecoder(r).Decode(&e)
+	if err != nil {
+		return v, false, commonerr.ErrCouldNotParse
+	}
+	if e.Withdrawn != "" {
+		return v, false, nil
+	}
+	v.Name = e.ID
+	v.Link = osvLinkURI + e.ID
+	v.Description = e.Summary
+	if v.Description == "" {
+		v.Description = e.Details
+	}
+	v.Severity = osvSeverity(e.DatabaseSpecific.Severity)
+	for _, a := range e.Affected {
+		if a.Package.Ecosystem != ecosystem || a.Package.Name == "" {
+			continue
+		}
+		for _, rng := range a.Ranges {
+			if rng.Type != "ECOSYSTEM" && rng.Type != "SEMVER" {
+				// GIT ranges give commits, not versions
+				continue
+			}
+			introduced := ""
+			for _, ev := range rng.Events {
+				switch {
+				case ev.Introduced != "":
+					introduced = ev.Introduced
+				case ev.Fixed != "" && introduced != "":
+					v.Affected = append(v.Affected, database.AffectedFeature{
+						FeatureName:     osvPackageName(ecosystem, a.Package.Name),
+						AffectedVersion: introduced,
+						FixedInVersion:  ev.Fixed,
+						Namespace: database.Namespace{
+							Name:          osvNamespace(ecosystem),
+							VersionFormat: format,
+						},
+					})
+					introduced = ""
+				}
+			}
+		}
+	}
+	return v, len(v.Affected) > 0, nil
+}
+
+// osvSeverity converts the severity GitHub advisories give in the OSV data.
+// Other advisories have no severity.
+func osvSeverity(sev string) database.Severity {
+	switch strings.ToUpper(sev) {
+	case "LOW":
+		return database.LowSeverity
+	case "MODERATE", "MEDIUM":
+		return database.MediumSeverity
+	case "HIGH":
+		return database.HighSeverity
+	case "CRITICAL":
+		return database.CriticalSeverity
+	}
+	return database.UnknownSeverity
+}
+
+// semverVersion is a parsed semantic version
+type semverVersion struct {
+	release [3]int
+	pre     []string
+}
+
+// parseSemver parses a semantic version as used by npm and Go modules, also
+// accepting a leading v or =, and Go toolchain versions such as go1.21.5 or
+// go1.22rc1
+func parseSemver(v string) (ret semverVersion, err error) {
+	bad := fmt.Errorf("invalid semantic version %q", v)
+	s := strings.TrimLeft(strings.TrimSpace(v), "=v")
+	if strings.HasPrefix(s, "go") {
+		// Go toolchain versions drop zero components and have no dash
+		// before a pre-release
+		s = s[2:]
+		if i := strings.IndexAny(s, "abcdefghijklmnopqrstuvwxyz"); i != -1 {
+			s = s[:i] + "-" + s[i:]
+		}
+	}
+	if i := strings.Index(s, "+"); i != -1 {
+		// Build metadata is ignored in comparisons
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i != -1 {
+		ret.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return ret, bad
+	}
+	for i, x := range parts {
+		n, err := strconv.Atoi(x)
+		if err != nil || n < 0 {
+			return ret, bad
+		}
+		ret.release[i] = n
+	}
+	return ret, nil
+}
+
+// compareSemver compares semantic versions a and b, returning -1, 0 or 1 if a
+// is lower than, equal to or greater than b
+func compareSemver(a, b string) (int, error) {
+	x, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range x.release {
+		if c := compareInts(x.release[i], y.release[i]); c != 0 {
+			return c, nil
+		}
+	}
+	// A pre-release is lower than the release, and identifiers are compared
+	// numerically if they are numbers, with numbers lower than other
+	// identifiers, and a shorter list of identifiers is lower
+	switch {
+	case len(x.pre) == 0 && len(y.pre) == 0:
+		return 0, nil
+	case len(x.pre) == 0:
+		return 1, nil
+	case len(y.pre) == 0:
+		return -1, nil
+	}
+	for i := 0; i < len(x.pre) && i < len(y.pre); i++ {
+		xn, xerr := strconv.Atoi(x.pre[i])
+		yn, yerr := strconv.Atoi(y.pre[i])
+		var c int
+		switch {
+		case xerr == nil && yerr == nil:
+			c = compareInts(xn, yn)
+		case xerr == nil:
+			c = -1
+		case yerr == nil:
+			c = 1
+		default:
+			c = strings.Compare(x.pre[i], y.pre[i])
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+	return compareInts(len(x.pre), len(y.pre)), nil
+}
+
+// pep440Version is a parsed Python package version
+type pep440Version struct {
+	epoch   int
+	release []int
+	pre     int // Pre-release phase, -1 if none, otherwise 0 for a, 1 for b, 2 for rc
+	preN    int
+	post    int // -1 if none
+	dev     int // -1 if none
+	local   string
+}
+
+// pep440PreReleases maps the spellings of pre-release phases to their rank
+var pep440PreReleases = map[string]int{
+	"a": 0, "alpha": 0,
+	"b": 1, "beta": 1,
+	"rc": 2, "c": 2, "pre": 2, "preview": 2,
+}
+
+// parsePEP440 parses a version following PEP 440, accepting the alternative
+// spellings it allows such as 1.0-alpha.1 for 1.0a1
+func parsePEP440(v string) (ret pep440Version, err error) {
+	bad := fmt.Errorf("invalid PEP 440 version %q", v)
+	s := strings.ToLower(strings.TrimSpace(v))
+	s = strings.TrimPrefix(s, "v")
+	ret.pre, ret.post, ret.dev = -1, -1, -1
+	if i := strings.Index(s, "+"); i != -1 {
+		ret.local = s[i+1:]
+		s = s[:i]
+	}
+	if i := strings.Index(s, "!"); i != -1 {
+		ret.epoch, err = strconv.Atoi(s[:i])
+		if err != nil {
+			return ret, bad
+		}
+		s = s[i+1:]
+	}
+	// number reads digits from the start of s
+	number := func() (int, bool) {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, false
+		}
+		n, err := strconv.Atoi(s[:i])
+		s = s[i:]
+		return n, err == nil
+	}
+	// word reads a separator, if any, and the letters that follow it
+	word := func() (string, string) {
+		sep := ""
+		if len(s) > 0 && (s[0] == '.' || s[0] == '-' || s[0] == '_') {
+			sep = s[:1]
+		}
+		i := len(sep)
+		for i < len(s) && unicode.IsLetter(rune(s[i])) {
+			i++
+		}
+		return sep, s[len(sep):i]
+	}
+	for {
+		n, ok := number()
+		if !ok {
+			return ret, bad
+		}
+		ret.release = append(ret.release, n)
+		if !strings.HasPrefix(s, ".") || len(s) < 2 || s[1] < '0' || s[1] > '9' {
+			break
+		}
+		s = s[1:]
+	}
+	for s != "" {
+		sep, w := word()
+		s = s[len(sep)+len(w):]
+		if w == "" && sep != "-" {
+			return ret, bad
+		}
+		if w != "" && len(s) > 1 && (s[0] == '.' || s[0] == '-' || s[0] == '_') {
+			// The number after a pre, post or dev release may be
+			// separated from it
+			s = s[1:]
+		}
+		n, ok := number()
+		phase, isPre := pep440PreReleases[w]
+		switch {
+		case w == "" && ok && ret.post == -1 && ret.dev == -1:
+			// 1.0-1 is the same as 1.0.post1
+			ret.post = n
+		case isPre && ret.pre == -1 && ret.post == -1 && ret.dev == -1:
+			ret.pre, ret.preN = phase, n
+		case (w == "post" || w == "rev" || w == "r") && ret.post == -1 && ret.dev == -1:
+			ret.post = n
+		case w == "dev" && ret.dev == -1:
+			ret.dev = n
+		default:
+			return ret, bad
+		}
+	}
+	return ret, nil
+}
+
+// comparePEP440 compares Python package versions a and b following PEP 440,
+// returning -1, 0 or 1 if a is lower than, equal to or greater than b. A
+// development release is lower than the pre-releases, which are lower than
+// the release, which is lower than its post-releases.
+func comparePEP440(a, b string) (int, error) {
+	x, err := parsePEP440(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := parsePEP440(b)
+	if err != nil {
+		return 0, err
+	}
+	if c := compareInts(x.epoch, y.epoch); c != 0 {
+		return c, nil
+	}
+	for i := 0; i < len(x.release) || i < len(y.release); i++ {
+		var xn, yn int
+		if i < len(x.release) {
+			xn = x.release[i]
+		}
+		if i < len(y.release) {
+			yn = y.release[i]
+		}
+		if c := compareInts(xn, yn); c != 0 {
+			return c, nil
+		}
+	}
+	if c := compareInts(x.preKey(), y.preKey()); c != 0 {
+		return c, nil
+	}
+	if x.pre != -1 && y.pre != -1 {
+		if c := compareInts(x.preN, y.preN); c != 0 {
+			return c, nil
+		}
+	}
+	if c := compareInts(x.post, y.post); c != 0 {
+		return c, nil
+	}
+	// No dev release is greater than any
+	xd, yd := x.dev, y.dev
+	if xd == -1 {
+		xd = int(^uint(0) >> 1)
+	}
+	if yd == -1 {
+		yd = int(^uint(0) >> 1)
+	}
+	if c := compareInts(xd, yd); c != 0 {
+		return c, nil
+	}
+	// A local version is greater than the same version without one
+	switch {
+	case x.local == y.local:
+		return 0, nil
+	case x.local == "":
+		return -1, nil
+	case y.local == "":
+		return 1, nil
+	}
+	return strings.Compare(x.local, y.local), nil
+}
+
+// preKey ranks the pre-release phase of v, where a development release of the
+// release itself ranks lowest and the release highest
+func (v pep440Version) preKey() int {
+	switch {
+	case v.pre == -1 && v.post == -1 && v.dev != -1:
+		return -1
+	case v.pre == -1:
+		return 3
+	}
+	return v.pre
+}
+
+// compareGemVersions compares Ruby gem versions a and b as Gem::Version does,
+// returning -1, 0 or 1 if a is lower than, equal to or greater than b. A
+// version with letters in it, such as 1.0.0.rc1, is a pre-release, and lower
+// than the release.
+func compareGemVersions(a, b string) (int, error) {
+	x, err := gemSegments(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := gemSegments(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(x) || i < len(y); i++ {
+		xs, ys := "0", "0"
+		if i < len(x) {
+			xs = x[i]
+		}
+		if i < len(y) {
+			ys = y[i]
+		}
+		xn, xerr := strconv.Atoi(xs)
+		yn, yerr := strconv.Atoi(ys)
+		var c int
+		switch {
+		case xerr == nil && yerr == nil:
+			c = compareInts(xn, yn)
+		case xerr == nil:
+			c = 1
+		case yerr == nil:
+			c = -1
+		default:
+			c = strings.Compare(xs, ys)
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+	return 0, nil
+}
+
+// gemSegments splits a gem version into its numeric and letter segments, such
+// as 1, 0, rc, 1 for 1.0.rc1, and removes the trailing zeros of the release and
+// pre-release parts, which do not change the version
+func gemSegments(v string) ([]string, error) {
+	var segs []string
+	s := strings.TrimSpace(v)
+	for s != "" {
+		i := 0
+		switch {
+		case s[0] >= '0' && s[0] <= '9':
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+		case unicode.IsLetter(rune(s[0])):
+			for i < len(s) && unicode.IsLetter(rune(s[i])) {
+				i++
+			}
+		case s[0] == '.' || s[0] == '-':
+			s = s[1:]
+			continue
+		default:
+			return nil, fmt.Errorf("invalid gem version %q", v)
+		}
+		segs = append(segs, s[:i])
+		s = s[i:]
+	}
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("invalid gem version %q", v)
+	}
+	pre := len(segs)
+	for i, x := range segs {
+		if x[0] < '0' || x[0] > '9' {
+			pre = i
+			break
+		}
+	}
+	trim := func(p []string) []string {
+		for len(p) > 0 {
+			if n, err := strconv.Atoi(p[len(p)-1]); err != nil || n != 0 {
+				break
+			}
+			p = p[:len(p)-1]
+		}
+		return p
+	}
+	return append(trim(segs[:pre:pre]), trim(segs[pre:])...), nil
+}
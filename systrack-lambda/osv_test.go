@@ -0,0 +1,263 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/coreos/clair/database"
+)
+
+// loadOSVSamples parses the saved OSV advisories in sample/osv
+func loadOSVSamples(t *testing.T) []database.VulnerabilityWithAffected {
+	paths, err := filepath.Glob("sample/osv/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no OSV samples: %v", err)
+	}
+	var ret []database.VulnerabilityWithAffected
+	for _, path := range paths {
+		eco := strings.SplitN(filepath.Base(path), "-", 2)[0]
+		format := ""
+		for _, e := range osvEcosystems {
+			if e.name == eco {
+				format = e.format
+			}
+		}
+		if format == "" {
+			t.Fatalf("%v: unknown ecosystem %v", path, eco)
+		}
+		fd, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, ok, err := parseOSV(fd, eco, format)
+		fd.Close()
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if ok {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func TestParseOSV(t *testing.T) {
+	type feature struct {
+		ns, name, introduced, fixed string
+	}
+	want := map[string][]feature{
+		"GHSA-h5c8-rqwp-cp95": {
+			{"osv:PyPI", "jinja2", "0", "3.1.3"},
+		},
+		"PYSEC-2023-74": {
+			{"osv:PyPI", "requests", "2.3.0", "2.31.0"},
+		},
+		"GO-2024-2598": {
+			{"osv:Go", "stdlib", "0", "1.21.8"},
+			{"osv:Go", "stdlib", "1.22.0-0", "1.22.1"},
+		},
+		"GHSA-c2qf-rxjj-qqgw": {
+			{"osv:npm", "semver", "7.0.0", "7.5.2"},
+			{"osv:npm", "semver", "6.0.0", "6.3.1"},
+			{"osv:npm", "semver", "0", "5.7.2"},
+		},
+		"GHSA-vr8q-g5c7-m54m": {
+			{"osv:RubyGems", "nokogiri", "0", "1.15.6"},
+			{"osv:RubyGems", "nokogiri", "1.16.0.rc1", "1.16.2"},
+		},
+	}
+	got := make(map[string][]feature)
+	for _, v := range loadOSVSamples(t) {
+		if v.Link != osvLinkURI+v.Name {
+			t.Errorf("%v: link %v", v.Name, v.Link)
+		}
+		for _, a := range v.Affected {
+			got[v.Name] = append(got[v.Name], feature{a.Namespace.Name, a.FeatureName,
+				a.AffectedVersion, a.FixedInVersion})
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %v, want %v", got, want)
+	}
+}
+
+func TestOSVSeverity(t *testing.T) {
+	sev := make(map[string]database.Severity)
+	for _, v := range loadOSVSamples(t) {
+		sev[v.Name] = v.Severity
+	}
+	for name, want := range map[string]database.Severity{
+		"GHSA-h5c8-rqwp-cp95": database.MediumSeverity,
+		"GHSA-c2qf-rxjj-qqgw": database.HighSeverity,
+		"PYSEC-2023-74":       database.UnknownSeverity,
+	} {
+		if sev[name] != want {
+			t.Errorf("%v: severity %v, want %v", name, sev[name], want)
+		}
+	}
+}
+
+func TestCheckLangVuln(t *testing.T) {
+	saved := cfg.vulnIndex
+	defer func() { cfg.vulnIndex = saved }()
+	cfg.vulnIndex = newVulnIndex(loadOSVSamples(t))
+
+	tests := []struct {
+		pkgtype, name, version string
+		want                   []string
+	}{
+		{"pip", "Jinja2", "3.1.2", []string{"GHSA-h5c8-rqwp-cp95"}},
+		{"pip", "jinja2", "3.1.3", nil},
+		{"pip", "requests", "2.2.1", nil},
+		{"pip", "requests", "2.28.1", []string{"PYSEC-2023-74"}},
+		{"pip", "requests", "2.31.0", nil},
+		{"go", "stdlib", "go1.21.7", []string{"GO-2024-2598"}},
+		{"go", "stdlib", "go1.21.8", nil},
+		{"go", "stdlib", "go1.22rc1", []string{"GO-2024-2598"}},
+		{"go", "stdlib", "go1.22.0", []string{"GO-2024-2598"}},
+		{"go", "stdlib", "go1.22.1", nil},
+		{"npm", "semver", "5.7.1", []string{"GHSA-c2qf-rxjj-qqgw"}},
+		{"npm", "semver", "5.7.2", nil},
+		{"npm", "semver", "6.3.0", []string{"GHSA-c2qf-rxjj-qqgw"}},
+		{"npm", "semver", "7.5.4", nil},
+		{"gem", "nokogiri", "1.15.5", []string{"GHSA-vr8q-g5c7-m54m"}},
+		{"gem", "nokogiri", "1.15.6", nil},
+		{"gem", "nokogiri", "1.16.0", []string{"GHSA-vr8q-g5c7-m54m"}},
+		{"gem", "nokogiri", "1.16.2", nil},
+	}
+	for _, tc := range tests {
+		p := pkgLogEnt{Hostname: "host1"}
+		p.Fields.PkgType = tc.pkgtype
+		p.Fields.PkgName = tc.name
+		p.Fields.PkgVersion = tc.version
+		p.Fields.Dist = "debian:12"
+		lines := checkVuln(p)
+		var got []string
+		for _, l := range lines {
+			for _, w := range tc.want {
+				if strings.Contains(l, "\t"+w+"\t") {
+					got = append(got, w)
+				}
+			}
+		}
+		sort.Strings(got)
+		if len(lines) != len(tc.want) || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v %v %v: got %q, want %v", tc.pkgtype, tc.name, tc.version,
+				lines, tc.want)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.0.0+build1", "1.0.0", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"1.22.0-0", "1.22.0", -1},
+		{"go1.21.5", "1.21.5", 0},
+		{"go1.22", "1.22.0", 0},
+		{"go1.22rc1", "1.22.0", -1},
+		{"go1.22rc1", "1.22.0-0", 1},
+	}
+	for _, tc := range tests {
+		c, err := compareSemver(tc.a, tc.b)
+		if err != nil {
+			t.Errorf("%v %v: %v", tc.a, tc.b, err)
+		} else if c != tc.want {
+			t.Errorf("compareSemver(%v, %v) = %v, want %v", tc.a, tc.b, c, tc.want)
+		}
+	}
+	for _, v := range []string{"", "1.x", "1.2.3.4", "(devel)"} {
+		if _, err := compareSemver(v, "1.0.0"); err == nil {
+			t.Errorf("compareSemver(%q) had no error", v)
+		}
+	}
+}
+
+func TestComparePEP440(t *testing.T) {
+	// Each version is lower than the next, following the ordering examples
+	// of PEP 440
+	ordered := []string{
+		"1.0.dev456",
+		"1.0a1",
+		"1.0a2.dev456",
+		"1.0a12.dev456",
+		"1.0a12",
+		"1.0b1.dev456",
+		"1.0b2",
+		"1.0b2.post345.dev456",
+		"1.0b2.post345",
+		"1.0rc1.dev456",
+		"1.0rc1",
+		"1.0",
+		"1.0+abc.5",
+		"1.0.post456.dev34",
+		"1.0.post456",
+		"1.1.dev1",
+		"1!0.1",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		c, err := comparePEP440(ordered[i], ordered[i+1])
+		if err != nil {
+			t.Errorf("%v %v: %v", ordered[i], ordered[i+1], err)
+		} else if c != -1 {
+			t.Errorf("comparePEP440(%v, %v) = %v, want -1", ordered[i], ordered[i+1], c)
+		}
+	}
+	for _, eq := range [][2]string{
+		{"1.0", "1.0.0"},
+		{"1.0a1", "1.0-alpha.1"},
+		{"1.0rc1", "1.0c1"},
+		{"1.0.post1", "1.0-1"},
+		{"1.0.post1", "1.0.r1"},
+		{"v2.31.0", "2.31.0"},
+		{"1.0.DEV1", "1.0.dev1"},
+	} {
+		c, err := comparePEP440(eq[0], eq[1])
+		if err != nil || c != 0 {
+			t.Errorf("comparePEP440(%v, %v) = %v, %v, want 0", eq[0], eq[1], c, err)
+		}
+	}
+	for _, v := range []string{"", "abc", "1.0..1", "1.0foo1"} {
+		if _, err := comparePEP440(v, "1.0"); err == nil {
+			t.Errorf("comparePEP440(%q) had no error", v)
+		}
+	}
+}
+
+func TestCompareGemVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0.0", 0},
+		{"1.0.0.rc1", "1.0.0", -1},
+		{"1.0.0.a", "1.0.0.b", -1},
+		{"1.0.0.rc1", "1.0.0.rc2", -1},
+		{"1.16.0.rc1", "1.16.0", -1},
+		{"1.16.0.rc1", "1.15.6", 1},
+		{"1.9", "1.10", -1},
+	}
+	for _, tc := range tests {
+		c, err := compareGemVersions(tc.a, tc.b)
+		if err != nil {
+			t.Errorf("%v %v: %v", tc.a, tc.b, err)
+		} else if c != tc.want {
+			t.Errorf("compareGemVersions(%v, %v) = %v, want %v", tc.a, tc.b, c, tc.want)
+		}
+	}
+}
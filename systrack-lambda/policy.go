@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+
+	"github.com/coreos/clair/database"
+)
+
+// checkPolicy checks a policy record, returning an output line if the policy
+// test failed. The finding is named after the test, with the severity given
+// by a severity tag on the test, or medium if it has none. Tests that could
+// not be evaluated are logged but not reported.
+func checkPolicy(p pkgLogEnt) (ret []string) {
+	switch p.Fields.Result {
+	case "fail":
+	case "error":
+		log.Printf("policy %v test %v on %v could not be evaluated: %v\n", p.Fields.Policy,
+			p.Fields.TestID, p.Hostname, p.Fields.Error)
+		return
+	default:
+		return
+	}
+	var v database.VulnerabilityWithAffected
+	v.Name = p.Fields.Policy + "/" + p.Fields.TestID
+	sev, err := database.NewSeverity(p.Fields.TestTags["severity"])
+	if err != nil {
+		sev = database.MediumSeverity
+	}
+	v.Severity = sev
+	p.Fields.setDefaults()
+	p.Fields.PkgArch, p.Fields.PkgName, p.Fields.PkgVersion = "none", "none", "none"
+	return []string{p.toLogEntry(v)}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	cfg.outputFormat = 2
+	cfg.sigPolicy = sigPolicyIgnore
+
+	// Only failed tests are reported. A test without a severity tag, or
+	// with one that is not a severity, is medium, and a test that could not
+	// be evaluated is logged.
+	want := [][]string{
+		{"sshd/sshd-password-auth", "High", "policy sshd sshd-password-auth"},
+		{"sshd/sshd-x11", "Medium", "policy sshd X11 forwarding is disabled"},
+		{"sshd/sshd-ciphers", "Medium", "policy sshd sshd-ciphers"},
+	}
+	lines := checkRecords(readTestRecords(t, "testdata/policy/records.json"))
+	if len(lines) != len(want) {
+		t.Fatalf("got %q, want %v findings", lines, len(want))
+	}
+	for i, l := range lines {
+		cols := strings.Split(l, "\t")
+		got := []string{cols[8], cols[9], cols[14]}
+		if cols[6] != "none" || cols[10] != "shop" || strings.Join(got, "|") != strings.Join(want[i], "|") {
+			t.Errorf("got %q, want %q", l, want[i])
+		}
+	}
+}
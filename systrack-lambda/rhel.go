@@ -19,14 +19,11 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
-	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -70,6 +67,7 @@ type definition struct {
 	Description string      `xml:"metadata>description"`
 	References  []reference `xml:"metadata>reference"`
 	Criteria    criteria    `xml:"criteria"`
+	Severity    string      `xml:"metadata>advisory>severity"`
 }
 
 type reference struct {
@@ -87,23 +85,6 @@ type criterion struct {
 	Comment string `xml:"comment,attr"`
 }
 
-func cacheRHEL() error {
-	vs, err := fetchRHEL()
-	if err != nil {
-		return err
-	}
-	opath := path.Join(cfg.cacheDir, "rheldata")
-	buf, err := json.Marshal(vs)
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(opath, buf, 0644)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func fetchRHEL() (resp vulnsrc.UpdateResponse, err error) {
 	r, err := http.Get(ovalURI)
 	if err != nil {
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coreos/clair/database"
+)
+
+// findingService is the name of the finding reported for a denied service
+const findingService = "denied-service"
+
+// loadServiceConfig reads the denied service names from the environment, as
+// patterns such as telnet or rsh* matched against the service name with or
+// without its .service or .socket suffix
+func loadServiceConfig() error {
+	add := func(spec string) error {
+		scn := bufio.NewScanner(strings.NewReader(strings.Replace(spec, ",", "\n", -1)))
+		for scn.Scan() {
+			line := strings.TrimSpace(scn.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if _, err := path.Match(line, ""); err != nil {
+				return fmt.Errorf("invalid service pattern %q", line)
+			}
+			cfg.deniedServices = append(cfg.deniedServices, line)
+		}
+		return scn.Err()
+	}
+	err := add(os.Getenv("SERVICE_DENYLIST"))
+	if err != nil {
+		return err
+	}
+	if p := os.Getenv("SERVICE_DENYFILE"); p != "" {
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		err = add(string(buf))
+		if err != nil {
+			return fmt.Errorf("%v: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// serviceDenied returns true if the service name matches the denylist
+func serviceDenied(name string) bool {
+	short := strings.TrimSuffix(strings.TrimSuffix(name, ".service"), ".socket")
+	for _, pattern := range cfg.deniedServices {
+		for _, n := range []string{name, short} {
+			if ok, _ := path.Match(pattern, n); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkService checks a service record against the denylist, returning an
+// output line if a denied service is enabled or running. A denied service
+// that is installed but disabled and stopped is not reported.
+func checkService(p pkgLogEnt) (ret []string) {
+	if !serviceDenied(p.Fields.Service) {
+		return
+	}
+	switch {
+	case p.Fields.Enabled == "enabled":
+	case p.Fields.Active == "active" || p.Fields.Active == "activating" ||
+		p.Fields.Active == "reloading":
+	default:
+		return
+	}
+	log.Printf("%v %v on %v\n", findingService, p.Fields.Service, p.Hostname)
+	p.Fields.setDefaults()
+	if p.Fields.PkgName == "" {
+		p.Fields.PkgArch, p.Fields.PkgName, p.Fields.PkgVersion = "none", "none", "none"
+	}
+	var v database.VulnerabilityWithAffected
+	v.Name, v.Severity = findingService, database.HighSeverity
+	return []string{p.toLogEntry(v)}
+}
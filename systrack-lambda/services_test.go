@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readTestRecords returns the records in the JSONL file at path
+func readTestRecords(t *testing.T, path string) (ret []pkgLogEnt) {
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		p, _, err := parseRecord(scn.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, p)
+	}
+	if err := scn.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestCheckService(t *testing.T) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	for k, v := range map[string]string{
+		"SERVICE_DENYLIST": "avahi-daemon",
+		"SERVICE_DENYFILE": "testdata/services/denylist",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+	cfg.deniedServices = nil
+	cfg.outputFormat = 2
+	cfg.sigPolicy = sigPolicyIgnore
+	if err := loadServiceConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := readTestRecords(t, "testdata/services/records.json")
+	// rshd.service is denied but disabled and stopped, rlogin.socket is
+	// masked, tftp.service is not the denied socket and ssh.service is
+	// allowed
+	want := [][]string{
+		{"none", "none", "service telnet.socket enabled listening"},
+		{"rsh-server", "0.17-24", "service rsh.socket disabled active"},
+		{"avahi-daemon", "0.8-10", "service avahi-daemon enabled"},
+	}
+	lines := checkRecords(recs)
+	if len(lines) != len(want) {
+		t.Fatalf("got %q, want %v findings", lines, len(want))
+	}
+	for i, l := range lines {
+		cols := strings.Split(l, "\t")
+		got := []string{cols[6], cols[7], cols[14]}
+		if cols[8] != findingService || cols[9] != "High" ||
+			strings.Join(got, "|") != strings.Join(want[i], "|") {
+			t.Errorf("got %q, want %q", l, want[i])
+		}
+	}
+}
+
+func TestLoadServiceConfigInvalid(t *testing.T) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	defer os.Setenv("SERVICE_DENYLIST", os.Getenv("SERVICE_DENYLIST"))
+	os.Setenv("SERVICE_DENYLIST", "telnet,rsh[")
+	err := loadServiceConfig()
+	if err == nil || err.Error() != `invalid service pattern "rsh["` {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signature algorithms systrack signs records with
+const (
+	sigAlgHMAC    = "hmac-sha256"
+	sigAlgEd25519 = "ed25519"
+)
+
+// Signature status of a record, included in output lines
+const (
+	sigValid    = "valid"
+	sigUnsigned = "unsigned"
+	sigInvalid  = "invalid"
+)
+
+// Policies for records that are unsigned or have an invalid signature
+const (
+	sigPolicyIgnore = "ignore" // Process the record without checking signatures
+	sigPolicyFlag   = "flag"   // Process the record, marking its output lines
+	sigPolicyReject = "reject" // Drop the record
+)
+
+// sigClockSkew is how far in the future the signing time of a record may be,
+// to allow for clocks that are not quite in step
+const sigClockSkew = 5 * time.Minute
+
+// verifyKey is a key used to verify record signatures, an HMAC secret or an
+// Ed25519 public key
+type verifyKey struct {
+	alg string
+	key []byte
+
+	// Patterns of the fqdns the key may sign records for. If there are none
+	// the key may only sign for the host named by its key id.
+	hosts []string
+}
+
+// signsFor returns true if k may sign records for the host fqdn
+func (k verifyKey) signsFor(keyID, fqdn string) bool {
+	if fqdn == "" {
+		return false
+	}
+	if len(k.hosts) == 0 {
+		return fqdn == keyID
+	}
+	for _, h := range k.hosts {
+		if ok, _ := path.Match(h, fqdn); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadVerifyKeys parses keys in the form keyid:alg:base64key[:hosts],
+// separated by commas or newlines, where hosts is a space separated list of
+// patterns of the fqdns the key may sign for
+func loadVerifyKeys(spec string, keys map[string]verifyKey) error {
+	scn := bufio.NewScanner(strings.NewReader(strings.Replace(spec, ",", "\n", -1)))
+	for scn.Scan() {
+		line := strings.TrimSpace(scn.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.SplitN(line, ":", 4)
+		if len(f) < 3 {
+			return fmt.Errorf("invalid signing key entry, expected keyid:alg:key[:hosts]")
+		}
+		key, err := base64.StdEncoding.DecodeString(f[2])
+		if err != nil {
+			return fmt.Errorf("signing key %v is not base64 encoded: %v", f[0], err)
+		}
+		switch f[1] {
+		case sigAlgHMAC:
+		case sigAlgEd25519:
+			if len(key) != ed25519.PublicKeySize {
+				return fmt.Errorf("signing key %v is not an Ed25519 public key", f[0])
+			}
+		default:
+			return fmt.Errorf("signing key %v has unknown algorithm %q", f[0], f[1])
+		}
+		k := verifyKey{alg: f[1], key: key}
+		if len(f) == 4 {
+			k.hosts = strings.Fields(f[3])
+			for _, h := range k.hosts {
+				if _, err := path.Match(h, ""); err != nil {
+					return fmt.Errorf("signing key %v has invalid host pattern %q", f[0], h)
+				}
+			}
+		}
+		keys[f[0]] = k
+	}
+	return scn.Err()
+}
+
+// loadSigningConfig reads the signature policy and verification keys from the
+// environment
+func loadSigningConfig() error {
+	cfg.sigPolicy = os.Getenv("SIGNATURE_POLICY")
+	if cfg.sigPolicy == "" {
+		cfg.sigPolicy = sigPolicyIgnore
+	}
+	switch cfg.sigPolicy {
+	case sigPolicyIgnore, sigPolicyFlag, sigPolicyReject:
+	default:
+		return fmt.Errorf("SIGNATURE_POLICY must be %v, %v or %v", sigPolicyIgnore,
+			sigPolicyFlag, sigPolicyReject)
+	}
+	cfg.sigKeys = make(map[string]verifyKey)
+	err := loadVerifyKeys(os.Getenv("SIGNING_KEYS"), cfg.sigKeys)
+	if err != nil {
+		return err
+	}
+	if path := os.Getenv("SIGNING_KEYFILE"); path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		err = loadVerifyKeys(string(buf), cfg.sigKeys)
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+	}
+	if cfg.sigPolicy != sigPolicyIgnore && len(cfg.sigKeys) == 0 {
+		return errors.New("SIGNATURE_POLICY requires SIGNING_KEYS or SIGNING_KEYFILE")
+	}
+	// Spooled records may be delivered up to a week after they were
+	// written, by default
+	cfg.sigMaxAge = 8 * 24 * time.Hour
+	if v := os.Getenv("SIGNATURE_MAX_AGE"); v != "" {
+		cfg.sigMaxAge, err = time.ParseDuration(v)
+		if err != nil || cfg.sigMaxAge <= 0 {
+			return fmt.Errorf("SIGNATURE_MAX_AGE must be a positive duration")
+		}
+	}
+	return nil
+}
+
+// verifyRecord checks the signature of the raw record buf, returning its
+// signature status
+func verifyRecord(buf []byte) (string, error) {
+	var rec struct {
+		Fields json.RawMessage `json:"Fields"`
+	}
+	err := json.Unmarshal(buf, &rec)
+	if err != nil {
+		return "", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(rec.Fields))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	err = dec.Decode(&fields)
+	if err != nil {
+		return "", err
+	}
+	sig, _ := fields["sig"].(string)
+	if sig == "" {
+		return sigUnsigned, nil
+	}
+	alg, _ := fields["sigalg"].(string)
+	keyID, _ := fields["sigkeyid"].(string)
+	key, ok := cfg.sigKeys[keyID]
+	if !ok || key.alg != alg {
+		// A key we do not know cannot be told apart from a forgery
+		return sigInvalid, nil
+	}
+	sigbuf, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return sigInvalid, nil
+	}
+	// The signature covers the fields encoded with sorted keys, without the
+	// signature and the message added by the log formatter
+	delete(fields, "sig")
+	delete(fields, "msg")
+	msg, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	valid := false
+	switch alg {
+	case sigAlgHMAC:
+		mac := hmac.New(sha256.New, key.key)
+		mac.Write(msg)
+		valid = hmac.Equal(mac.Sum(nil), sigbuf)
+	case sigAlgEd25519:
+		valid = ed25519.Verify(ed25519.PublicKey(key.key), msg, sigbuf)
+	}
+	if !valid {
+		return sigInvalid, nil
+	}
+	// A good signature from a key for another host, or on a record signed
+	// too long ago, is a record being replayed
+	fqdn, _ := fields["fqdn"].(string)
+	if !key.signsFor(keyID, fqdn) {
+		log.Printf("key %v may not sign for %v\n", keyID, fqdn)
+		return sigInvalid, nil
+	}
+	n, _ := fields["sigtime"].(json.Number)
+	sigtime, err := n.Int64()
+	if err != nil {
+		log.Printf("record from %v has no signing time\n", fqdn)
+		return sigInvalid, nil
+	}
+	age := time.Since(time.Unix(sigtime, 0))
+	if age > cfg.sigMaxAge || age < -sigClockSkew {
+		log.Printf("record from %v signed %v ago\n", fqdn, age)
+		return sigInvalid, nil
+	}
+	return sigValid, nil
+}
+
+// parseRecord decodes the raw record buf and applies the signature policy. If
+// the record should be dropped, ok is false.
+func parseRecord(buf []byte) (p pkgLogEnt, ok bool, err error) {
+	err = json.Unmarshal(buf, &p)
+	if err != nil {
+		return
+	}
+	if cfg.sigPolicy == sigPolicyIgnore {
+		return p, true, nil
+	}
+	p.SigStatus, err = verifyRecord(buf)
+	if err != nil {
+		return
+	}
+	if p.SigStatus == sigValid {
+		// Report the signed host and time, rather than those of the
+		// envelope, which anyone could change
+		p.Hostname = p.Fields.FQDN
+		p.Time = time.Unix(p.Fields.SigTime, 0).UTC()
+		return p, true, nil
+	}
+	log.Printf("%v record from %v (%v)\n", p.SigStatus, p.Hostname, p.Fields.SigKeyID)
+	return p, cfg.sigPolicy == sigPolicyFlag, nil
+}
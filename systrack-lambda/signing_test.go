@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// signedRecord returns a record for hostname with fields, signed the way
+// systrack signs them with sign, and with the envelope host and time set to
+// envHost and envTime
+func signedRecord(t *testing.T, fields map[string]interface{}, envHost string, envTime time.Time,
+	sign func([]byte) []byte) []byte {
+	msg, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := make(map[string]interface{})
+	for k, v := range fields {
+		f[k] = v
+	}
+	f["sig"] = base64.StdEncoding.EncodeToString(sign(msg))
+	f["msg"] = "package"
+	buf, err := json.Marshal(map[string]interface{}{
+		"Hostname":  envHost,
+		"Timestamp": envTime.UnixNano(),
+		"Time":      envTime,
+		"Fields":    f,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestVerifyRecord(t *testing.T) {
+	saved := cfg
+	defer func() { cfg = saved }()
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := bytes.Repeat([]byte{7}, 32)
+	cfg.sigPolicy = sigPolicyReject
+	cfg.sigMaxAge = 24 * time.Hour
+	cfg.sigKeys = make(map[string]verifyKey)
+	err = loadVerifyKeys("web1.example.com:ed25519:"+base64.StdEncoding.EncodeToString(pub)+
+		",fleet:hmac-sha256:"+base64.StdEncoding.EncodeToString(hmacKey)+":*.db.example.com",
+		cfg.sigKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSign := func(msg []byte) []byte { return ed25519.Sign(priv, msg) }
+	hmacSign := func(msg []byte) []byte {
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(msg)
+		return mac.Sum(nil)
+	}
+
+	now := time.Now()
+	fields := func(fqdn, keyID, alg string, signed time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"fqdn": fqdn, "dist": "debian:12", "pkgname": "bash", "pkgversion": "5.2.15-2+b9",
+			"rectype": "package", "sigalg": alg, "sigkeyid": keyID, "sigtime": signed.Unix(),
+		}
+	}
+	tests := []struct {
+		name    string
+		fields  map[string]interface{}
+		envHost string
+		sign    func([]byte) []byte
+		want    string
+	}{
+		{"host key", fields("web1.example.com", "web1.example.com", sigAlgEd25519, now),
+			"web1.example.com", edSign, sigValid},
+		{"fleet key", fields("pg1.db.example.com", "fleet", sigAlgHMAC, now),
+			"pg1.db.example.com", hmacSign, sigValid},
+		// The envelope is not signed, so relabelling it changes nothing but
+		// the output uses the signed fqdn
+		{"relabelled envelope", fields("web1.example.com", "web1.example.com", sigAlgEd25519, now),
+			"web2.example.com", edSign, sigValid},
+		{"host key for another host", fields("web2.example.com", "web1.example.com", sigAlgEd25519, now),
+			"web2.example.com", edSign, sigInvalid},
+		{"fleet key outside its hosts", fields("web2.example.com", "fleet", sigAlgHMAC, now),
+			"web2.example.com", hmacSign, sigInvalid},
+		{"replayed", fields("web1.example.com", "web1.example.com", sigAlgEd25519, now.Add(-48*time.Hour)),
+			"web1.example.com", edSign, sigInvalid},
+		{"future", fields("web1.example.com", "web1.example.com", sigAlgEd25519, now.Add(time.Hour)),
+			"web1.example.com", edSign, sigInvalid},
+		{"wrong key", fields("web1.example.com", "web1.example.com", sigAlgEd25519, now),
+			"web1.example.com", hmacSign, sigInvalid},
+	}
+	for _, tc := range tests {
+		buf := signedRecord(t, tc.fields, tc.envHost, now.Add(-72*time.Hour), tc.sign)
+		p, ok, err := parseRecord(buf)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if p.SigStatus != tc.want || ok != (tc.want == sigValid) {
+			t.Errorf("%v: got %v %v, want %v", tc.name, p.SigStatus, ok, tc.want)
+			continue
+		}
+		if ok && (p.Hostname != tc.fields["fqdn"] || p.Time.Unix() != now.Unix()) {
+			t.Errorf("%v: reported as %v at %v, want the signed fqdn and time", tc.name,
+				p.Hostname, p.Time)
+		}
+	}
+
+	// A record with no signing time cannot be checked for replay
+	f := fields("web1.example.com", "web1.example.com", sigAlgEd25519, now)
+	delete(f, "sigtime")
+	if p, _, _ := parseRecord(signedRecord(t, f, "web1.example.com", now, edSign)); p.SigStatus != sigInvalid {
+		t.Errorf("record without sigtime is %v", p.SigStatus)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/versionfmt"
+	"github.com/coreos/clair/ext/versionfmt/dpkg"
+	"github.com/coreos/clair/ext/vulnsrc"
+	"github.com/mozilla/scribe"
+)
+
+// vulnSource is a source of advisories, which is fetched when the cache is
+// generated and stored in the cache directory under its cache file name
+type vulnSource struct {
+	name      string
+	cacheFile string
+	fetch     func() (vulnsrc.UpdateResponse, error)
+}
+
+// vulnSources are the advisory sources cached and checked against
+var vulnSources = []vulnSource{
+	{"rhel", "rheldata", fetchRHEL},
+	{"debian", "debiandata", fetchDebian},
+	{"ubuntu", "ubuntudata", fetchUbuntu},
+	{"alpine", "alpinedata", fetchAlpine},
+	{"oracle", "oracledata", fetchOracle},
+	{"amazon", "amazondata", fetchALAS},
+	{"osv", "osvdata", fetchOSV},
+}
+
+// sourceList returns the advisory sources named in the comma separated list in
+// environment variable env, or an empty map if it is not set
+func sourceList(env string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	for _, x := range strings.Split(os.Getenv(env), ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			ret[x] = true
+		}
+	}
+	for x := range ret {
+		found := false
+		for _, s := range vulnSources {
+			found = found || s.name == x
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown advisory source %q in %v", x, env)
+		}
+	}
+	return ret, nil
+}
+
+// loadSourceConfig reads the advisory sources that must be loaded from
+// VULNSOURCES. If it is not set, every source must be in the cache.
+func loadSourceConfig() (err error) {
+	cfg.vulnSources, err = sourceList("VULNSOURCES")
+	return err
+}
+
+// cacheVulns fetches the advisories from each source and writes them to the
+// cache directory. If CACHESOURCES is set, only the sources it names are
+// fetched, leaving the cache of the others as it is.
+func cacheVulns() error {
+	only, err := sourceList("CACHESOURCES")
+	if err != nil {
+		return err
+	}
+	for _, s := range vulnSources {
+		if len(only) > 0 && !only[s.name] {
+			continue
+		}
+		vs, err := s.fetch()
+		if err != nil {
+			return fmt.Errorf("%v: %v", s.name, err)
+		}
+		for _, n := range vs.Notes {
+			log.Printf("%v: %v\n", s.name, n)
+		}
+		buf, err := json.Marshal(vs)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(path.Join(cfg.cacheDir, s.cacheFile), buf, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadVulns reads the advisories of each source in cfg.vulnSources, or of
+// every source if it is empty, from the cache directory. It fails if any of
+// them is missing from the cache, or if they hold no advisories at all, as
+// every record would then be reported as not vulnerable.
+func loadVulns() (ret []database.VulnerabilityWithAffected, err error) {
+	for _, s := range vulnSources {
+		if len(cfg.vulnSources) > 0 && !cfg.vulnSources[s.name] {
+			continue
+		}
+		buf, err := ioutil.ReadFile(path.Join(cfg.cacheDir, s.cacheFile))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("no %v advisories in cache %v", s.name, cfg.cacheDir)
+			}
+			return nil, err
+		}
+		var vs vulnsrc.UpdateResponse
+		err = json.Unmarshal(buf, &vs)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", s.cacheFile, err)
+		}
+		log.Printf("loaded %v %v advisories\n", len(vs.Vulnerabilities), s.name)
+		ret = append(ret, vs.Vulnerabilities...)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no advisories in cache %v", cfg.cacheDir)
+	}
+	return ret, nil
+}
+
+// vulnNamespace returns the namespace in the advisory data that applies to
+// dist
+func vulnNamespace(dist string) (string, bool) {
+	if ns, ok := rhelNamespace(dist); ok {
+		return ns, true
+	}
+	for _, prefix := range []string{"oracle:", "amzn:", "debian:", "ubuntu:", "alpine:"} {
+		if strings.HasPrefix(dist, prefix) {
+			return dist, true
+		}
+	}
+	return "", false
+}
+
+// testVersion compares version a against b using the scribe comparison op,
+// with the rules of the version format of the advisory namespace. Advisories
+// in dpkg and apk namespaces are compared with dpkg and apk rules, language
+// packages with the rules of their ecosystem, and all others as rpm versions.
+//
+// As rpm compares releases segment by segment, the .elN_M suffixes of RHEL
+// and Oracle releases compare by minor release, so 9.el8_10 is greater than
+// 9.el8_9, and a release without the suffix is lower than one with it, so 9.el8
+// is lower than 9.el8_7. Oracle rebuilds of RHEL packages insert segments
+// before the suffix, so 9.0.1.el8_7 is greater than 9.el8_7. In Oracle
+// namespaces ksplice builds are only compared with other ksplice builds.
+func testVersion(format string, op int, a, b string) (bool, error) {
+	var (
+		c   int
+		err error
+	)
+	switch format {
+	case dpkg.ParserName:
+		c, err = versionfmt.Compare(format, a, b)
+	case apkVersionFormat:
+		c, err = compareApkVersions(a, b)
+	case pep440VersionFormat:
+		c, err = comparePEP440(a, b)
+	case semverVersionFormat:
+		c, err = compareSemver(a, b)
+	case gemVersionFormat:
+		c, err = compareGemVersions(a, b)
+	case oracleVersionFormat:
+		if isKsplice(a) != isKsplice(b) {
+			// A ksplice fix only applies to ksplice builds of the
+			// package, and other fixes only to other builds
+			return false, nil
+		}
+		return scribe.TestEvrCompare(op, a, b)
+	default:
+		return scribe.TestEvrCompare(op, a, b)
+	}
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case scribe.EvropEquals:
+		return c == 0, nil
+	case scribe.EvropGreaterThan:
+		return c > 0, nil
+	case scribe.EvropLessThan:
+		return c < 0, nil
+	}
+	return false, fmt.Errorf("unknown version comparison %v", op)
+}
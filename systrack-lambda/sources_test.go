@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/coreos/clair/ext/versionfmt/rpm"
+	"github.com/mozilla/scribe"
+)
+
+func TestLoadVulns(t *testing.T) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	dir, err := ioutil.TempDir("", "systrack-lambda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg.cacheDir = dir
+
+	// Only the osv source is in the cache
+	buf, err := ioutil.ReadFile("testdata/cache/osvdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(dir, "osvdata"), buf, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadVulns(); err == nil {
+		t.Error("no error with sources missing from the cache")
+	}
+	cfg.vulnSources = map[string]bool{"osv": true}
+	vulns, err := loadVulns()
+	if err != nil || len(vulns) == 0 {
+		t.Errorf("loading osv advisories: got %v advisories, %v", len(vulns), err)
+	}
+	cfg.vulnSources = map[string]bool{"osv": true, "debian": true}
+	if _, err := loadVulns(); err == nil {
+		t.Error("no error with the debian source missing from the cache")
+	}
+
+	// A cache holding no advisories is refused
+	err = ioutil.WriteFile(path.Join(dir, "osvdata"), []byte(`{"Vulnerabilities":[]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.vulnSources = map[string]bool{"osv": true}
+	if _, err := loadVulns(); err == nil {
+		t.Error("no error with no advisories in the cache")
+	}
+}
+
+func TestTestVersion(t *testing.T) {
+	tests := []struct {
+		format string
+		a, b   string
+		want   bool // a is greater than b
+	}{
+		// Releases compare by the minor release in the .elN_M suffix
+		{oracleVersionFormat, "1:1.1.1k-9.el8_10", "1:1.1.1k-9.el8_9", true},
+		{oracleVersionFormat, "1:1.1.1k-9.el8_7", "1:1.1.1k-9.el8", true},
+		{oracleVersionFormat, "1:1.1.1k-9.el8_7", "1:1.1.1k-9.el8_7", false},
+		{oracleVersionFormat, "1:1.1.1k-12.el8_9", "1:1.1.1k-9.el8_7", true},
+		// Oracle rebuilds of RHEL packages are greater than the RHEL build
+		{oracleVersionFormat, "1:1.1.1k-9.0.1.el8_7", "1:1.1.1k-9.el8_7", true},
+		{oracleVersionFormat, "2.17-326.0.5.el7_9", "2.17-326.0.3.el7_9", true},
+		{oracleVersionFormat, "2.17-326.0.5.el7_9.1", "2.17-326.0.5.el7_9", true},
+		// Ksplice builds are only compared with other ksplice builds in
+		// Oracle namespaces
+		{oracleVersionFormat, "2:2.17-326.0.5.ksplice1.el7_9", "2:2.17-326.0.1.ksplice1.el7_9", true},
+		{oracleVersionFormat, "2:2.17-326.0.5.ksplice1.el7_9", "2:2.17-326.0.1.el7_9", false},
+		{oracleVersionFormat, "2:2.17-326.0.5.el7_9", "2:2.17-326.0.1.ksplice1.el7_9", false},
+		{rpm.ParserName, "2:2.17-326.0.5.ksplice1.el7_9", "2:2.17-326.0.1.el7_9", true},
+		{rpm.ParserName, "1:1.1.1k-9.el8_10", "1:1.1.1k-9.el8_9", true},
+	}
+	for _, tc := range tests {
+		got, err := testVersion(tc.format, scribe.EvropGreaterThan, tc.a, tc.b)
+		if err != nil {
+			t.Errorf("%v %v %v: %v", tc.format, tc.a, tc.b, err)
+		} else if got != tc.want {
+			t.Errorf("%v: %v greater than %v is %v, want %v", tc.format, tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2017 clair authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This is a slightly modified version of the Ubuntu CVE Tracker parser from
+// the clair project. The tracker is now a git repository rather than bzr, only
+// released fixes are kept, and releases are mapped to versions here as the
+// clair mapping stops at artful.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/coreos/clair/database"
+	"github.com/coreos/clair/ext/versionfmt"
+	"github.com/coreos/clair/ext/versionfmt/dpkg"
+	"github.com/coreos/clair/ext/vulnsrc"
+	"github.com/coreos/clair/ext/vulnsrc/ubuntu"
+	"github.com/coreos/clair/pkg/commonerr"
+)
+
+const (
+	ubuntuTrackerRepository = "https://git.launchpad.net/ubuntu-cve-tracker"
+	ubuntuCVEURI            = "https://ubuntu.com/security/%s"
+	ubuntuUpdaterFlag       = "ubuntuUpdater"
+
+	// ubuntuMaxLineSize is the longest line read from a CVE file. Some
+	// descriptions and notes are a single line longer than the 64 KiB
+	// scanner default.
+	ubuntuMaxLineSize = 1024 * 1024
+)
+
+// ubuntuReleases maps Ubuntu code names to the version used in the namespace
+var ubuntuReleases = map[string]string{
+	"trusty":   "14.04",
+	"xenial":   "16.04",
+	"bionic":   "18.04",
+	"focal":    "20.04",
+	"jammy":    "22.04",
+	"noble":    "24.04",
+	"oracular": "24.10",
+	"plucky":   "25.04",
+	"questing": "25.10",
+}
+
+var ubuntuAffectsRegexp = regexp.MustCompile(`^(.*)_(.*): ([^\s]*)( \(+([^()]*)\)+)?`)
+
+func fetchUbuntu() (resp vulnsrc.UpdateResponse, err error) {
+	dir, err := ioutil.TempDir("", "ubuntu-cve-tracker")
+	if err != nil {
+		return resp, vulnsrc.ErrFilesystem
+	}
+	defer os.RemoveAll(dir)
+	cmd := exec.Command("git", "clone", "--depth", "1", ubuntuTrackerRepository, dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not clone Ubuntu CVE tracker: %v\n%s", err, out)
+		return resp, commonerr.ErrCouldNotDownload
+	}
+	return parseUbuntuTracker(dir)
+}
+
+// parseUbuntuTracker parses the CVE files in the active and retired
+// directories of a checkout of the Ubuntu CVE tracker
+func parseUbuntuTracker(dir string) (resp vulnsrc.UpdateResponse, err error) {
+	var paths []string
+	for _, folder := range []string{"active", "retired"} {
+		names, err := filepath.Glob(filepath.Join(dir, folder, "CVE-*"))
+		if err != nil {
+			return resp, vulnsrc.ErrFilesystem
+		}
+		paths = append(paths, names...)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fd, err := os.Open(p)
+		if err != nil {
+			return resp, vulnsrc.ErrFilesystem
+		}
+		v, err := parseUbuntuCVE(fd)
+		// Closed here rather than deferred, as there are tens of thousands
+		// of files
+		fd.Close()
+		if err != nil {
+			return resp, err
+		}
+		if len(v.Affected) > 0 {
+			resp.Vulnerabilities = append(resp.Vulnerabilities, v)
+		}
+	}
+	resp.FlagName = ubuntuUpdaterFlag
+	return resp, nil
+}
+
+func parseUbuntuCVE(fileContent io.Reader) (vulnerability database.VulnerabilityWithAffected, err error) {
+	readingDescription := false
+	scanner := bufio.NewScanner(fileContent)
+	scanner.Buffer(make([]byte, 0, 64*1024), ubuntuMaxLineSize)
+
+	// Only unique major releases will be considered. All sub releases' (e.g.
+	// esm-infra/xenial) features are considered to belong to major releases.
+	uniqueRelease := map[string]bool{}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip any comments.
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Parse the name.
+		if strings.HasPrefix(line, "Candidate:") {
+			vulnerability.Name = strings.TrimSpace(strings.TrimPrefix(line, "Candidate:"))
+			vulnerability.Link = fmt.Sprintf(ubuntuCVEURI, vulnerability.Name)
+			continue
+		}
+
+		// Parse the priority.
+		if strings.HasPrefix(line, "Priority:") {
+			priority := strings.TrimSpace(strings.TrimPrefix(line, "Priority:"))
+
+			// Handle syntax error: Priority: medium (heap-protector)
+			if strings.Contains(priority, " ") {
+				priority = priority[:strings.Index(priority, " ")]
+			}
+
+			vulnerability.Severity = ubuntu.SeverityFromPriority(priority)
+			continue
+		}
+
+		// Parse the description.
+		if strings.HasPrefix(line, "Description:") {
+			readingDescription = true
+			vulnerability.Description = strings.TrimSpace(strings.TrimPrefix(line, "Description:"))
+			continue
+		}
+		if readingDescription {
+			if strings.HasPrefix(line, "Ubuntu-Description:") || strings.HasPrefix(line, "Notes:") ||
+				strings.HasPrefix(line, "Bugs:") || strings.HasPrefix(line, "Priority:") ||
+				strings.HasPrefix(line, "Discovered-by:") || strings.HasPrefix(line, "Assigned-to:") {
+				readingDescription = false
+			} else {
+				vulnerability.Description = vulnerability.Description + " " + line
+				continue
+			}
+		}
+
+		// Try to parse the package that the vulnerability affects, such
+		// as "jammy_openssl: released (3.0.2-0ubuntu1.8)". Only released
+		// fixes are considered, as packages are reported by comparing them
+		// against the fixed version.
+		m := ubuntuAffectsRegexp.FindStringSubmatch(line)
+		if m == nil || m[3] != "released" {
+			continue
+		}
+		pkgName, version := strings.TrimSpace(m[2]), strings.TrimSpace(m[5])
+
+		// Ignore Linux kernels.
+		if strings.HasPrefix(pkgName, "linux") || version == "" {
+			continue
+		}
+		var release string
+		for _, r := range strings.Split(m[1], "/") {
+			if v, ok := ubuntuReleases[r]; ok {
+				release = "ubuntu:" + v
+				break
+			}
+		}
+		if release == "" {
+			continue
+		}
+		err := versionfmt.Valid(dpkg.ParserName, version)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not parse package version %v. skipping\n", version)
+			continue
+		}
+		if uniqueRelease[release+"_:_"+pkgName] {
+			continue
+		}
+		uniqueRelease[release+"_:_"+pkgName] = true
+
+		vulnerability.Affected = append(vulnerability.Affected, database.AffectedFeature{
+			Namespace: database.Namespace{
+				Name:          release,
+				VersionFormat: dpkg.ParserName,
+			},
+			FeatureName:     pkgName,
+			AffectedVersion: version,
+			FixedInVersion:  version,
+		})
+	}
+	if scanner.Err() != nil {
+		return vulnerability, commonerr.ErrCouldNotParse
+	}
+
+	// Trim extra spaces in the description
+	vulnerability.Description = strings.TrimSpace(vulnerability.Description)
+
+	// If no priority has been provided, set the priority to Unknown
+	if vulnerability.Severity == "" {
+		vulnerability.Severity = database.UnknownSeverity
+	}
+
+	return
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUbuntuCVE(t *testing.T) {
+	// The description is a single line longer than the default scanner
+	// buffer
+	long := strings.Repeat("A use-after-free in the TLS handshake. ", 2000)
+	cve := `Candidate: CVE-2024-0001
+PublicDate: 2024-01-02
+References:
+ https://www.openssl.org/news/secadv/20240102.txt
+Description:
+ ` + long + `
+Ubuntu-Description:
+Notes:
+Priority: high (wide impact)
+
+Patches_openssl:
+upstream_openssl: released (3.0.13)
+jammy_openssl: released (3.0.2-0ubuntu1.14)
+esm-infra/xenial_openssl: released (1.0.2g-1ubuntu4.20+esm11)
+xenial_openssl: released (1.0.2g-1ubuntu4.20+esm12)
+noble_openssl: not-affected (3.0.13-0ubuntu2)
+jammy_linux: released (5.15.0-91.101)
+`
+	v, err := parseUbuntuCVE(strings.NewReader(cve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "CVE-2024-0001" || v.Severity != "High" || v.Description != strings.TrimSpace(long) {
+		t.Errorf("got %v %v with a %v byte description", v.Name, v.Severity, len(v.Description))
+	}
+	// The first fix listed for a release is kept, and kernels and releases
+	// that are not affected are skipped
+	want := []string{
+		"ubuntu:22.04 openssl 3.0.2-0ubuntu1.14",
+		"ubuntu:16.04 openssl 1.0.2g-1ubuntu4.20+esm11",
+	}
+	var got []string
+	for _, a := range v.Affected {
+		got = append(got, a.Namespace.Name+" "+a.FeatureName+" "+a.FixedInVersion)
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/coreos/clair/database"
+)
+
+// vulnEntry is a package affected by a vulnerability, and the version the
+// vulnerability is fixed in
+type vulnEntry struct {
+	vuln       *database.VulnerabilityWithAffected
+	format     string // Version format of the namespace, such as rpm or dpkg
+	fixed      string
+	introduced string // First affected version, if not every earlier version is
+}
+
+// newVulnEntry returns the entry for package w affected by vulnerability v.
+// Sources that give ranges of affected versions, such as OSV, store the start
+// of the range as the affected version, where others store the fixed version.
+func newVulnEntry(v *database.VulnerabilityWithAffected, w database.AffectedFeature) vulnEntry {
+	e := vulnEntry{
+		vuln:   v,
+		format: w.Namespace.VersionFormat,
+		fixed:  w.FixedInVersion,
+	}
+	if w.AffectedVersion != w.FixedInVersion && w.AffectedVersion != "0" {
+		e.introduced = w.AffectedVersion
+	}
+	return e
+}
+
+// vulnIndex holds the affected packages of the loaded vulnerabilities by
+// namespace and then package name, so checking a package does not need to
+// scan every advisory
+type vulnIndex map[string]map[string][]vulnEntry
+
+// newVulnIndex indexes vulns. The entries for each package are in the order
+// they appear in vulns, so results come out in the same order as scanning
+// vulns in full.
+func newVulnIndex(vulns []database.VulnerabilityWithAffected) vulnIndex {
+	ret := make(vulnIndex)
+	for i := range vulns {
+		for _, w := range vulns[i].Affected {
+			pkgs, ok := ret[w.Namespace.Name]
+			if !ok {
+				pkgs = make(map[string][]vulnEntry)
+				ret[w.Namespace.Name] = pkgs
+			}
+			pkgs[w.FeatureName] = append(pkgs[w.FeatureName], newVulnEntry(&vulns[i], w))
+		}
+	}
+	return ret
+}
+
+// lookup returns the vulnerabilities affecting package name in namespace ns
+func (x vulnIndex) lookup(ns, name string) []vulnEntry {
+	return x[ns][name]
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/clair/database"
+	"github.com/mozilla/scribe"
+)
+
+// lookupRecord is a package record, the record with the version of its source
+// package, and the advisory namespace and package name it is looked up under
+type lookupRecord struct {
+	p, src   pkgLogEnt
+	ns, name string
+}
+
+// loadTestCache loads the advisories in testdata/cache into cfg, returning
+// the package records in testdata/records.json to look up against them
+func loadTestCache(tb testing.TB) []lookupRecord {
+	cfg.cacheDir = "testdata/cache"
+	cfg.sigPolicy = sigPolicyIgnore
+	vulns, err := loadVulns()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cfg.vulns, cfg.vulnIndex = vulns, newVulnIndex(vulns)
+
+	fd, err := os.Open("testdata/records.json")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer fd.Close()
+	var ret []lookupRecord
+	scn := bufio.NewScanner(fd)
+	for scn.Scan() {
+		r, _, err := parseRecord(scn.Bytes())
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for _, p := range r.expand() {
+			if eco, ok := langPkgTypes[p.Fields.PkgType]; ok {
+				ret = append(ret, lookupRecord{p, p, osvNamespace(eco),
+					osvPackageName(eco, p.Fields.PkgName)})
+				continue
+			}
+			ns, ok := vulnNamespace(p.Fields.Dist)
+			if !ok {
+				tb.Fatalf("unsupported dist %v", p.Fields.Dist)
+			}
+			name, src := p.sourcePackage()
+			ret = append(ret, lookupRecord{p, src, ns, name})
+		}
+	}
+	if err := scn.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return ret
+}
+
+// baselineScan returns the names of the vulnerabilities affecting the package
+// record p, found as checkVuln did before advisories were indexed, by walking
+// the affected packages of every advisory for those in namespace ns with
+// package name. It is kept apart from the vulnEntry and affected helpers the
+// index uses. Versions are compared with testVersion rather than as rpm
+// versions, and before the start of an OSV range are not affected, as the
+// sources added since the index need.
+func baselineScan(vulns []database.VulnerabilityWithAffected, p pkgLogEnt, ns, name string) (ret []string, err error) {
+	for _, v := range vulns {
+		for _, w := range v.Affected {
+			if ns != w.Namespace.Name {
+				continue
+			}
+			if w.FeatureName != name {
+				continue
+			}
+			format := w.Namespace.VersionFormat
+			if w.AffectedVersion != w.FixedInVersion && w.AffectedVersion != "0" {
+				f, err := testVersion(format, scribe.EvropGreaterThan, w.AffectedVersion,
+					p.Fields.PkgVersion)
+				if err != nil {
+					return nil, err
+				}
+				if f {
+					continue
+				}
+			}
+			f, err := testVersion(format, scribe.EvropGreaterThan, w.FixedInVersion,
+				p.Fields.PkgVersion)
+			if err != nil {
+				return nil, err
+			}
+			if f {
+				ret = append(ret, v.Name)
+			}
+		}
+	}
+	return
+}
+
+// baselineNamespace returns the namespace the baseline matched records of
+// dist against. Before the index only centos:N hosts were checked, with the
+// dist used as the namespace. RHEL and its rebuilds have since been checked
+// against the same centos:N advisories, so rhel:N, rocky:N and alma:N map to
+// them.
+func baselineNamespace(dist string) string {
+	for _, prefix := range []string{"rhel:", "rocky:", "alma:"} {
+		if strings.HasPrefix(dist, prefix) {
+			return "centos:" + strings.TrimPrefix(dist, prefix)
+		}
+	}
+	return dist
+}
+
+// matchVulns returns the names of the vulnerabilities in entries that affect
+// the package described by p
+func matchVulns(p pkgLogEnt, entries []vulnEntry) (ret []string, err error) {
+	for _, e := range entries {
+		f, err := affected(p, e)
+		if err != nil {
+			return nil, err
+		}
+		if f {
+			ret = append(ret, e.vuln.Name)
+		}
+	}
+	return
+}
+
+func TestIndexMatchesScan(t *testing.T) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	recs := loadTestCache(t)
+	cfg.outputFormat = 1
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	found := 0
+	for _, r := range recs {
+		ns := r.ns
+		if _, ok := langPkgTypes[r.p.Fields.PkgType]; !ok {
+			ns = baselineNamespace(r.p.Fields.Dist)
+		}
+		want, err := baselineScan(cfg.vulns, r.src, ns, r.name)
+		if err != nil {
+			t.Fatalf("%v %v: %v", r.name, r.p.Fields.PkgVersion, err)
+		}
+		// The vulnerability is the ninth column of the findings
+		var got []string
+		for _, l := range checkVuln(r.p) {
+			got = append(got, strings.Split(l, "\t")[8])
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v %v on %v: checkVuln found %v, scan found %v", r.p.Fields.PkgName,
+				r.p.Fields.PkgVersion, r.p.Hostname, got, want)
+		}
+		found += len(got)
+	}
+	// Most of the records are vulnerable, so the comparison is not of
+	// empty results
+	if found < len(recs)/2 {
+		t.Errorf("%v findings for %v records", found, len(recs))
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	recs := loadTestCache(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, r := range recs {
+			_, err := matchVulns(r.src, cfg.vulnIndex.lookup(r.ns, r.name))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	recs := loadTestCache(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, r := range recs {
+			_, err := baselineScan(cfg.vulns, r.src, r.ns, r.name)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}